@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jawher/mow.cli"
 	"github.com/kr/pretty"
+	"github.com/pborman/uuid"
 
 	"github.com/Financial-Times/go-logger"
 	"github.com/Financial-Times/nativerw/pkg/config"
 	"github.com/Financial-Times/nativerw/pkg/db"
+	"github.com/Financial-Times/nativerw/pkg/outbox"
 	"github.com/Financial-Times/nativerw/pkg/resources"
 	status "github.com/Financial-Times/service-status-go/httphandlers"
 )
@@ -19,6 +23,14 @@ import (
 const (
 	appName        = "nativerw"
 	appDescription = "Writes any raw content/data from native CMS in mongoDB without transformation."
+
+	tombstonePurgeInterval = time.Hour
+	// blobs uploaded more recently may belong to writes which are still going on
+	blobPurgeDelay = time.Hour
+
+	// the jobs run on startup are leased to a single instance, and cut off after a while, to carry on with the next startup
+	jobLease   = time.Minute
+	jobTimeout = time.Hour
 )
 
 func main() {
@@ -26,7 +38,7 @@ func main() {
 	mongos := cliApp.String(cli.StringOpt{
 		Name:   "mongos",
 		Value:  "",
-		Desc:   "Mongo addresses to connect to in format: host1:port1[,host2:port2,...]",
+		Desc:   "Mongo addresses to connect to in format: host1:port1[,host2:port2,...], or a mongodb:// or mongodb+srv:// connection string",
 		EnvVar: "MONGOS",
 	})
 
@@ -37,6 +49,13 @@ func main() {
 		EnvVar: "MONGO_NODE_COUNT",
 	})
 
+	backend := cliApp.String(cli.StringOpt{
+		Name:   "db_backend",
+		Value:  "",
+		Desc:   "Storage backend for native documents, either mongo or memory (overrides the backend in the config file, defaults to mongo)",
+		EnvVar: "DB_BACKEND",
+	})
+
 	configFile := cliApp.String(cli.StringOpt{
 		Name:   "config",
 		Value:  "configs/config.json",
@@ -52,16 +71,35 @@ func main() {
 			logger.WithError(err).Fatal("Error reading the configuration")
 		}
 
-		if err = db.CheckMongoUrls(*mongos, *mongoNodeCount); err != nil {
-			logger.WithError(err).Fatalf("Provided mongoDB urls %s are invalid", *mongos)
+		if *backend != "" {
+			conf.Backend = *backend
+		}
+		if conf.Backend == "" {
+			conf.Backend = db.MongoBackend
+		}
+
+		if conf.Backend == db.MongoBackend {
+			if err = db.CheckMongoUrls(*mongos, *mongoNodeCount); err != nil {
+				logger.WithError(err).Fatalf("Provided mongoDB urls %s are invalid", *mongos)
+			}
+			conf.Mongos = *mongos
 		}
 
-		conf.Mongos = *mongos
 		logger.Infof("Using configuration %# v", pretty.Formatter(conf))
 
+		mongo, err := db.New(conf)
+		if err != nil {
+			logger.WithError(err).Fatal("Error setting up the db backend")
+		}
+
+		sink, err := outbox.NewSink(conf.Outbox)
+		if err != nil {
+			logger.WithError(err).Fatal("Error setting up the outbox sink")
+		}
+		webhookTargets := outbox.NewWebhookTargets(conf.Webhooks.AllowedHosts)
+
 		logger.ServiceStartedEvent(conf.Server.Port)
-		mongo := db.NewDBConnection(conf)
-		router(mongo)
+		router(mongo, conf, webhookTargets)
 
 		go func() {
 			connection, mErr := mongo.Open()
@@ -73,8 +111,16 @@ func main() {
 				}
 			}
 
-			logger.Info("Established connection to mongoDB.")
+			logger.Infof("Established connection to the %s db backend.", conf.Backend)
 			connection.EnsureIndex()
+
+			runLeased(connection, "backfill-hashes", backfillHashes)
+			runLeased(connection, "reencode-content", reencodeContent)
+			if sink != nil {
+				go outbox.NewDispatcher(connection, sink).Run(context.Background())
+			}
+			go outbox.NewWebhooks(connection, webhookTargets).Run(context.Background())
+			purgeTombstones(connection, conf.RetentionPeriod, conf.ChangeRetentionPeriod())
 		}()
 
 		err = http.ListenAndServe(":"+strconv.Itoa(conf.Server.Port), nil)
@@ -89,15 +135,172 @@ func main() {
 	}
 }
 
-func router(mongo db.DB) {
+// backfillHashes stores the hashes of the documents written before they were, so that the hash check never needs to compute them
+func backfillHashes(ctx context.Context, connection db.Connection) {
+	for collection := range connection.GetSupportedCollections() {
+		backfilled, err := connection.BackfillHashes(ctx, collection)
+		if ctx.Err() != nil {
+			logger.Warnf("Stopped backfilling the hashes of collection %s after %d documents, the rest is left for the next startup", collection, backfilled)
+			return
+		}
+
+		if err != nil {
+			logger.WithError(err).Errorf("Failed to backfill hashes of collection %s", collection)
+			continue
+		}
+
+		if backfilled > 0 {
+			logger.Infof("Backfilled %d hashes of collection %s", backfilled, collection)
+		}
+	}
+}
+
+// runLeased runs the job in the background on the one instance which claims its lease, as every instance starts it, and renews the
+// lease while it runs. The job is cancelled once it has run for jobTimeout, or if the lease is lost.
+func runLeased(connection db.Connection, name string, job func(ctx context.Context, connection db.Connection)) {
+	host, _ := os.Hostname()
+	owner := host + "/" + uuid.New()
+	claim := func() error {
+		// job leases are kept with the ones of the outboxes, under names which can't clash with them
+		_, err := connection.ClaimOutbox("__job/"+name, owner, 0, time.Now().Add(jobLease))
+		return err
+	}
+
+	if err := claim(); err != nil {
+		if err != db.ErrOutboxClaimed {
+			logger.WithError(err).Errorf("Failed to claim the lease of job %s", name)
+		}
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+		defer cancel()
+
+		go func() {
+			ticker := time.NewTicker(jobLease / 3)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					if err := claim(); err != nil {
+						logger.WithError(err).Errorf("Lost the lease of job %s, stopping it", name)
+						cancel()
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		job(ctx, connection)
+	}()
+}
+
+// reencodeContent stores the documents written before their collection got its storage codec, or lost it, with the current one
+func reencodeContent(ctx context.Context, connection db.Connection) {
+	for collection := range connection.GetSupportedCollections() {
+		reencoded, err := connection.ReencodeContent(ctx, collection)
+		if ctx.Err() != nil {
+			logger.Warnf("Stopped re-encoding the content of collection %s after %d documents, the rest is left for the next startup", collection, reencoded)
+			return
+		}
+
+		if err != nil {
+			logger.WithError(err).Errorf("Failed to re-encode the content of collection %s", collection)
+			continue
+		}
+
+		if reencoded > 0 {
+			logger.Infof("Re-encoded the content of %d documents of collection %s", reencoded, collection)
+		}
+	}
+}
+
+// purgeTombstones periodically removes the deleted documents which can no longer be restored, after the retention of their collection,
+// then the blobs no document refers to anymore, and the changes older than the retention of the change feed
+func purgeTombstones(connection db.Connection, retention func(collection string) time.Duration, changeRetention time.Duration) {
+	ticker := time.NewTicker(tombstonePurgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for collection := range connection.GetSupportedCollections() {
+			deletedBefore := time.Now().Add(-retention(collection))
+			purged, err := connection.PurgeTombstones(collection, deletedBefore)
+			if err != nil {
+				logger.WithError(err).Errorf("Failed to purge tombstones from collection %s", collection)
+				continue
+			}
+
+			if purged > 0 {
+				logger.Infof("Purged %d tombstones deleted before %s from collection %s", purged, deletedBefore.Format(time.RFC3339), collection)
+			}
+
+			purgeBlobs(connection, collection)
+			purgeChanges(connection, collection, changeRetention)
+		}
+	}
+}
+
+func purgeChanges(connection db.Connection, collection string, retention time.Duration) {
+	recordedBefore := time.Now().Add(-retention)
+	purged, err := connection.PurgeChanges(collection, recordedBefore)
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to purge changes from collection %s", collection)
+		return
+	}
+
+	if purged > 0 {
+		logger.Infof("Purged %d changes recorded before %s from collection %s", purged, recordedBefore.Format(time.RFC3339), collection)
+	}
+}
+
+func purgeBlobs(connection db.Connection, collection string) {
+	uploadedBefore := time.Now().Add(-blobPurgeDelay)
+	purged, err := connection.PurgeBlobs(collection, uploadedBefore)
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to purge blobs from collection %s", collection)
+		return
+	}
+
+	if purged > 0 {
+		logger.Infof("Purged %d unreferenced blobs uploaded before %s from collection %s", purged, uploadedBefore.Format(time.RFC3339), collection)
+	}
+}
+
+func router(mongo db.DB, conf *config.Configuration, webhookTargets *outbox.WebhookTargets) {
 	r := mux.NewRouter()
 
-	r.HandleFunc("/{collection}/__ids", resources.Filter(resources.ReadIDs(mongo)).ValidateAccessForCollection(mongo).Build()).Methods("GET")
+	// registered first, so that the collections and subscriptions are not mistaken for a collection
+	r.HandleFunc("/__collections", resources.ReadCollections(mongo)).Methods("GET")
+	r.HandleFunc("/__collections", resources.RegisterCollection(mongo)).Methods("POST")
+	r.HandleFunc("/__collections/{name}", resources.UnregisterCollection(mongo)).Methods("DELETE")
+
+	r.HandleFunc("/__subscriptions", resources.CreateSubscription(mongo, webhookTargets)).Methods("POST")
+	r.HandleFunc("/__subscriptions", resources.ReadSubscriptions(mongo)).Methods("GET")
+	r.HandleFunc("/__subscriptions/{id}", resources.ReadSubscription(mongo)).Methods("GET")
+	r.HandleFunc("/__subscriptions/{id}", resources.DeleteSubscription(mongo)).Methods("DELETE")
+	r.HandleFunc("/__subscriptions/{id}/deliveries", resources.ReadDeliveries(mongo)).Methods("GET")
+
+	r.HandleFunc("/{collection}/__ids", resources.Filter(resources.ReadIDs(mongo)).ValidateAccessForCollection(mongo).EncodeContent(conf).Build()).Methods("GET")
+	r.HandleFunc("/{collection}/__changes", resources.Filter(resources.ReadChanges(mongo)).ValidateAccessForCollection(mongo).Build()).Methods("GET")
+	r.HandleFunc("/{collection}/__stats", resources.Filter(resources.ReadStats(mongo)).ValidateAccessForCollection(mongo).Build()).Methods("GET")
+	r.HandleFunc("/{collection}/__batch-read", resources.Filter(resources.BatchRead(mongo)).ValidateAccessForCollection(mongo).EncodeContent(conf).Build()).Methods("POST")
+	r.HandleFunc("/{collection}/__bulk", resources.Filter(resources.BulkWrite(mongo)).EnforcePolicy(conf).ValidateAccessForCollection(mongo).Build()).Methods("POST")
+
+	r.HandleFunc("/{collection}/{resource}/__versions", resources.Filter(resources.ReadVersions(mongo)).ValidateAccess(mongo).Build()).Methods("GET")
+	r.HandleFunc("/{collection}/{resource}/__versions/{version}", resources.Filter(resources.ReadVersion(mongo)).ValidateAccess(mongo).Build()).Methods("GET")
+	r.HandleFunc("/{collection}/{resource}/__restore", resources.Filter(resources.RestoreContent(mongo)).EnforcePolicy(conf).ValidateAccess(mongo).Build()).Methods("POST")
+	r.HandleFunc("/{collection}/{resource}/__undo", resources.Filter(resources.UndoContent(mongo)).EnforcePolicy(conf).ValidateAccess(mongo).Build()).Methods("POST")
+
+	r.HandleFunc("/{collection}/{resource}", resources.Filter(resources.ReadContent(mongo)).ValidateAccess(mongo).EncodeContent(conf).Build()).Methods("GET", "HEAD")
+	r.HandleFunc("/{collection}/{resource}", resources.Filter(resources.WriteContent(mongo)).EnforcePolicy(conf).DecodeContent().ValidateAccess(mongo).CheckNativeHash(mongo).Build()).Methods("PUT")
+	r.HandleFunc("/{collection}/{resource}", resources.Filter(resources.PatchContent(mongo)).EnforcePolicy(conf).DecodeContent().ValidateAccess(mongo).CheckNativeHash(mongo).Build()).Methods("PATCH")
+	r.HandleFunc("/{collection}/{resource}", resources.Filter(resources.DeleteContent(mongo)).EnforcePolicy(conf).ValidateAccess(mongo).Build()).Methods("DELETE")
 
-	r.HandleFunc("/{collection}/{resource}", resources.Filter(resources.ReadContent(mongo)).ValidateAccess(mongo).Build()).Methods("GET")
-	r.HandleFunc("/{collection}/{resource}", resources.Filter(resources.WriteContent(mongo)).ValidateAccess(mongo).CheckNativeHash(mongo).Build()).Methods("PUT")
-	r.HandleFunc("/{collection}/{resource}", resources.Filter(resources.PatchContent(mongo)).ValidateAccess(mongo).CheckNativeHash(mongo).Build()).Methods("PATCH")
-	r.HandleFunc("/{collection}/{resource}", resources.Filter(resources.DeleteContent(mongo)).ValidateAccess(mongo).Build()).Methods("DELETE")
+	r.HandleFunc("/__hash", resources.ComputeHash()).Methods("GET", "POST")
 
 	r.HandleFunc("/__health", resources.Healthchecks(mongo))
 	r.HandleFunc(status.GTGPath, status.NewGoodToGoHandler(resources.GoodToGo(mongo)))
@@ -2,10 +2,30 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"time"
 )
 
+// DefaultTombstoneRetention is how long deleted documents can be restored for, unless configured otherwise
+const DefaultTombstoneRetention = 7 * 24 * time.Hour
+
+// DefaultChangeRetention is how long the changes of the change feed are kept for, unless configured otherwise
+const DefaultChangeRetention = 7 * 24 * time.Hour
+
+// DefaultBlobThreshold is the size from which octet streams are stored in GridFS, unless configured otherwise. It leaves room
+// for the rest of the document within the 16MB mongo documents are limited to.
+const DefaultBlobThreshold = 8 << 20
+
+// DefaultCompressionThreshold is the size from which responses are compressed, unless configured otherwise
+const DefaultCompressionThreshold = 1 << 10
+
+// DefaultStorageCodecThreshold is the size, encoded in bson, from which the content of documents with a storage codec is stored
+// compressed, unless configured otherwise
+const DefaultStorageCodecThreshold = 1 << 10
+
 // Server config struct
 type Server struct {
 	Port int `json:"port"`
@@ -13,14 +33,107 @@ type Server struct {
 
 // Configuration data
 type Configuration struct {
-	Mongos      string   `json:"mongos"`
-	DbName      string   `json:"dbName"`
-	Server      Server   `json:"server"`
-	Collections []string `json:"collections"`
+	Backend     string         `json:"backend"`
+	Mongos      string         `json:"mongos"`
+	DbName      string         `json:"dbName"`
+	Server      Server         `json:"server"`
+	Collections []string       `json:"collections"`
+	History     map[string]int `json:"history"` // max number of revisions kept per collection, no history is kept for absent collections
+
+	TombstoneRetention Duration           `json:"tombstoneRetention"`
+	ChangeRetention    Duration           `json:"changeRetention"` // of the change feed, short of the changes not delivered yet
+	Outbox             Outbox             `json:"outbox"`
+	Webhooks           Webhooks           `json:"webhooks"`
+	Policies           map[string]*Policy `json:"policies"`      // by collection, collections without one accept any write
+	MaxBodySize        int64              `json:"maxBodySize"`   // in bytes, of a document of any collection, unless its policy says otherwise
+	BlobThreshold      int64              `json:"blobThreshold"` // size in bytes from which octet streams are stored in GridFS
+
+	CompressionThreshold  int64 `json:"compressionThreshold"`  // size in bytes from which responses are compressed
+	StorageCodecThreshold int64 `json:"storageCodecThreshold"` // size in bytes of bson from which content is stored compressed
+}
+
+// Outbox configures the delivery of the changes of the supported collections to a message queue
+type Outbox struct {
+	Sink       string `json:"sink"`       // kafka, file or inprocess, no changes are delivered without one
+	KafkaProxy string `json:"kafkaProxy"` // address of the kafka REST proxy, for the kafka sink
+	Topic      string `json:"topic"`      // topic of the kafka sink
+	File       string `json:"file"`       // path of the file sink
+}
+
+// Webhooks configures the calls to the webhooks of the subscriptions
+type Webhooks struct {
+	AllowedHosts []string `json:"allowedHosts"` // hosts which may be loopback, private or link-local addresses, none by default
 }
 
-// ReadConfigFromReader reads config as a json stream from the given reader
+// Duration reads a time.Duration from a json string like "72h"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses the duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = duration
+	return nil
+}
+
+// TombstoneRetentionPeriod returns the configured retention of deleted documents, or the default one
+func (c *Configuration) TombstoneRetentionPeriod() time.Duration {
+	if c.TombstoneRetention.Duration <= 0 {
+		return DefaultTombstoneRetention
+	}
+	return c.TombstoneRetention.Duration
+}
+
+// ChangeRetentionPeriod returns the configured retention of the changes of the change feed, or the default one
+func (c *Configuration) ChangeRetentionPeriod() time.Duration {
+	if c.ChangeRetention.Duration <= 0 {
+		return DefaultChangeRetention
+	}
+	return c.ChangeRetention.Duration
+}
+
+// BlobSizeThreshold returns the configured size from which octet streams are stored in GridFS, or the default one
+func (c *Configuration) BlobSizeThreshold() int64 {
+	if c.BlobThreshold <= 0 {
+		return DefaultBlobThreshold
+	}
+	return c.BlobThreshold
+}
+
+// CompressionSizeThreshold returns the configured size from which responses are compressed, or the default one
+func (c *Configuration) CompressionSizeThreshold() int64 {
+	if c.CompressionThreshold <= 0 {
+		return DefaultCompressionThreshold
+	}
+	return c.CompressionThreshold
+}
+
+// StorageCodecSizeThreshold returns the configured size from which the content of documents with a storage codec is stored
+// compressed, or the default one. Lowering it makes the next re-encoding job compress the stored content it now covers, while
+// raising it leaves the content already compressed as it is.
+func (c *Configuration) StorageCodecSizeThreshold() int64 {
+	if c.StorageCodecThreshold <= 0 {
+		return DefaultStorageCodecThreshold
+	}
+	return c.StorageCodecThreshold
+}
+
+// ReadConfigFromReader reads config as a json stream from the given reader. Schema files are read relative to the working directory.
 func ReadConfigFromReader(r io.Reader) (c *Configuration, e error) {
+	return readConfig(r, "")
+}
+
+func readConfig(r io.Reader, dir string) (c *Configuration, e error) {
 	c = new(Configuration)
 
 	decoder := json.NewDecoder(r)
@@ -29,6 +142,14 @@ func ReadConfigFromReader(r io.Reader) (c *Configuration, e error) {
 		return nil, e
 	}
 
+	if c.MaxBodySize < 0 {
+		return nil, fmt.Errorf("invalid maxBodySize %d", c.MaxBodySize)
+	}
+
+	if e = c.compilePolicies(dir); e != nil {
+		return nil, e
+	}
+
 	return c, nil
 }
 
@@ -45,7 +166,7 @@ func ReadConfig(confPath string) (*Configuration, error) {
 		return nil, fErr
 	}
 
-	c, fErr := ReadConfigFromReader(file)
+	c, fErr := readConfig(file, filepath.Dir(confPath))
 
 	return c, fErr
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
@@ -13,6 +14,7 @@ import (
 func TestConfigFromReader(t *testing.T) {
 	randomness := uuid.NewUUID().String()
 	reader := strings.NewReader(`{
+         "backend": "memory",
          "mongos": "` + randomness + `",
          "server": {
             "port": 8080
@@ -23,14 +25,19 @@ func TestConfigFromReader(t *testing.T) {
             "methode",
             "wordpress",
             "v1-metadata"
-         ]
+         ],
+         "history": {
+            "methode": 10
+         }
       }`)
 	config, err := ReadConfigFromReader(reader)
 
 	assert.NoError(t, err)
+	assert.Equal(t, "memory", config.Backend)
 	assert.Equal(t, randomness, config.Mongos)
 	assert.Equal(t, "native-store", config.DbName)
 	assert.Equal(t, []string{"video", "methode", "wordpress", "v1-metadata"}, config.Collections)
+	assert.Equal(t, map[string]int{"methode": 10}, config.History)
 	assert.Equal(t, 8080, config.Server.Port)
 }
 
@@ -71,3 +78,57 @@ func TestConfigFromFile(t *testing.T) {
 	assert.Equal(t, []string{"video", "methode", "wordpress", "v1-metadata"}, config.Collections)
 	assert.Equal(t, 8080, config.Server.Port)
 }
+
+func TestTombstoneRetention(t *testing.T) {
+	config, err := ReadConfigFromReader(strings.NewReader(`{"tombstoneRetention": "72h"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 72*time.Hour, config.TombstoneRetentionPeriod())
+
+	config, err = ReadConfigFromReader(strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultTombstoneRetention, config.TombstoneRetentionPeriod())
+
+	_, err = ReadConfigFromReader(strings.NewReader(`{"tombstoneRetention": "a while"}`))
+	assert.Error(t, err)
+}
+
+func TestChangeRetention(t *testing.T) {
+	config, err := ReadConfigFromReader(strings.NewReader(`{"changeRetention": "720h"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 720*time.Hour, config.ChangeRetentionPeriod())
+
+	config, err = ReadConfigFromReader(strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultChangeRetention, config.ChangeRetentionPeriod())
+}
+
+func TestBlobThreshold(t *testing.T) {
+	config, err := ReadConfigFromReader(strings.NewReader(`{"blobThreshold": 1048576}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1048576), config.BlobSizeThreshold())
+
+	config, err = ReadConfigFromReader(strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(DefaultBlobThreshold), config.BlobSizeThreshold())
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	config, err := ReadConfigFromReader(strings.NewReader(`{"compressionThreshold": 4096}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4096), config.CompressionSizeThreshold())
+
+	config, err = ReadConfigFromReader(strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(DefaultCompressionThreshold), config.CompressionSizeThreshold())
+}
+
+func TestStorageCodecThreshold(t *testing.T) {
+	config, err := ReadConfigFromReader(strings.NewReader(`{"compressionThreshold": 4096, "storageCodecThreshold": 512}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(512), config.StorageCodecSizeThreshold())
+	assert.Equal(t, int64(4096), config.CompressionSizeThreshold(), "the thresholds should be independent")
+
+	config, err = ReadConfigFromReader(strings.NewReader(`{"compressionThreshold": 4096}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(DefaultStorageCodecThreshold), config.StorageCodecSizeThreshold())
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Financial-Times/nativerw/pkg/schema"
+)
+
+// The modes of the schema of a collection
+const (
+	SchemaEnforce = "enforce"
+	SchemaWarn    = "warn"
+)
+
+// StorageCodecZstd compresses the content of the documents of a collection with zstd as they are stored
+const StorageCodecZstd = "zstd"
+
+// Policy restricts what a collection accepts. Every restriction is optional, and the zero policy accepts any write.
+type Policy struct {
+	ContentTypes    []string `json:"contentTypes"`    // media types of the documents, whatever their parameters
+	MaxBodySize     int64    `json:"maxBodySize"`     // in bytes, of a document
+	OriginSystemIDs []string `json:"originSystemIds"` // origin systems allowed to write
+	IDFormat        string   `json:"idFormat"`        // regular expression the uuids of the documents must match
+	ReadOnly        bool     `json:"readOnly"`
+	Retention       Duration `json:"retention"`    // of deleted documents, instead of tombstoneRetention
+	History         *int     `json:"history"`      // max number of revisions kept, instead of the one in history
+	StorageCodec    string   `json:"storageCodec"` // compressing the content of the documents as they are stored, none by default
+
+	Schema     json.RawMessage `json:"schema"`     // JSON Schema (draft 2020-12) the json documents must match
+	SchemaFile string          `json:"schemaFile"` // file of the schema instead, relative to the config file
+	SchemaMode string          `json:"schemaMode"` // enforce (the default) rejects the documents which don't match, warn logs them
+
+	idFormat          *regexp.Regexp
+	schema            *schema.Schema
+	sharedMaxBodySize int64 // the global maxBodySize, which applies when the policy has none
+}
+
+// AllowsContentType tells whether documents of the given content type can be written
+func (p *Policy) AllowsContentType(contentType string) bool {
+	if len(p.ContentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range p.ContentTypes {
+		if strings.EqualFold(allowed, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsOriginSystemID tells whether the given origin system can write
+func (p *Policy) AllowsOriginSystemID(originSystemID string) bool {
+	if len(p.OriginSystemIDs) == 0 {
+		return true
+	}
+
+	for _, allowed := range p.OriginSystemIDs {
+		if allowed == originSystemID {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsID tells whether a document can be written with the given uuid
+func (p *Policy) AllowsID(id string) bool {
+	return p.idFormat == nil || p.idFormat.MatchString(id)
+}
+
+// BodySizeLimit returns the max size in bytes of a document, from the policy or the global maxBodySize, or 0 if there is none
+func (p *Policy) BodySizeLimit() int64 {
+	if p.MaxBodySize > 0 {
+		return p.MaxBodySize
+	}
+	return p.sharedMaxBodySize
+}
+
+// ContentSchema returns the compiled schema of the json documents, or nil if they can be anything
+func (p *Policy) ContentSchema() *schema.Schema {
+	return p.schema
+}
+
+// WarnOnly tells whether the documents which don't match the schema are written anyway
+func (p *Policy) WarnOnly() bool {
+	return p.SchemaMode == SchemaWarn
+}
+
+// Policy returns the policy of the collection, or the zero one, but for the global maxBodySize, if it has none
+func (c *Configuration) Policy(collection string) *Policy {
+	if p, found := c.Policies[collection]; found && p != nil {
+		return p
+	}
+	return &Policy{sharedMaxBodySize: c.MaxBodySize}
+}
+
+// HistoryDepths returns the max number of revisions kept per collection, from history and the policies
+func (c *Configuration) HistoryDepths() map[string]int {
+	depths := make(map[string]int, len(c.History))
+	for collection, depth := range c.History {
+		depths[collection] = depth
+	}
+
+	for collection, p := range c.Policies {
+		if p != nil && p.History != nil {
+			depths[collection] = *p.History
+		}
+	}
+	return depths
+}
+
+// RetentionPeriod returns the retention of the deleted documents of the collection, from its policy or tombstoneRetention
+func (c *Configuration) RetentionPeriod(collection string) time.Duration {
+	if retention := c.Policy(collection).Retention.Duration; retention > 0 {
+		return retention
+	}
+	return c.TombstoneRetentionPeriod()
+}
+
+// StorageCodec returns the codec compressing the content of the documents of the collection, or "" if it is stored as it is
+func (c *Configuration) StorageCodec(collection string) string {
+	return c.Policy(collection).StorageCodec
+}
+
+// compilePolicies validates the policies, and compiles their id formats and schemas. Schema files are read relative to dir.
+func (c *Configuration) compilePolicies(dir string) error {
+	for collection, p := range c.Policies {
+		if p == nil {
+			continue
+		}
+
+		if p.MaxBodySize < 0 {
+			return fmt.Errorf("invalid maxBodySize %d in the policy of collection %s", p.MaxBodySize, collection)
+		}
+
+		p.sharedMaxBodySize = c.MaxBodySize
+
+		if p.History != nil && *p.History < 0 {
+			return fmt.Errorf("invalid history %d in the policy of collection %s", *p.History, collection)
+		}
+
+		if p.StorageCodec != "" && p.StorageCodec != StorageCodecZstd {
+			return fmt.Errorf("invalid storageCodec %q in the policy of collection %s, it should be %s", p.StorageCodec, collection, StorageCodecZstd)
+		}
+
+		if p.IDFormat != "" {
+			idFormat, err := regexp.Compile(p.IDFormat)
+			if err != nil {
+				return fmt.Errorf("invalid idFormat in the policy of collection %s: %v", collection, err)
+			}
+			p.idFormat = idFormat
+		}
+
+		if err := p.compileSchema(dir); err != nil {
+			return fmt.Errorf("invalid schema in the policy of collection %s: %v", collection, err)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) compileSchema(dir string) error {
+	if p.SchemaMode != "" && p.SchemaMode != SchemaEnforce && p.SchemaMode != SchemaWarn {
+		return fmt.Errorf("schemaMode should be %s or %s, not %q", SchemaEnforce, SchemaWarn, p.SchemaMode)
+	}
+
+	data := []byte(p.Schema)
+	if p.SchemaFile != "" {
+		if len(data) > 0 {
+			return errors.New("either schema or schemaFile should be set, not both")
+		}
+
+		path := p.SchemaFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+
+		var err error
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return err
+		}
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	var err error
+	p.schema, err = schema.Compile(data)
+	return err
+}
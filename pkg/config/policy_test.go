@@ -0,0 +1,127 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicies(t *testing.T) {
+	config, err := ReadConfigFromReader(strings.NewReader(`{
+         "tombstoneRetention": "72h",
+         "history": {"methode": 10, "wordpress": 5},
+         "policies": {
+            "methode": {
+               "contentTypes": ["application/json"],
+               "maxBodySize": 1024,
+               "originSystemIds": ["http://cmdb.ft.com/systems/methode-web-pub"],
+               "idFormat": "^[a-f0-9]{8}-[a-f0-9]{4}-4",
+               "retention": "24h",
+               "history": 3,
+               "storageCodec": "zstd"
+            },
+            "v1-metadata": {"readOnly": true}
+         }
+      }`))
+	assert.NoError(t, err)
+
+	methode := config.Policy("methode")
+	assert.Equal(t, int64(1024), methode.MaxBodySize)
+	assert.True(t, methode.AllowsContentType("application/json"))
+	assert.True(t, methode.AllowsContentType("Application/JSON; charset=utf-8"))
+	assert.False(t, methode.AllowsContentType("application/octet-stream"))
+	assert.False(t, methode.AllowsContentType("not a media type;"))
+	assert.True(t, methode.AllowsOriginSystemID("http://cmdb.ft.com/systems/methode-web-pub"))
+	assert.False(t, methode.AllowsOriginSystemID(""))
+	assert.True(t, methode.AllowsID("9694733e-163a-4393-801f-000ab7de5041"))
+	assert.False(t, methode.AllowsID("59b3a4d0-9a17-11e6-8dbb-4b02b9e5d5ff"))
+
+	assert.True(t, config.Policy("v1-metadata").ReadOnly)
+
+	unrestricted := config.Policy("video")
+	assert.False(t, unrestricted.ReadOnly)
+	assert.True(t, unrestricted.AllowsContentType("text/plain"))
+	assert.True(t, unrestricted.AllowsOriginSystemID(""))
+	assert.True(t, unrestricted.AllowsID("anything"))
+
+	assert.Equal(t, map[string]int{"methode": 3, "wordpress": 5}, config.HistoryDepths())
+	assert.Equal(t, 24*time.Hour, config.RetentionPeriod("methode"))
+	assert.Equal(t, 72*time.Hour, config.RetentionPeriod("video"))
+	assert.Equal(t, StorageCodecZstd, config.StorageCodec("methode"))
+	assert.Equal(t, "", config.StorageCodec("video"))
+}
+
+func TestInvalidPolicies(t *testing.T) {
+	for _, policy := range []string{`{"idFormat": "["}`, `{"maxBodySize": -1}`, `{"history": -1}`, `{"retention": "a while"}`, `{"storageCodec": "lz4"}`} {
+		_, err := ReadConfigFromReader(strings.NewReader(`{"policies": {"methode": ` + policy + `}}`))
+		assert.Error(t, err, policy)
+	}
+}
+
+func TestSchemaPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schemas")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "methode.json"), []byte(`{"type": "object", "required": ["title"]}`), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{
+         "policies": {
+            "methode": {"schemaFile": "methode.json"},
+            "wordpress": {"schema": {"type": "object"}, "schemaMode": "warn"}
+         }
+      }`), 0600))
+
+	config, err := ReadConfig(filepath.Join(dir, "config.json"))
+	require.NoError(t, err)
+
+	methode := config.Policy("methode")
+	require.NotNil(t, methode.ContentSchema())
+	assert.False(t, methode.WarnOnly())
+	assert.Len(t, methode.ContentSchema().Validate(map[string]interface{}{}), 1)
+
+	wordpress := config.Policy("wordpress")
+	require.NotNil(t, wordpress.ContentSchema())
+	assert.True(t, wordpress.WarnOnly())
+
+	assert.Nil(t, config.Policy("video").ContentSchema())
+}
+
+func TestInvalidSchemaPolicies(t *testing.T) {
+	for _, policy := range []string{
+		`{"schema": {"type": "thing"}}`,
+		`{"schema": {}, "schemaMode": "ignore"}`,
+		`{"schema": {}, "schemaFile": "methode.json"}`,
+		`{"schemaFile": "missing.json"}`,
+	} {
+		_, err := ReadConfigFromReader(strings.NewReader(`{"policies": {"methode": ` + policy + `}}`))
+		assert.Error(t, err, policy)
+	}
+}
+
+func TestBodySizeLimits(t *testing.T) {
+	config, err := ReadConfigFromReader(strings.NewReader(`{
+         "maxBodySize": 1024,
+         "policies": {
+            "methode": {"maxBodySize": 2048},
+            "wordpress": {"readOnly": true}
+         }
+      }`))
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(2048), config.Policy("methode").BodySizeLimit())
+	assert.Equal(t, int64(1024), config.Policy("wordpress").BodySizeLimit())
+	assert.Equal(t, int64(1024), config.Policy("video").BodySizeLimit())
+
+	config, err = ReadConfigFromReader(strings.NewReader(`{}`))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), config.Policy("video").BodySizeLimit())
+
+	_, err = ReadConfigFromReader(strings.NewReader(`{"maxBodySize": -1}`))
+	assert.Error(t, err)
+}
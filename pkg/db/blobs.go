@@ -0,0 +1,234 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+const (
+	blobName    = "blob"
+	blobIDName  = "blob.id"
+	blobTimeout = 10 * time.Minute
+)
+
+// blob references the GridFS file an octet stream over the blob threshold is stored in, instead of the content of its document.
+// Blobs are never updated: writing the document again stores a new one, and the ones no document or version refers to anymore
+// are deleted by PurgeBlobs.
+type blob struct {
+	ID   primitive.ObjectID `bson:"id"`
+	Size int64              `bson:"size"`
+}
+
+var blobIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: blobIDName, Value: 1}},
+	Options: options.Index().SetName("blob-index").SetBackground(true).SetSparse(true),
+}
+
+// blobsBucket is the name of the GridFS bucket of the blobs of the collection
+func blobsBucket(collection string) string {
+	return collection + "__blobs"
+}
+
+func (ma *mongoConnection) bucket(collection string) (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(ma.client.Database(ma.dbName), options.GridFSBucket().SetName(blobsBucket(collection)))
+}
+
+// storeStream stores streamed content over the blob threshold in a new blob, and reads smaller content in memory. It returns the
+// blob, or nil if the content is not streamed or is to be stored in the document. The hash of the content is set along the way.
+func (ma *mongoConnection) storeStream(collection string, resource *mapper.Resource) (*blob, error) {
+	stream, ok := resource.Content.(*mapper.Stream)
+	if !ok {
+		return nil, nil
+	}
+
+	r, err := stream.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	head, err := ioutil.ReadAll(io.LimitReader(r, ma.blobThreshold+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(head)) <= ma.blobThreshold {
+		resource.Content = head
+		return nil, nil
+	}
+
+	bucket, err := ma.bucket(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	upload, err := bucket.OpenUploadStream(resource.UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = upload.SetWriteDeadline(time.Now().Add(blobTimeout)); err != nil {
+		_ = upload.Abort()
+		return nil, err
+	}
+
+	hash := mapper.NewBinaryHash()
+	size, err := io.Copy(io.MultiWriter(upload, hash), io.MultiReader(bytes.NewReader(head), r))
+	if err != nil {
+		_ = upload.Abort()
+		return nil, err
+	}
+
+	if err = upload.Close(); err != nil {
+		return nil, err
+	}
+
+	stored := &blob{ID: upload.FileID.(primitive.ObjectID), Size: size}
+	resource.Content = ma.blobStream(collection, stored)
+	resource.Hash = hash.Sum()
+	return stored, nil
+}
+
+// blobStream returns the stream of the content stored in the blob
+func (ma *mongoConnection) blobStream(collection string, b *blob) *mapper.Stream {
+	return mapper.NewStream(b.Size, func() (io.ReadCloser, error) {
+		bucket, err := ma.bucket(collection)
+		if err != nil {
+			return nil, err
+		}
+
+		download, err := bucket.OpenDownloadStream(b.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = download.SetReadDeadline(time.Now().Add(blobTimeout)); err != nil {
+			_ = download.Close()
+			return nil, err
+		}
+		return download, nil
+	})
+}
+
+// resource returns the resource of the document, with the content of its blob if it has one, or decompressed if it is compressed
+func (ma *mongoConnection) resource(collection string, doc *document) (*mapper.Resource, error) {
+	if err := doc.decompress(); err != nil {
+		return nil, err
+	}
+
+	res := doc.resource()
+	if doc.Blob != nil {
+		res.Content = ma.blobStream(collection, doc.Blob)
+	}
+	return res, nil
+}
+
+// deleteBlob deletes the blob of a write which failed, leaving it to PurgeBlobs if that fails too
+func (ma *mongoConnection) deleteBlob(collection string, b *blob) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	bucket, err := ma.bucket(collection)
+	if err == nil {
+		err = bucket.DeleteContext(ctx, b.ID)
+	}
+
+	if err != nil {
+		logger.WithError(err).Warnf("Failed to delete blob %s of collection %s", b.ID.Hex(), collection)
+	}
+}
+
+// PurgeBlobs deletes the blobs uploaded before the given time which neither the documents nor the versions of the collection refer
+// to anymore. More recent blobs may belong to writes which are still going on.
+func (ma *mongoConnection) PurgeBlobs(collection string, uploadedBefore time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), blobTimeout)
+	defer cancel()
+
+	bucket, err := ma.bucket(collection)
+	if err != nil {
+		return 0, err
+	}
+
+	opts := options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := bucket.GetFilesCollection().Find(ctx, bson.D{{Key: "uploadDate", Value: bson.D{{Key: "$lt", Value: uploadedBefore}}}}, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var purged int64
+	for cursor.Next(ctx) {
+		file := struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}{}
+		if err = cursor.Decode(&file); err != nil {
+			return purged, err
+		}
+
+		referenced, err := ma.blobReferenced(ctx, collection, file.ID)
+		if err != nil {
+			return purged, err
+		}
+
+		if referenced {
+			continue
+		}
+
+		if err = bucket.DeleteContext(ctx, file.ID); err != nil && err != gridfs.ErrFileNotFound {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, cursor.Err()
+}
+
+// blobReferenced tells whether a document or a version of the collection refers to the blob
+func (ma *mongoConnection) blobReferenced(ctx context.Context, collection string, id primitive.ObjectID) (bool, error) {
+	filter := bson.D{{Key: blobIDName, Value: id}}
+	opts := options.Count().SetLimit(1)
+
+	for _, coll := range []string{collection, versionsCollection(collection)} {
+		count, err := ma.collection(coll).CountDocuments(ctx, filter, opts)
+		if err != nil {
+			return false, err
+		}
+
+		if count > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// readStream reads streamed content in memory, for the in memory store which keeps all the content there
+func readStream(resource *mapper.Resource) error {
+	stream, ok := resource.Content.(*mapper.Stream)
+	if !ok {
+		return nil
+	}
+
+	data, err := stream.Bytes()
+	if err != nil {
+		return err
+	}
+
+	resource.Content = data
+	return nil
+}
+
+// PurgeBlobs does nothing, as the in memory store keeps all the content in the documents
+func (mc *memoryConnection) PurgeBlobs(collection string, uploadedBefore time.Time) (int64, error) {
+	return 0, nil
+}
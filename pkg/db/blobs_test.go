@@ -0,0 +1,132 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func binaryStream(data []byte) *mapper.Stream {
+	return mapper.NewStream(int64(len(data)), func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	})
+}
+
+// binaryContent reads binary content, whether it is stored in the document or streamed from a blob
+func binaryContent(t *testing.T, content interface{}) []byte {
+	if stream, ok := content.(*mapper.Stream); ok {
+		data, err := stream.Bytes()
+		require.NoError(t, err)
+		return data
+	}
+
+	data, ok := content.([]byte)
+	require.True(t, ok, "expected binary content, got %T", content)
+	return data
+}
+
+// testBlobs writes a stream over the blob threshold of the mongo tests, then a small one over it
+func testBlobs(t *testing.T, connection Connection) {
+	data := bytes.Repeat([]byte{0, 1, 2, 0xff}, 1<<10)
+	hash, err := mapper.ContentHash(data)
+	require.NoError(t, err)
+
+	resource := generateResource()
+	resource.ContentType = "application/octet-stream"
+	resource.Content = binaryStream(data)
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+	assert.Equal(t, hash, resource.Hash, "the stream should be hashed as it is stored")
+
+	res, found, err := connection.Read("methode", resource.UUID)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, hash, res.Hash)
+	assert.Equal(t, data, binaryContent(t, res.Content))
+
+	many, err := connection.ReadMany("methode", []string{resource.UUID})
+	require.NoError(t, err)
+	require.Len(t, many, 1)
+	assert.Equal(t, data, binaryContent(t, many[0].Content))
+
+	resource.Content = binaryStream([]byte("small"))
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+
+	res, _, err = connection.Read("methode", resource.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("small"), res.Content, "content under the blob threshold should be stored in the document")
+
+	// the blob of the first revision is kept for its version
+	purged, err := connection.PurgeBlobs("methode", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), purged)
+
+	version, found, err := connection.ReadVersion("methode", resource.UUID, res.Revision-1)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, data, binaryContent(t, version.Content))
+}
+
+func TestInMemoryBlobs(t *testing.T) {
+	testBlobs(t, openInMemoryWithHistory(t, 10))
+}
+
+func TestBlobs(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+	defer connection.Close()
+
+	testBlobs(t, connection)
+}
+
+func TestPurgeBlobs(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+	defer connection.Close()
+
+	// the collection keeps no history, so overwritten blobs are no longer referenced
+	collection := "blobs-test"
+	ma := connection.(*mongoConnection)
+	bucket, err := ma.bucket(collection)
+	require.NoError(t, err)
+	require.NoError(t, bucket.Drop())
+
+	data := bytes.Repeat([]byte{0, 1, 2, 0xff}, 1<<10)
+	resource := generateResource()
+	resource.ContentType = "application/octet-stream"
+	resource.Content = binaryStream(data)
+	require.NoError(t, connection.Write(collection, resource, Precondition{}, nil))
+
+	resource.Content = binaryStream(data)
+	require.NoError(t, connection.Write(collection, resource, Precondition{}, nil))
+
+	// the blob of a write which fails is deleted straight away
+	resource.Content = binaryStream(data)
+	assert.Equal(t, ErrPreconditionFailed, connection.Write(collection, resource, Precondition{IfMatch: []int64{1}}, nil))
+
+	files, err := bucket.GetFilesCollection().CountDocuments(context.Background(), bson.D{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), files)
+
+	purged, err := connection.PurgeBlobs(collection, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), purged, "recent blobs may belong to writes going on")
+
+	purged, err = connection.PurgeBlobs(collection, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	res, _, err := connection.Read(collection, resource.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, data, binaryContent(t, res.Content))
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"github.com/pborman/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+// BulkWrite upserts the resources in a single transaction, with the same revisions, versions and changes as one Write each. The
+// resources whose content hash matches the one of the live stored document are left as they are, and reported as not written.
+// The uuids of the resources must be distinct, as the changes of a batch are all decided from the documents stored before it.
+func (ma *mongoConnection) BulkWrite(collection string, resources []*mapper.Resource) ([]bool, error) {
+	for _, resource := range resources {
+		if err := setHash(resource); err != nil {
+			return nil, err
+		}
+	}
+
+	var written []bool
+	var stored []*document
+	err := ma.withTransaction(func(ctx mongo.SessionContext) error {
+		written = make([]bool, len(resources))
+		stored = make([]*document, len(resources))
+
+		currents, err := ma.currents(ctx, collection, resources)
+		if err != nil {
+			return err
+		}
+
+		timestamp := now()
+		var replaces []mongo.WriteModel
+		var docs []*document
+		var changes []*Change
+		for i, resource := range resources {
+			current := currents[uuid.Parse(resource.UUID).String()]
+			live := current != nil && current.Tombstone == nil
+			if live && current.Hash == resource.Hash {
+				continue
+			}
+
+			revision, err := ma.nextRevision(ctx, collection, resource.UUID, current)
+			if err != nil {
+				return err
+			}
+
+			doc := newDocument(resource, revision)
+			if err = ma.compress(collection, doc); err != nil {
+				return err
+			}
+
+			doc.LastModified = timestamp
+			doc.Created = timestamp
+			if live {
+				doc.Created = current.Created
+			}
+
+			replaces = append(replaces, mongo.NewReplaceOneModel().SetFilter(uuidFilter(resource.UUID)).SetReplacement(doc).SetUpsert(true))
+			docs = append(docs, doc)
+			changes = append(changes, newChange(OperationPut, resource, timestamp))
+			written[i] = true
+			stored[i] = doc
+		}
+
+		if len(replaces) == 0 {
+			return nil
+		}
+
+		if _, err = ma.collection(collection).BulkWrite(ctx, replaces, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+
+		if err = ma.recordVersions(ctx, collection, docs); err != nil {
+			return err
+		}
+
+		return ma.recordChanges(ctx, collection, changes)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, doc := range stored {
+		if doc != nil {
+			resources[i].Revision = doc.Revision
+			resources[i].Created = doc.Created
+			resources[i].LastModified = doc.LastModified
+		}
+	}
+	return written, nil
+}
+
+// currents is current for many resources at once, keyed by their uuids
+func (ma *mongoConnection) currents(ctx mongo.SessionContext, collection string, resources []*mapper.Resource) (map[string]*document, error) {
+	ids := make(bson.A, 0, len(resources))
+	for _, resource := range resources {
+		ids = append(ids, bsonUUID(resource.UUID))
+	}
+
+	filter := bson.D{{Key: uuidName, Value: bson.D{{Key: "$in", Value: ids}}}}
+	cursor, err := ma.collection(collection).Find(ctx, filter, options.Find().SetProjection(currentProjection))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	currents := make(map[string]*document, len(resources))
+	for cursor.Next(ctx) {
+		current := &document{}
+		if err = cursor.Decode(current); err != nil {
+			return nil, err
+		}
+		currents[uuid.UUID(current.UUID.Data).String()] = current
+	}
+	return currents, cursor.Err()
+}
+
+func (mc *memoryConnection) BulkWrite(collection string, resources []*mapper.Resource) ([]bool, error) {
+	for _, resource := range resources {
+		if err := setHash(resource); err != nil {
+			return nil, err
+		}
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	written := make([]bool, len(resources))
+	for i, resource := range resources {
+		id := uuid.Parse(resource.UUID).String()
+		current, found := mc.documents[collection][id]
+		if found && current.tombstone == nil && current.resource.Hash == resource.Hash {
+			continue
+		}
+
+		var live *mapper.Resource
+		if found && current.tombstone == nil {
+			live = current.resource
+		}
+
+		if err := mc.put(collection, id, resource, live); err != nil {
+			return nil, err
+		}
+		written[i] = true
+	}
+	return written, nil
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func testBulkWrite(t *testing.T, connection Connection) {
+	since := lastSequence(t, connection)
+
+	existing := generateResource()
+	require.NoError(t, connection.Write("methode", existing, Precondition{}, nil))
+
+	deleted := generateResource()
+	require.NoError(t, connection.Write("methode", deleted, Precondition{}, nil))
+	require.NoError(t, connection.Delete("methode", deleted.UUID, "tid_delete", Precondition{}))
+
+	unchanged := &mapper.Resource{UUID: existing.UUID, Content: existing.Content, ContentType: "application/json"}
+	updated := &mapper.Resource{UUID: deleted.UUID, Content: deleted.Content, ContentType: "application/json", TransactionID: "tid_bulk"}
+	created := generateResource()
+	created.TransactionID = "tid_bulk"
+
+	written, err := connection.BulkWrite("methode", []*mapper.Resource{unchanged, updated, created})
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true, true}, written)
+
+	assert.Equal(t, int64(0), unchanged.Revision, "an unchanged resource shouldn't be given a revision")
+	assert.Equal(t, int64(2), updated.Revision, "the revision should carry on from the deleted document")
+	assert.Equal(t, int64(1), created.Revision)
+	assert.Equal(t, created.Created, created.LastModified)
+
+	res, found, err := connection.Read("methode", updated.UUID)
+	require.NoError(t, err)
+	require.True(t, found, "writing over a deleted document should bring it back")
+	assert.Equal(t, "tid_bulk", res.TransactionID)
+
+	res, found, err = connection.Read("methode", existing.UUID)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, existing.Revision, res.Revision)
+	assert.True(t, existing.LastModified.Equal(res.LastModified))
+
+	versions, err := connection.ReadVersions("methode", created.UUID)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, created.Hash, versions[0].Hash)
+
+	changes := readChanges(t, connection, since)
+	require.Len(t, changes, 5)
+	assert.Equal(t, updated.UUID, changes[3].UUID)
+	assert.Equal(t, created.UUID, changes[4].UUID)
+	for i, change := range changes {
+		assert.Equal(t, since+int64(i)+1, change.Sequence)
+	}
+	assert.Equal(t, OperationPut, changes[4].Operation)
+	assert.Equal(t, created.Hash, changes[4].Hash)
+	assert.True(t, created.LastModified.Equal(changes[4].Timestamp))
+}
+
+func TestInMemoryBulkWrite(t *testing.T) {
+	testBulkWrite(t, openInMemoryWithHistory(t, 3))
+}
+
+func TestBulkWrite(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testBulkWrite(t, connection)
+}
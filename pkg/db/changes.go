@@ -0,0 +1,375 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/pborman/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+// The operations recorded in the change feed
+const (
+	OperationPut     = "put"
+	OperationPatch   = "patch"
+	OperationDelete  = "delete"
+	OperationRestore = "restore"
+)
+
+const (
+	sequenceName  = "sequence"
+	timestampName = "timestamp"
+
+	// sequencesCollection holds one counter per native collection, the last sequence number given to a change in it
+	sequencesCollection = "__sequences"
+
+	// numberingBatchSize is the number of pending changes numbered in one transaction
+	numberingBatchSize = 1000
+)
+
+// ErrChangesPurged is returned when reading the change feed from a sequence some of the changes after which have been purged
+var ErrChangesPurged = errors.New("the changes after this sequence have been purged")
+
+// Change describes a committed write of a native document. Changes are numbered by a sequence which follows the order they are
+// read in after their writes commit, and which a reader resumes the feed from.
+type Change struct {
+	Sequence       int64
+	UUID           string
+	Operation      string
+	Hash           string
+	Timestamp      time.Time
+	OriginSystemID string
+}
+
+// changeDocument is the stored shape of a change, kept in a sibling collection named after pendingChangesCollection until it is
+// numbered, then after changesCollection
+type changeDocument struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	Sequence       int64              `bson:"sequence,omitempty"`
+	UUID           primitive.Binary   `bson:"uuid"`
+	Operation      string             `bson:"operation"`
+	Hash           string             `bson:"hash,omitempty"`
+	Timestamp      time.Time          `bson:"timestamp"`
+	OriginSystemID string             `bson:"origin-system-id"`
+}
+
+func (c *changeDocument) change() *Change {
+	return &Change{
+		Sequence:       c.Sequence,
+		UUID:           uuid.UUID(c.UUID.Data).String(),
+		Operation:      c.Operation,
+		Hash:           c.Hash,
+		Timestamp:      c.Timestamp,
+		OriginSystemID: c.OriginSystemID,
+	}
+}
+
+func changesCollection(collection string) string {
+	return collection + "__changes"
+}
+
+func pendingChangesCollection(collection string) string {
+	return collection + "__pending"
+}
+
+// newChange describes the given write of a resource, the sequence is only known once it is recorded
+func newChange(operation string, resource *mapper.Resource, timestamp time.Time) *Change {
+	return &Change{
+		UUID:           resource.UUID,
+		Operation:      operation,
+		Hash:           resource.Hash,
+		Timestamp:      timestamp,
+		OriginSystemID: resource.OriginSystemID,
+	}
+}
+
+var changesIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: sequenceName, Value: 1}},
+	Options: options.Index().SetName("sequence-index").SetBackground(true).SetUnique(true),
+}
+
+// pendingChangesIndex serves the numbering, which takes the pending changes in the order of their writes
+var pendingChangesIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: timestampName, Value: 1}, {Key: "_id", Value: 1}},
+	Options: options.Index().SetName("timestamp-index").SetBackground(true),
+}
+
+// recordChange stores the change as pending, in the transaction of the write it describes. Writes only insert their own
+// changes, so concurrent writes to a collection don't contend on its change feed; the changes are numbered once committed, by
+// numberChanges. Only the supported collections have a change feed, so that e.g. the healthcheck writes don't pile up changes.
+func (ma *mongoConnection) recordChange(ctx mongo.SessionContext, collection string, change *Change) error {
+	return ma.recordChanges(ctx, collection, []*Change{change})
+}
+
+// recordChanges is recordChange for the writes of a transaction, which are numbered in the given order
+func (ma *mongoConnection) recordChanges(ctx mongo.SessionContext, collection string, changes []*Change) error {
+	if !ma.collections.contains(collection) || len(changes) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, 0, len(changes))
+	for _, change := range changes {
+		// the ids break the ties between the changes of a transaction, which share their timestamp
+		docs = append(docs, &changeDocument{
+			ID:             primitive.NewObjectID(),
+			UUID:           bsonUUID(change.UUID),
+			Operation:      change.Operation,
+			Hash:           change.Hash,
+			Timestamp:      change.Timestamp,
+			OriginSystemID: change.OriginSystemID,
+		})
+	}
+
+	_, err := ma.collection(pendingChangesCollection(collection)).InsertMany(ctx, docs)
+	return err
+}
+
+// numberChanges moves the committed pending changes of the collection to its change feed, numbered by its counter after the
+// last change already there. Readers call it before reading the feed, so a change is numbered at the latest when it is first
+// read, and a reader never skips one: a change committed after a reader has gone past the counter is numbered after it.
+//
+// The numbering takes the pending changes by timestamp, which is the order of the writes of each document, as they conflict on
+// it and the later one takes its timestamp once retried after the earlier commits. Readers numbering at the same time conflict
+// on the counter instead, and are retried one after the other; the writes never wait on it.
+func (ma *mongoConnection) numberChanges(ctx context.Context, collection string) error {
+	pending := ma.collection(pendingChangesCollection(collection))
+	for {
+		if err := pending.FindOne(ctx, bson.D{}, options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}})).Err(); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil
+			}
+			return err
+		}
+
+		numbered := 0
+		err := ma.withTransaction(func(ctx mongo.SessionContext) error {
+			opts := options.Find().SetSort(bson.D{{Key: timestampName, Value: 1}, {Key: "_id", Value: 1}}).SetLimit(numberingBatchSize)
+			cursor, err := pending.Find(ctx, bson.D{}, opts)
+			if err != nil {
+				return err
+			}
+
+			var docs []*changeDocument
+			if err = cursor.All(ctx, &docs); err != nil {
+				return err
+			}
+
+			numbered = len(docs)
+			if numbered == 0 {
+				return nil
+			}
+
+			counterOpts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+			update := bson.D{{Key: "$inc", Value: bson.D{{Key: sequenceName, Value: int64(numbered)}}}}
+
+			var counter struct {
+				Sequence int64 `bson:"sequence"`
+			}
+			if err = ma.collection(sequencesCollection).FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: collection}}, update, counterOpts).Decode(&counter); err != nil {
+				return err
+			}
+
+			changes := make([]interface{}, 0, numbered)
+			ids := make(bson.A, 0, numbered)
+			for i, doc := range docs {
+				ids = append(ids, doc.ID)
+
+				doc.ID = primitive.NilObjectID
+				doc.Sequence = counter.Sequence - int64(numbered-1-i)
+				changes = append(changes, doc)
+			}
+
+			if _, err = ma.collection(changesCollection(collection)).InsertMany(ctx, changes); err != nil {
+				return err
+			}
+
+			_, err = pending.DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}})
+			return err
+		})
+		if err != nil || numbered < numberingBatchSize {
+			return err
+		}
+	}
+}
+
+// sequenceCounter is the stored shape of the counter of a collection, with the sequence of the last change purged from its feed
+type sequenceCounter struct {
+	Sequence int64 `bson:"sequence"`
+	Purged   int64 `bson:"purged"`
+}
+
+func (ma *mongoConnection) readCounter(ctx context.Context, collection string) (*sequenceCounter, error) {
+	counter := &sequenceCounter{}
+	err := ma.collection(sequencesCollection).FindOne(ctx, bson.D{{Key: "_id", Value: collection}}).Decode(counter)
+	if err == mongo.ErrNoDocuments {
+		return counter, nil
+	}
+	return counter, err
+}
+
+// PurgeChanges removes the changes of the collection recorded before the given time, short of the ones which the outbox of the
+// collection, or the webhook of one of its subscriptions, has yet to deliver. A reader whose lease expired before the given time
+// is deemed gone, and doesn't hold the changes back. Reading the feed from before a purged change fails with ErrChangesPurged.
+func (ma *mongoConnection) PurgeChanges(collection string, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	changes := ma.collection(changesCollection(collection))
+	counter, err := ma.readCounter(ctx, collection)
+	if err != nil {
+		return 0, err
+	}
+
+	// the changes are purged up to the first one recorded since, which the previous purges leave near the start of the feed
+	var first changeDocument
+	opts := options.FindOne().SetSort(bson.D{{Key: sequenceName, Value: 1}}).SetProjection(bson.D{{Key: sequenceName, Value: 1}})
+	cutoff := counter.Sequence
+	err = changes.FindOne(ctx, bson.D{{Key: timestampName, Value: bson.D{{Key: "$gte", Value: before}}}}, opts).Decode(&first)
+	if err == nil {
+		cutoff = first.Sequence - 1
+	} else if err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+
+	consumed, err := ma.consumedUpTo(ctx, collection, before)
+	if err != nil {
+		return 0, err
+	}
+	if consumed < cutoff {
+		cutoff = consumed
+	}
+	if cutoff <= counter.Purged {
+		return 0, nil
+	}
+
+	// readers check the mark once their cursor is open, so it is set before any change is gone
+	update := bson.D{{Key: "$max", Value: bson.D{{Key: "purged", Value: cutoff}}}}
+	if _, err = ma.collection(sequencesCollection).UpdateOne(ctx, bson.D{{Key: "_id", Value: collection}}, update); err != nil {
+		return 0, err
+	}
+
+	result, err := changes.DeleteMany(ctx, bson.D{{Key: sequenceName, Value: bson.D{{Key: "$lte", Value: cutoff}}}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// consumedUpTo returns the sequence up to which the outboxes reading the change feed of the collection have delivered it
+func (ma *mongoConnection) consumedUpTo(ctx context.Context, collection string, abandonedBefore time.Time) (int64, error) {
+	cursor, err := ma.collection(subscriptionsCollection).Find(ctx, bson.D{{Key: "collection", Value: collection}})
+	if err != nil {
+		return 0, err
+	}
+
+	var subscriptions []*Subscription
+	if err = cursor.All(ctx, &subscriptions); err != nil {
+		return 0, err
+	}
+
+	names := bson.A{collection}
+	for _, subscription := range subscriptions {
+		names = append(names, SubscriptionOutbox(subscription.ID))
+	}
+
+	cursor, err = ma.collection(outboxCollection).Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: names}}}})
+	if err != nil {
+		return 0, err
+	}
+
+	outboxes := make(map[string]*outboxDocument)
+	var docs []*outboxDocument
+	if err = cursor.All(ctx, &docs); err != nil {
+		return 0, err
+	}
+	for _, doc := range docs {
+		outboxes[doc.Name] = doc
+	}
+
+	return consumedUpTo(collection, subscriptions, outboxes, abandonedBefore), nil
+}
+
+// consumedUpTo returns the lowest position of the outbox of the collection and of the ones of its subscriptions, which start from
+// the sequence they were created at until they are first claimed. Outboxes whose lease expired before abandonedBefore are left
+// out, as nothing has read them since.
+func consumedUpTo(collection string, subscriptions []*Subscription, outboxes map[string]*outboxDocument, abandonedBefore time.Time) int64 {
+	consumed := int64(math.MaxInt64)
+	position := func(name string, from int64) {
+		outbox, found := outboxes[name]
+		switch {
+		case !found && from < consumed:
+			consumed = from
+		case found && !outbox.LeaseUntil.Before(abandonedBefore) && outbox.Position < consumed:
+			consumed = outbox.Position
+		}
+	}
+
+	if _, found := outboxes[collection]; found {
+		position(collection, 0)
+	}
+	for _, subscription := range subscriptions {
+		if subscription.Collection == collection {
+			position(SubscriptionOutbox(subscription.ID), subscription.Since)
+		}
+	}
+	return consumed
+}
+
+// ReadChanges streams the changes of the collection with a sequence greater than the given one, in sequence order, once it has
+// numbered the pending ones. It fails with ErrChangesPurged if some of them have been purged; 0 reads from the oldest change kept.
+func (ma *mongoConnection) ReadChanges(ctx context.Context, collection string, since int64) (chan *Change, error) {
+	changes := make(chan *Change, 8)
+
+	if err := ma.numberChanges(ctx, collection); err != nil {
+		return changes, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: sequenceName, Value: 1}}).SetBatchSize(32)
+	filter := bson.D{{Key: sequenceName, Value: bson.D{{Key: "$gt", Value: since}}}}
+
+	cursor, err := ma.collection(changesCollection(collection)).Find(ctx, filter, opts)
+	if err != nil {
+		return changes, err
+	}
+
+	if since > 0 {
+		counter, err := ma.readCounter(ctx, collection)
+		if err == nil && since < counter.Purged {
+			err = ErrChangesPurged
+		}
+		if err != nil {
+			_ = cursor.Close(context.Background())
+			return changes, err
+		}
+	}
+
+	go func() {
+		defer cursor.Close(context.Background())
+		defer close(changes)
+
+		for cursor.Next(ctx) {
+			doc := &changeDocument{}
+			if err := cursor.Decode(doc); err != nil {
+				// a gap would be skipped for good by readers resuming after it, so stop the feed short of it instead
+				logger.WithError(err).Error("could not decode change from mongoDB")
+				return
+			}
+
+			select {
+			case changes <- doc.change():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return changes, nil
+}
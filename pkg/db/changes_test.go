@@ -0,0 +1,347 @@
+package db
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func readChanges(t *testing.T, connection Connection, since int64) []*Change {
+	changes, err := connection.ReadChanges(context.Background(), "methode", since)
+	require.NoError(t, err)
+
+	read := make([]*Change, 0)
+	for change := range changes {
+		read = append(read, change)
+	}
+	return read
+}
+
+func lastSequence(t *testing.T, connection Connection) int64 {
+	changes := readChanges(t, connection, 0)
+	if len(changes) == 0 {
+		return 0
+	}
+	return changes[len(changes)-1].Sequence
+}
+
+func testChanges(t *testing.T, connection Connection) {
+	since := lastSequence(t, connection)
+
+	resource := generateResource()
+	resource.OriginSystemID = "methode-web-pub"
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+
+	patched, err := connection.Patch("methode", &mapper.Resource{UUID: resource.UUID, Content: map[string]interface{}{"patched": true}, ContentType: "application/json", OriginSystemID: "methode-web-pub"}, Precondition{}, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{}))
+	require.NoError(t, connection.Restore("methode", resource.UUID, "tid_restore"))
+
+	assert.Equal(t, ErrPreconditionFailed, connection.Write("methode", resource, Precondition{IfNoneMatchAny: true}, nil))
+
+	changes := readChanges(t, connection, since)
+	require.Len(t, changes, 4)
+
+	for i, operation := range []string{OperationPut, OperationPatch, OperationDelete, OperationRestore} {
+		assert.Equal(t, since+int64(i)+1, changes[i].Sequence)
+		assert.Equal(t, operation, changes[i].Operation)
+		assert.Equal(t, resource.UUID, changes[i].UUID)
+		assert.Equal(t, "methode-web-pub", changes[i].OriginSystemID)
+	}
+
+	assert.Equal(t, resource.Hash, changes[0].Hash)
+	assert.True(t, resource.LastModified.Equal(changes[0].Timestamp))
+	assert.Equal(t, patched.Hash, changes[1].Hash)
+	assert.True(t, patched.LastModified.Equal(changes[1].Timestamp))
+	assert.Equal(t, patched.Hash, changes[2].Hash)
+	assert.Equal(t, patched.Hash, changes[3].Hash)
+
+	resumed := readChanges(t, connection, changes[1].Sequence)
+	require.Len(t, resumed, 2)
+	assert.Equal(t, changes[2:], resumed)
+}
+
+func testConcurrentChanges(t *testing.T, connection Connection) {
+	since := lastSequence(t, connection)
+
+	wg := &sync.WaitGroup{}
+	for range make([]struct{}, 16) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, connection.Write("methode", generateResource(), Precondition{}, nil))
+		}()
+	}
+	wg.Wait()
+
+	changes := readChanges(t, connection, since)
+	require.Len(t, changes, 16)
+
+	uuids := make(map[string]bool)
+	for i, change := range changes {
+		assert.Equal(t, since+int64(i)+1, change.Sequence, "the sequence should have no gaps")
+		uuids[change.UUID] = true
+	}
+	assert.Len(t, uuids, 16)
+}
+
+func testPurgeChanges(t *testing.T, connection Connection) {
+	// a collection of its own keeps the readers of the other tests from holding its changes back
+	collection := "purge-" + uuid.New()[:8]
+	_, _, err := connection.RegisterCollection(collection)
+	require.NoError(t, err)
+
+	for range make([]struct{}, 4) {
+		require.NoError(t, connection.Write(collection, generateResource(), Precondition{}, nil))
+	}
+
+	read := func(since int64) ([]*Change, error) {
+		changes, err := connection.ReadChanges(context.Background(), collection, since)
+		if err != nil {
+			return nil, err
+		}
+
+		read := make([]*Change, 0)
+		for change := range changes {
+			read = append(read, change)
+		}
+		return read, nil
+	}
+
+	changes, err := read(0)
+	require.NoError(t, err)
+	require.Len(t, changes, 4)
+
+	purged, err := connection.PurgeChanges(collection, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, purged, "the changes within the retention should be kept")
+
+	// the webhook of the subscription has yet to deliver the changes after the second one
+	subscription := &Subscription{ID: uuid.New(), Collection: collection, URL: "http://localhost/hook", Since: changes[1].Sequence, Created: time.Now()}
+	require.NoError(t, connection.CreateSubscription(subscription))
+
+	purged, err = connection.PurgeChanges(collection, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	_, err = read(changes[0].Sequence)
+	assert.Equal(t, ErrChangesPurged, err)
+
+	resumed, err := read(changes[1].Sequence)
+	require.NoError(t, err)
+	assert.Equal(t, changes[2:], resumed)
+
+	fromStart, err := read(0)
+	require.NoError(t, err)
+	assert.Equal(t, changes[2:], fromStart, "the feed should start from the oldest change kept")
+
+	// once delivered, the changes go
+	outbox := SubscriptionOutbox(subscription.ID)
+	_, err = connection.ClaimOutbox(outbox, "owner", subscription.Since, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, connection.AdvanceOutbox(outbox, "owner", changes[2].Sequence))
+
+	purged, err = connection.PurgeChanges(collection, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	last, err := connection.LastSequence(collection)
+	require.NoError(t, err)
+	assert.Equal(t, changes[3].Sequence, last)
+
+	require.NoError(t, connection.Write(collection, generateResource(), Precondition{}, nil))
+	next, err := read(changes[2].Sequence)
+	require.NoError(t, err)
+	require.Len(t, next, 2)
+	assert.Equal(t, changes[3].Sequence+1, next[1].Sequence, "the sequence should carry on after a purge")
+}
+
+func TestConsumedUpTo(t *testing.T) {
+	now := time.Now()
+	subscriptions := []*Subscription{
+		{ID: "claimed", Collection: "methode", Since: 1},
+		{ID: "new", Collection: "methode", Since: 30},
+		{ID: "other", Collection: "wordpress", Since: 2},
+	}
+	outboxes := map[string]*outboxDocument{
+		"methode":                          {Name: "methode", Position: 40, LeaseUntil: now},
+		SubscriptionOutbox("claimed"):      {Position: 20, LeaseUntil: now},
+		SubscriptionOutbox("other"):        {Position: 2, LeaseUntil: now},
+		SubscriptionOutbox("unsubscribed"): {Position: 3, LeaseUntil: now},
+	}
+
+	assert.Equal(t, int64(20), consumedUpTo("methode", subscriptions, outboxes, now.Add(-time.Hour)))
+	assert.Equal(t, int64(30), consumedUpTo("methode", subscriptions, outboxes, now.Add(time.Hour)), "outboxes abandoned before the cutoff shouldn't hold the changes back")
+	assert.Equal(t, int64(math.MaxInt64), consumedUpTo("video", subscriptions, outboxes, now), "nothing should hold back the changes of a collection without readers")
+}
+
+func TestInMemoryPurgeChanges(t *testing.T) {
+	testPurgeChanges(t, openInMemory(t))
+}
+
+func TestInMemoryChanges(t *testing.T) {
+	testChanges(t, openInMemoryWithHistory(t, 10))
+}
+
+func TestInMemoryConcurrentChanges(t *testing.T) {
+	testConcurrentChanges(t, openInMemory(t))
+}
+
+func TestInMemoryReadChangesDoesNotShareState(t *testing.T) {
+	connection := openInMemory(t)
+	require.NoError(t, connection.Write("methode", generateResource(), Precondition{}, nil))
+
+	readChanges(t, connection, 0)[0].Operation = "changed by the caller"
+	assert.Equal(t, OperationPut, readChanges(t, connection, 0)[0].Operation)
+	assert.Empty(t, readChanges(t, connection, 1))
+}
+
+func TestInMemoryNoChangesForUnsupportedCollections(t *testing.T) {
+	connection := openInMemory(t)
+	require.NoError(t, connection.Write("healthcheck", generateResource(), Precondition{}, nil))
+
+	changes, err := connection.ReadChanges(context.Background(), "healthcheck", 0)
+	require.NoError(t, err)
+	_, ok := <-changes
+	assert.False(t, ok)
+}
+
+func TestChanges(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testChanges(t, connection)
+}
+
+func TestPurgeChanges(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testPurgeChanges(t, connection)
+}
+
+func TestConcurrentChanges(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	connection.EnsureIndex()
+	testConcurrentChanges(t, connection)
+}
+
+// TestConcurrentNumbering writes to a collection from many writers while readers follow its change feed, numbering the changes
+// as they go: every reader must see the sequence without gaps, and every write exactly once
+func TestConcurrentNumbering(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	connection.EnsureIndex()
+	since := lastSequence(t, connection)
+
+	const writers, writes, readers = 64, 25, 4
+	done := make(chan struct{})
+	readersWg := &sync.WaitGroup{}
+	for range make([]struct{}, readers) {
+		readersWg.Add(1)
+		go func() {
+			defer readersWg.Done()
+
+			position := since
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				changes, err := connection.ReadChanges(context.Background(), "methode", position)
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				gap := false
+				for change := range changes {
+					gap = gap || !assert.Equal(t, position+1, change.Sequence, "the sequence should have no gaps")
+					position = change.Sequence
+				}
+				if gap {
+					return
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	wg := &sync.WaitGroup{}
+	for range make([]struct{}, writers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				assert.NoError(t, connection.Write("methode", generateResource(), Precondition{}, nil))
+			}
+		}()
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	t.Logf("%d writes by %d writers in %s, %.0f writes/s", writers*writes, writers, elapsed, float64(writers*writes)/elapsed.Seconds())
+
+	close(done)
+	readersWg.Wait()
+
+	changes := readChanges(t, connection, since)
+	require.Len(t, changes, writers*writes, "no write should fail")
+
+	uuids := make(map[string]bool)
+	for i, change := range changes {
+		require.Equal(t, since+int64(i)+1, change.Sequence, "the sequence should have no gaps")
+		uuids[change.UUID] = true
+	}
+	assert.Len(t, uuids, writers*writes, "every write should be numbered once")
+}
+
+// BenchmarkConcurrentWrites measures the throughput of concurrent writes to a single collection, e.g. with -cpu 1,4,16 to see
+// that it grows with the writers, as they don't contend on its change feed
+func BenchmarkConcurrentWrites(b *testing.B) {
+	mongo := startMongo(b)
+	connection, err := mongo.Open()
+	require.NoError(b, err)
+
+	defer connection.Close()
+
+	var failed int64
+	b.SetParallelism(4)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := connection.Write("methode", generateResource(), Precondition{}, nil); err != nil {
+				atomic.AddInt64(&failed, 1)
+			}
+		}
+	})
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "writes/s")
+	assert.Zero(b, failed)
+}
@@ -0,0 +1,270 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Financial-Times/go-logger"
+)
+
+const (
+	collectionsCollection = "__collections"
+	removedName           = "removed"
+
+	// collectionsRefresh is how long a collection registered, or unregistered, through another instance takes to be picked up
+	collectionsRefresh = 5 * time.Second
+)
+
+// ErrInvalidCollectionName is returned when registering a collection whose name could clash with the internal collections
+var ErrInvalidCollectionName = errors.New("invalid collection name, it should be made of letters, digits, - and _, without __")
+
+var collectionNameRegexp = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_-]{0,63}$")
+
+// Collection is a registered collection of native documents. Unregistered collections are kept as removed, with their documents,
+// so that the configured collections they were seeded from don't come back on the next start.
+type Collection struct {
+	Name    string     `bson:"_id"`
+	Created time.Time  `bson:"created"`
+	Removed *time.Time `bson:"removed,omitempty"`
+}
+
+// validCollectionName rejects the names with __, which the internal collections and the changes and versions of the native
+// collections are named with
+func validCollectionName(name string) bool {
+	return collectionNameRegexp.MatchString(name) && !strings.Contains(name, "__")
+}
+
+// collectionSet is the set of supported collections of a mongo connection, refreshed from the registry in the background
+type collectionSet struct {
+	mutex *sync.RWMutex
+	names map[string]bool
+}
+
+func newCollectionSet(names []string) *collectionSet {
+	return &collectionSet{mutex: &sync.RWMutex{}, names: createMapWithAllowedCollections(names)}
+}
+
+func (s *collectionSet) contains(name string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.names[name]
+}
+
+// get returns a copy of the set, which callers are free to range over while it is refreshed
+func (s *collectionSet) get() map[string]bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	names := make(map[string]bool, len(s.names))
+	for name := range s.names {
+		names[name] = true
+	}
+	return names
+}
+
+func (s *collectionSet) set(name string, supported bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if supported {
+		s.names[name] = true
+	} else {
+		delete(s.names, name)
+	}
+}
+
+func (s *collectionSet) reset(collections []*Collection) {
+	names := make(map[string]bool, len(collections))
+	for _, c := range collections {
+		names[c.Name] = true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.names = names
+}
+
+// seedCollections registers the configured collections which have never been, and loads the supported collections
+func (ma *mongoConnection) seedCollections(ctx context.Context, names []string) error {
+	for _, name := range names {
+		_, err := ma.collection(collectionsCollection).UpdateOne(ctx,
+			bson.D{{Key: "_id", Value: name}},
+			bson.D{{Key: "$setOnInsert", Value: bson.D{{Key: "created", Value: now()}}}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	collections, err := ma.ReadCollections()
+	if err != nil {
+		return err
+	}
+	ma.collections.reset(collections)
+	return nil
+}
+
+// refreshCollections picks up the collections registered, or unregistered, through other instances until the context is cancelled
+func (ma *mongoConnection) refreshCollections(ctx context.Context) {
+	ticker := time.NewTicker(collectionsRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		collections, err := ma.ReadCollections()
+		if err != nil {
+			logger.WithError(err).Error("Failed to refresh the supported collections")
+			continue
+		}
+		ma.collections.reset(collections)
+	}
+}
+
+// ReadCollections lists the registered collections by name
+func (ma *mongoConnection) ReadCollections() ([]*Collection, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	cursor, err := ma.collection(collectionsCollection).Find(ctx,
+		bson.D{{Key: removedName, Value: bson.D{{Key: "$exists", Value: false}}}},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	collections := make([]*Collection, 0)
+	if err = cursor.All(ctx, &collections); err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+// RegisterCollection creates the indexes of the collection, then registers it, unless it is registered already. A collection
+// whose indexes can't be created is left unregistered, so that no instance writes to it without its unique uuid index. A
+// collection which was unregistered is registered again with the documents it had.
+func (ma *mongoConnection) RegisterCollection(name string) (*Collection, bool, error) {
+	if !validCollectionName(name) {
+		return nil, false, ErrInvalidCollectionName
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	// the supported collections have their indexes already
+	if !ma.collections.contains(name) {
+		if err := ma.ensureIndexes(ctx, name); err != nil {
+			return nil, false, err
+		}
+	}
+
+	collection := &Collection{}
+	created := false
+	err := ma.withTransaction(func(ctx mongo.SessionContext) error {
+		err := ma.collection(collectionsCollection).FindOne(ctx, bson.D{{Key: "_id", Value: name}}).Decode(collection)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		created = err == mongo.ErrNoDocuments || collection.Removed != nil
+		if !created {
+			return nil
+		}
+
+		collection = &Collection{Name: name, Created: now()}
+		_, err = ma.collection(collectionsCollection).ReplaceOne(ctx, bson.D{{Key: "_id", Value: name}}, collection, options.Replace().SetUpsert(true))
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	ma.collections.set(name, true)
+	return collection, created, nil
+}
+
+// UnregisterCollection stops supporting the collection, but keeps its documents
+func (ma *mongoConnection) UnregisterCollection(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	res, err := ma.collection(collectionsCollection).UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: name}, {Key: removedName, Value: bson.D{{Key: "$exists", Value: false}}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: removedName, Value: now()}}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	ma.collections.set(name, false)
+	return nil
+}
+
+func (mc *memoryConnection) ReadCollections() ([]*Collection, error) {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	collections := make([]*Collection, 0, len(mc.collections))
+	for _, c := range mc.collections {
+		copied := *c
+		collections = append(collections, &copied)
+	}
+
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Name < collections[j].Name
+	})
+	return collections, nil
+}
+
+// RegisterCollection mirrors the mongo implementation, where the documents of an unregistered collection are kept as well
+func (mc *memoryConnection) RegisterCollection(name string) (*Collection, bool, error) {
+	if !validCollectionName(name) {
+		return nil, false, ErrInvalidCollectionName
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	if c, found := mc.collections[name]; found {
+		copied := *c
+		return &copied, false, nil
+	}
+
+	c := &Collection{Name: name, Created: now()}
+	mc.collections[name] = c
+
+	copied := *c
+	return &copied, true, nil
+}
+
+func (mc *memoryConnection) UnregisterCollection(name string) error {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	if _, found := mc.collections[name]; !found {
+		return ErrNotFound
+	}
+
+	delete(mc.collections, name)
+	return nil
+}
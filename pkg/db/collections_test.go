@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRegisterCollection(t *testing.T, connection Connection) {
+	// a unique name keeps the registry of the test mongo usable across runs
+	name := "test-" + uuid.New()[:8]
+	assert.False(t, connection.GetSupportedCollections()[name])
+
+	registered, created, err := connection.RegisterCollection(name)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, name, registered.Name)
+	assert.True(t, connection.GetSupportedCollections()[name])
+
+	again, created, err := connection.RegisterCollection(name)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.True(t, registered.Created.Equal(again.Created))
+
+	collections, err := connection.ReadCollections()
+	require.NoError(t, err)
+	assert.Contains(t, names(collections), name)
+	assert.Contains(t, names(collections), "methode", "the configured collections should be registered")
+
+	resource := generateResource()
+	require.NoError(t, connection.Write(name, resource, Precondition{}, nil))
+
+	sequence, err := connection.LastSequence(name)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), sequence, "the changes of a registered collection should be recorded")
+
+	require.NoError(t, connection.UnregisterCollection(name))
+	assert.False(t, connection.GetSupportedCollections()[name])
+	assert.Equal(t, ErrNotFound, connection.UnregisterCollection(name))
+
+	collections, err = connection.ReadCollections()
+	require.NoError(t, err)
+	assert.NotContains(t, names(collections), name)
+
+	_, created, err = connection.RegisterCollection(name)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	_, found, err := connection.Read(name, resource.UUID)
+	require.NoError(t, err)
+	assert.True(t, found, "the documents of an unregistered collection should be kept")
+
+	require.NoError(t, connection.UnregisterCollection(name))
+}
+
+func names(collections []*Collection) []string {
+	n := make([]string, 0, len(collections))
+	for _, c := range collections {
+		n = append(n, c.Name)
+	}
+	return n
+}
+
+func TestInMemoryRegisterCollection(t *testing.T) {
+	testRegisterCollection(t, openInMemory(t))
+}
+
+func TestRegisterCollection(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testRegisterCollection(t, connection)
+}
+
+func TestRegisterCollectionRejectsInvalidNames(t *testing.T) {
+	connection := openInMemory(t)
+
+	for _, name := range []string{"", "__collections", "methode__changes", "-methode", "new methode", "$methode", "methode.v2"} {
+		_, _, err := connection.RegisterCollection(name)
+		assert.Equal(t, ErrInvalidCollectionName, err, name)
+	}
+
+	for _, name := range []string{"v2-metadata", "pac_metadata", "Methode"} {
+		_, created, err := connection.RegisterCollection(name)
+		assert.NoError(t, err, name)
+		assert.True(t, created, name)
+	}
+}
+
+func TestRegisteredCollectionsAreRefreshed(t *testing.T) {
+	first, err := startMongo(t).Open()
+	require.NoError(t, err)
+	defer first.Close()
+
+	second, err := startMongo(t).Open()
+	require.NoError(t, err)
+	defer second.Close()
+
+	name := "test-" + uuid.New()[:8]
+	_, _, err = first.RegisterCollection(name)
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return second.GetSupportedCollections()[name] }, 3*collectionsRefresh, 100*time.Millisecond)
+
+	require.NoError(t, first.UnregisterCollection(name))
+	assert.Eventually(t, func() bool { return !second.GetSupportedCollections()[name] }, 3*collectionsRefresh, 100*time.Millisecond)
+}
+
+func TestRegisterCollectionFailsWithoutItsUUIDIndex(t *testing.T) {
+	connection, err := startMongo(t).Open()
+	require.NoError(t, err)
+	defer connection.Close()
+
+	// documents sharing a uuid keep the unique index from being built
+	name := "test-" + uuid.New()[:8]
+	resource := generateResource()
+	for range make([]struct{}, 2) {
+		_, err = connection.(*mongoConnection).collection(name).InsertOne(context.Background(), newDocument(resource, 1))
+		require.NoError(t, err)
+	}
+
+	_, _, err = connection.RegisterCollection(name)
+	assert.Error(t, err)
+	assert.False(t, connection.GetSupportedCollections()[name])
+
+	registered, err := connection.ReadCollections()
+	require.NoError(t, err)
+	for _, collection := range registered {
+		assert.NotEqual(t, name, collection.Name, "the collection shouldn't be registered")
+	}
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pborman/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Financial-Times/nativerw/pkg/config"
+)
+
+const (
+	compressionName      = "compression"
+	compressionCodecName = "compression.codec"
+)
+
+// compression marks a document whose content is stored compressed by the storage codec of its collection. Documents without it,
+// like the ones written before the collection had a codec, store their content as it is.
+type compression struct {
+	Codec string `bson:"codec"`
+	Size  int64  `bson:"size"` // of the content before it got compressed, encoded in bson
+}
+
+// the zstd encoder and decoder are safe for concurrent use, as long as they only compress whole buffers
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// compressedContent wraps the content which gets compressed, as bson only encodes documents on their own
+type compressedContent struct {
+	Content interface{} `bson:"content"`
+}
+
+// compress stores the content of the document compressed by the storage codec of the collection, if it has one
+func (ma *mongoConnection) compress(collection string, d *document) error {
+	return d.compress(ma.storageCodec(collection), ma.compressThreshold)
+}
+
+// compress stores the content of the document compressed by the codec, if there is one and the content, encoded in bson, is at
+// least threshold bytes long; smaller content is hardly any smaller compressed. Content stored in a blob is left alone.
+func (d *document) compress(codec string, threshold int64) error {
+	if codec == "" || d.Content == nil {
+		return nil
+	}
+
+	data, err := bson.Marshal(compressedContent{Content: d.Content})
+	if err != nil {
+		return err
+	}
+
+	if int64(len(data)) < threshold {
+		return nil
+	}
+
+	var compressed []byte
+	switch codec {
+	case config.StorageCodecZstd:
+		compressed = zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/2))
+	default:
+		return fmt.Errorf("unsupported storage codec %q", codec)
+	}
+
+	d.Content = primitive.Binary{Data: compressed}
+	d.Compression = &compression{Codec: codec, Size: int64(len(data))}
+	return nil
+}
+
+// decompress restores the content of a document stored compressed. The compression is kept, to tell how it was stored.
+func (d *document) decompress() error {
+	if d.Compression == nil {
+		return nil
+	}
+
+	compressed, ok := d.Content.(primitive.Binary)
+	if !ok {
+		return fmt.Errorf("the compressed content of document %s is not binary", uuid.UUID(d.UUID.Data))
+	}
+
+	var data []byte
+	var err error
+	switch d.Compression.Codec {
+	case config.StorageCodecZstd:
+		data, err = zstdDecoder.DecodeAll(compressed.Data, make([]byte, 0, d.Compression.Size))
+	default:
+		err = fmt.Errorf("unsupported storage codec %q", d.Compression.Codec)
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not decompress the content of document %s: %v", uuid.UUID(d.UUID.Data), err)
+	}
+
+	content := compressedContent{}
+	if err = bson.Unmarshal(data, &content); err != nil {
+		return err
+	}
+
+	d.Content = content.Content
+	return nil
+}
+
+// ReencodeContent stores the content of the live documents of the collection with its current storage codec, and returns how many
+// have been updated: they get compressed once the collection has a codec, unless they are under the storage codec threshold, and
+// decompressed if it no longer has one. Neither their revision nor their modification time change, as their content doesn't,
+// and a document written while the job is running is left alone, as the write stores it with the codec anyway. The versions are
+// not re-encoded, they are replaced as documents get written. It stops, with what it has done so far, once the context is done.
+func (ma *mongoConnection) ReencodeContent(ctx context.Context, collection string) (int64, error) {
+	codec := ma.storageCodec(collection)
+
+	filter := bson.D{{Key: compressionName, Value: bson.D{{Key: "$exists", Value: true}}}, notDeleted}
+	if codec != "" {
+		// the documents compressed by another codec, and the uncompressed ones a write would compress, which
+		// $bsonSize measures as compress does
+		filter = bson.D{
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: compressionCodecName, Value: bson.D{{Key: "$exists", Value: true}, {Key: "$ne", Value: codec}}}},
+				bson.D{
+					{Key: compressionName, Value: bson.D{{Key: "$exists", Value: false}}},
+					{Key: blobName, Value: bson.D{{Key: "$exists", Value: false}}},
+					{Key: "$expr", Value: bson.D{{Key: "$gte", Value: bson.A{
+						bson.D{{Key: "$bsonSize", Value: bson.D{{Key: contentName, Value: "$" + contentName}}}},
+						ma.compressThreshold,
+					}}}},
+				},
+			}},
+			notDeleted,
+		}
+	}
+
+	cursor, err := ma.collection(collection).Find(ctx, filter, options.Find().SetBatchSize(32))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var reencoded int64
+	for cursor.Next(ctx) {
+		doc := &document{}
+		if err = cursor.Decode(doc); err != nil {
+			return reencoded, err
+		}
+
+		if err = doc.decompress(); err != nil {
+			return reencoded, err
+		}
+
+		doc.Compression = nil
+		if err = ma.compress(collection, doc); err != nil {
+			return reencoded, err
+		}
+
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: contentName, Value: doc.Content}, {Key: compressionName, Value: doc.Compression}}}}
+		if doc.Compression == nil {
+			update = bson.D{
+				{Key: "$set", Value: bson.D{{Key: contentName, Value: doc.Content}}},
+				{Key: "$unset", Value: bson.D{{Key: compressionName, Value: ""}}},
+			}
+		}
+
+		res, err := ma.collection(collection).UpdateOne(ctx, append(bson.D{{Key: uuidName, Value: doc.UUID}, revisionFilter(doc.Revision)}, filter...), update)
+		if err != nil {
+			return reencoded, err
+		}
+		reencoded += res.ModifiedCount
+	}
+
+	return reencoded, cursor.Err()
+}
+
+// ReencodeContent does nothing, as the in memory store keeps the content as it is
+func (mc *memoryConnection) ReencodeContent(ctx context.Context, collection string) (int64, error) {
+	return 0, nil
+}
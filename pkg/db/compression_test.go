@@ -0,0 +1,208 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Financial-Times/nativerw/pkg/config"
+)
+
+// storeDocument compresses the document as a write would, whatever its size, and decodes it back as a read would
+func storeDocument(t *testing.T, doc *document, codec string) (stored bson.M, decoded *document) {
+	require.NoError(t, doc.compress(codec, 0))
+
+	data, err := bson.Marshal(doc)
+	require.NoError(t, err)
+	require.NoError(t, bson.Unmarshal(data, &stored))
+
+	decoded = &document{}
+	require.NoError(t, bson.Unmarshal(data, decoded))
+	require.NoError(t, decoded.decompress())
+	return stored, decoded
+}
+
+func TestCompressedDocumentRoundTrip(t *testing.T) {
+	resource := generateResource()
+	resource.Content = map[string]interface{}{
+		"title":  strings.Repeat("a title ", 100),
+		"nested": map[string]interface{}{"count": 10.4, "tags": []interface{}{"a", map[string]interface{}{"b": true}}},
+		"empty":  nil,
+	}
+
+	stored, decoded := storeDocument(t, newDocument(resource, 0), config.StorageCodecZstd)
+
+	content, ok := stored["content"].(primitive.Binary)
+	require.True(t, ok, "the content should be stored compressed")
+	assert.Equal(t, "zstd", stored["compression"].(bson.M)["codec"])
+	size := stored["compression"].(bson.M)["size"].(int64)
+	assert.Less(t, int64(len(content.Data)), size)
+
+	assert.Equal(t, resource, decoded.resource())
+	assert.Equal(t, &compression{Codec: config.StorageCodecZstd, Size: size}, decoded.Compression)
+}
+
+func TestCompressedBinaryRoundTrip(t *testing.T) {
+	resource := generateResource()
+	resource.Content = bytes.Repeat([]byte{0, 1, 2, 0xff}, 100)
+	resource.ContentType = "application/octet-stream"
+
+	_, decoded := storeDocument(t, newDocument(resource, 0), config.StorageCodecZstd)
+	assert.Equal(t, resource, decoded.resource())
+}
+
+func TestUncompressedDocument(t *testing.T) {
+	resource := generateResource()
+
+	stored, decoded := storeDocument(t, newDocument(resource, 0), "")
+	assert.NotContains(t, stored, "compression")
+	assert.IsType(t, bson.M{}, stored["content"])
+	assert.Equal(t, resource, decoded.resource())
+
+	doc := &document{UUID: bsonUUID(resource.UUID), Blob: &blob{ID: primitive.NewObjectID(), Size: 10}}
+	stored, _ = storeDocument(t, doc, config.StorageCodecZstd)
+	assert.NotContains(t, stored, "compression", "content stored in a blob is not compressed")
+}
+
+func TestCompressThreshold(t *testing.T) {
+	resource := generateResource()
+	resource.Content = map[string]interface{}{"title": "a title"}
+
+	doc := newDocument(resource, 0)
+	require.NoError(t, doc.compress(config.StorageCodecZstd, config.DefaultStorageCodecThreshold))
+	assert.Nil(t, doc.Compression, "content under the threshold should be stored as it is")
+	assert.Equal(t, resource.Content, doc.Content)
+
+	resource.Content = map[string]interface{}{"title": strings.Repeat("a title ", 200)}
+	doc = newDocument(resource, 0)
+	require.NoError(t, doc.compress(config.StorageCodecZstd, config.DefaultStorageCodecThreshold))
+	require.NotNil(t, doc.Compression)
+	assert.True(t, doc.Compression.Size >= config.DefaultStorageCodecThreshold)
+}
+
+func TestUnsupportedCodec(t *testing.T) {
+	assert.Error(t, newDocument(generateResource(), 0).compress("lz4", 0))
+
+	doc := &document{Content: primitive.Binary{Data: []byte("data")}, Compression: &compression{Codec: "lz4"}}
+	assert.Error(t, doc.decompress())
+
+	doc = &document{Content: primitive.Binary{Data: []byte("not zstd")}, Compression: &compression{Codec: config.StorageCodecZstd}}
+	assert.Error(t, doc.decompress())
+}
+
+// rawContent returns how the content of the document is stored
+func rawContent(t *testing.T, connection Connection, collection string, uuidString string) bson.M {
+	raw := bson.M{}
+	err := connection.(*mongoConnection).collection(collection).FindOne(context.Background(), uuidFilter(uuidString)).Decode(&raw)
+	require.NoError(t, err)
+	return raw
+}
+
+func TestCompression(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+	defer connection.Close()
+
+	collection := "compressed"
+	resource := generateResource()
+	resource.Content = map[string]interface{}{"title": strings.Repeat("a title ", 200), "body": map[string]interface{}{"text": "text"}}
+	require.NoError(t, connection.Write(collection, resource, Precondition{}, nil))
+
+	raw := rawContent(t, connection, collection, resource.UUID)
+	assert.IsType(t, primitive.Binary{}, raw["content"])
+	assert.Contains(t, raw, "compression")
+
+	res, found, err := connection.Read(collection, resource.UUID)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, resource.Content, res.Content)
+
+	many, err := connection.ReadMany(collection, []string{resource.UUID})
+	require.NoError(t, err)
+	require.Len(t, many, 1)
+	assert.Equal(t, resource.Content, many[0].Content)
+
+	hash, _, err := connection.ReadHash(collection, resource.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, resource.Hash, hash)
+
+	patch := generateResource()
+	patch.UUID = resource.UUID
+	patch.Content = map[string]interface{}{"body": map[string]interface{}{"text": "patched"}}
+	patched, err := connection.Patch(collection, patch, Precondition{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"title": strings.Repeat("a title ", 200), "body": map[string]interface{}{"text": "patched"}}, patched.Content)
+
+	res, _, err = connection.Read(collection, resource.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, patched.Content, res.Content)
+	assert.IsType(t, primitive.Binary{}, rawContent(t, connection, collection, resource.UUID)["content"])
+
+	require.NoError(t, connection.Delete(collection, resource.UUID, "tid_delete", Precondition{}))
+	require.NoError(t, connection.Restore(collection, resource.UUID, "tid_restore"))
+
+	res, _, err = connection.Read(collection, resource.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, patched.Content, res.Content)
+
+	stats, err := connection.Stats(collection)
+	require.NoError(t, err)
+	assert.True(t, stats.Compressed > 0)
+	assert.True(t, stats.UncompressedSize > stats.CompressedSize)
+}
+
+func TestReencodeContent(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+	defer connection.Close()
+
+	collection := "reencoded"
+	ma := connection.(*mongoConnection)
+	_, err = ma.collection(collection).DeleteMany(context.Background(), bson.D{})
+	require.NoError(t, err)
+
+	ma.storageCodec = func(string) string { return "" }
+	resource := generateResource()
+	resource.Content = map[string]interface{}{"title": strings.Repeat("a title ", 200)}
+	require.NoError(t, connection.Write(collection, resource, Precondition{}, nil))
+	assert.IsType(t, bson.M{}, rawContent(t, connection, collection, resource.UUID)["content"])
+
+	small := generateResource()
+	require.NoError(t, connection.Write(collection, small, Precondition{}, nil))
+
+	ma.storageCodec = func(string) string { return config.StorageCodecZstd }
+	reencoded, err := connection.ReencodeContent(context.Background(), collection)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), reencoded, "the content under the threshold shouldn't be compressed")
+	assert.NotContains(t, rawContent(t, connection, collection, small.UUID), "compression")
+
+	raw := rawContent(t, connection, collection, resource.UUID)
+	assert.IsType(t, primitive.Binary{}, raw["content"])
+	assert.Equal(t, resource.Revision, raw["revision"], "re-encoding doesn't change the document")
+
+	res, _, err := connection.Read(collection, resource.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, resource.Content, res.Content)
+
+	reencoded, err = connection.ReencodeContent(context.Background(), collection)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), reencoded)
+
+	ma.storageCodec = func(string) string { return "" }
+	reencoded, err = connection.ReencodeContent(context.Background(), collection)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), reencoded)
+	assert.NotContains(t, rawContent(t, connection, collection, resource.UUID), "compression")
+
+	res, _, err = connection.Read(collection, resource.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, resource.Content, res.Content)
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"time"
+
+	"github.com/pborman/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+// document is the stored shape of a native resource, which must stay compatible with the data written by previous releases
+type document struct {
+	UUID           primitive.Binary `bson:"uuid"`
+	Content        interface{}      `bson:"content"`
+	ContentType    string           `bson:"content-type"`
+	OriginSystemID string           `bson:"origin-system-id"`
+	TransactionID  string           `bson:"transaction-id,omitempty"`
+	Revision       int64            `bson:"revision,omitempty"`
+	Hash           string           `bson:"hash,omitempty"`
+	Created        time.Time        `bson:"created,omitempty"`
+	LastModified   time.Time        `bson:"last-modified,omitempty"`
+	Tombstone      *tombstone       `bson:"tombstone,omitempty"`
+	Blob           *blob            `bson:"blob,omitempty"`        // of the content, which is then not stored in the document
+	Compression    *compression     `bson:"compression,omitempty"` // of the content, which is then stored compressed
+}
+
+// tombstone marks a deleted document, which can still be restored until it is purged
+type tombstone struct {
+	DeletedAt     time.Time `bson:"deleted-at"`
+	TransactionID string    `bson:"transaction-id"`
+	Hash          string    `bson:"hash"`
+}
+
+func newDocument(resource *mapper.Resource, revision int64) *document {
+	return &document{
+		UUID:           bsonUUID(resource.UUID),
+		Content:        resource.Content,
+		ContentType:    resource.ContentType,
+		OriginSystemID: resource.OriginSystemID,
+		TransactionID:  resource.TransactionID,
+		Revision:       revision,
+		Hash:           resource.Hash,
+		Created:        resource.Created,
+		LastModified:   resource.LastModified,
+	}
+}
+
+func (d *document) resource() *mapper.Resource {
+	return &mapper.Resource{
+		UUID:           uuid.UUID(d.UUID.Data).String(),
+		Content:        fromBSON(d.Content),
+		ContentType:    d.ContentType,
+		OriginSystemID: d.OriginSystemID,
+		TransactionID:  d.TransactionID,
+		Revision:       d.Revision,
+		Hash:           d.Hash,
+		Created:        d.Created,
+		LastModified:   d.LastModified,
+	}
+}
+
+func bsonUUID(uuidString string) primitive.Binary {
+	return primitive.Binary{Subtype: bsontype.BinaryUUID, Data: []byte(uuid.Parse(uuidString))}
+}
+
+func uuidFilter(uuidString string) bson.D {
+	return bson.D{{Key: uuidName, Value: bsonUUID(uuidString)}}
+}
+
+// liveFilter matches the document unless it has been deleted
+func liveFilter(uuidString string) bson.D {
+	return append(uuidFilter(uuidString), notDeleted)
+}
+
+var notDeleted = bson.E{Key: tombstoneName, Value: bson.D{{Key: "$exists", Value: false}}}
+
+// fromBSON converts decoded bson values back into the plain shapes produced by the mappers (json objects, arrays and raw bytes)
+func fromBSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case primitive.D:
+		res := make(map[string]interface{}, len(v))
+		for _, e := range v {
+			res[e.Key] = fromBSON(e.Value)
+		}
+		return res
+	case primitive.M:
+		res := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			res[k] = fromBSON(e)
+		}
+		return res
+	case primitive.A:
+		res := make([]interface{}, len(v))
+		for i, e := range v {
+			res[i] = fromBSON(e)
+		}
+		return res
+	case primitive.Binary:
+		return v.Data
+	default:
+		return value
+	}
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDocumentRoundTrip(t *testing.T) {
+	resource := generateResource()
+	resource.Content = map[string]interface{}{
+		"title":  "a title",
+		"nested": map[string]interface{}{"count": 10.4, "tags": []interface{}{"a", map[string]interface{}{"b": true}}},
+		"empty":  nil,
+	}
+	resource.OriginSystemID = "methode-web-pub"
+
+	data, err := bson.Marshal(&document{
+		UUID:           bsonUUID(resource.UUID),
+		Content:        resource.Content,
+		ContentType:    resource.ContentType,
+		OriginSystemID: resource.OriginSystemID,
+	})
+	assert.NoError(t, err)
+
+	var raw bson.M
+	assert.NoError(t, bson.Unmarshal(data, &raw))
+	assert.Equal(t, byte(0x04), raw["uuid"].(primitive.Binary).Subtype)
+	assert.Contains(t, raw, "content")
+	assert.Contains(t, raw, "content-type")
+	assert.Contains(t, raw, "origin-system-id")
+
+	decoded := &document{}
+	assert.NoError(t, bson.Unmarshal(data, decoded))
+	assert.Equal(t, resource, decoded.resource())
+}
+
+func TestDocumentBinaryRoundTrip(t *testing.T) {
+	resource := generateResource()
+	resource.Content = []byte("some binary content")
+	resource.ContentType = "application/octet-stream"
+
+	data, err := bson.Marshal(&document{UUID: bsonUUID(resource.UUID), Content: resource.Content, ContentType: resource.ContentType})
+	assert.NoError(t, err)
+
+	decoded := &document{}
+	assert.NoError(t, bson.Unmarshal(data, decoded))
+	assert.Equal(t, resource, decoded.resource())
+}
+
+func TestDocumentWithoutOriginSystemID(t *testing.T) {
+	resource := generateResource()
+
+	data, err := bson.Marshal(bson.M{"uuid": bsonUUID(resource.UUID), "content": resource.Content, "content-type": resource.ContentType})
+	assert.NoError(t, err)
+
+	decoded := &document{}
+	assert.NoError(t, bson.Unmarshal(data, decoded))
+	assert.Equal(t, resource, decoded.resource())
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+const hashName = "hash"
+
+// setHash computes the hash of the content of the resource, which is stored alongside it so that it never needs computing on reads
+func setHash(resource *mapper.Resource) error {
+	hash, err := mapper.ContentHash(resource.Content)
+	if err != nil {
+		return err
+	}
+
+	resource.Hash = hash
+	return nil
+}
+
+// hashIndex lets hash lookups be answered from the index, without loading the document. It holds when the document was deleted
+// too, as the tombstone $exists: false of liveFilter can't be answered from an index, nor be the filter of a partial one.
+var hashIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: uuidName, Value: 1}, {Key: hashName, Value: 1}, {Key: tombstoneDeletedAtName, Value: 1}},
+	Options: options.Index().SetName("uuid-hash-tombstone-index").SetBackground(true),
+}
+
+// legacyHashIndex is the name of the previous hashIndex, without the tombstone, which is dropped as hash lookups don't use it
+const legacyHashIndex = "uuid-hash-index"
+
+// isIndexNotFound tells whether dropping an index failed as there is no such index, or collection
+func isIndexNotFound(err error) bool {
+	cmdErr, ok := err.(mongo.CommandError)
+	return ok && (cmdErr.Code == 27 || cmdErr.Code == 26)
+}
+
+// hashProjection only asks for fields of hashIndex, so that the lookup is covered by it
+var hashProjection = bson.D{{Key: hashName, Value: 1}, {Key: tombstoneDeletedAtName, Value: 1}, {Key: "_id", Value: 0}}
+
+// storedHash is what hashProjection reads: a covered query can't tell a missing field from a null one, hence the pointers
+type storedHash struct {
+	Hash      string `bson:"hash"`
+	Tombstone *struct {
+		DeletedAt *time.Time `bson:"deleted-at"`
+	} `bson:"tombstone"`
+}
+
+func (ma *mongoConnection) ReadHash(collection string, uuidString string) (hash string, found bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	// the lookup is by uuid alone, to be covered by hashIndex, and leaves out the deleted document itself
+	stored := &storedHash{}
+	if err = ma.collection(collection).FindOne(ctx, uuidFilter(uuidString), options.FindOne().SetProjection(hashProjection)).Decode(stored); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	if stored.Tombstone != nil && stored.Tombstone.DeletedAt != nil {
+		return "", false, nil
+	}
+
+	if stored.Hash != "" {
+		return stored.Hash, true, nil
+	}
+
+	// the document has been written by a previous release, and not backfilled yet
+	resource, found, err := ma.Read(collection, uuidString)
+	if err != nil || !found {
+		return "", found, err
+	}
+
+	hash, err = mapper.ContentHash(resource.Content)
+	return hash, err == nil, err
+}
+
+// BackfillHashes stores the hash of the documents which were written before hashes were, and returns how many have been updated.
+// A document which gets written while the backfill is running is left alone, as the write stores its hash anyway. It stops, with
+// what it has done so far, once the context is done.
+func (ma *mongoConnection) BackfillHashes(ctx context.Context, collection string) (int64, error) {
+	filter := bson.D{{Key: hashName, Value: bson.D{{Key: "$exists", Value: false}}}, notDeleted}
+	cursor, err := ma.collection(collection).Find(ctx, filter, options.Find().SetBatchSize(32))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var backfilled int64
+	for cursor.Next(ctx) {
+		doc := &document{}
+		if err = cursor.Decode(doc); err != nil {
+			return backfilled, err
+		}
+
+		if err = doc.decompress(); err != nil {
+			return backfilled, err
+		}
+
+		hash, err := mapper.ContentHash(fromBSON(doc.Content))
+		if err != nil {
+			return backfilled, err
+		}
+
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: hashName, Value: hash}}}}
+		res, err := ma.collection(collection).UpdateOne(ctx, append(bson.D{{Key: uuidName, Value: doc.UUID}, revisionFilter(doc.Revision)}, filter...), update)
+		if err != nil {
+			return backfilled, err
+		}
+		backfilled += res.ModifiedCount
+	}
+
+	return backfilled, cursor.Err()
+}
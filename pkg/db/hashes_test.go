@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func testHashes(t *testing.T, connection Connection) {
+	resource := generateResource()
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+
+	expected, err := mapper.ContentHash(resource.Content)
+	require.NoError(t, err)
+	assert.Equal(t, expected, resource.Hash)
+
+	res, found, err := connection.Read("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, expected, res.Hash)
+
+	hash, found, err := connection.ReadHash("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, expected, hash)
+
+	patched, err := connection.Patch("methode", &mapper.Resource{UUID: resource.UUID, Content: map[string]interface{}{"patched": true}, ContentType: "application/json"}, Precondition{}, nil)
+	require.NoError(t, err)
+
+	hash, _, err = connection.ReadHash("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.Equal(t, patched.Hash, hash)
+	assert.NotEqual(t, expected, hash)
+
+	require.NoError(t, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{}))
+	_, found, err = connection.ReadHash("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestInMemoryHashes(t *testing.T) {
+	testHashes(t, openInMemory(t))
+}
+
+func TestHashes(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testHashes(t, connection)
+}
+
+func TestBackfillHashes(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	// documents written by previous releases have no hash
+	resource := generateResource()
+	_, err = connection.(*mongoConnection).collection("methode").InsertOne(context.Background(), newDocument(resource, 0))
+	require.NoError(t, err)
+
+	expected, err := mapper.ContentHash(resource.Content)
+	require.NoError(t, err)
+
+	hash, found, err := connection.ReadHash("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, expected, hash)
+
+	backfilled, err := connection.BackfillHashes(context.Background(), "methode")
+	assert.NoError(t, err)
+	assert.True(t, backfilled >= 1)
+
+	res, found, err := connection.Read("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, expected, res.Hash)
+}
+
+func TestReadHashIsCovered(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+	connection.EnsureIndex()
+
+	resource := generateResource()
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+
+	ma := connection.(*mongoConnection)
+	explain := bson.D{
+		{Key: "explain", Value: bson.D{
+			{Key: "find", Value: "methode"},
+			{Key: "filter", Value: uuidFilter(resource.UUID)},
+			{Key: "projection", Value: hashProjection},
+			{Key: "limit", Value: 1},
+		}},
+		{Key: "verbosity", Value: "executionStats"},
+	}
+
+	var plan struct {
+		ExecutionStats struct {
+			NReturned         int `bson:"nReturned"`
+			TotalDocsExamined int `bson:"totalDocsExamined"`
+		} `bson:"executionStats"`
+	}
+	require.NoError(t, ma.client.Database(ma.dbName).RunCommand(context.Background(), explain).Decode(&plan))
+	assert.Equal(t, 1, plan.ExecutionStats.NReturned)
+	assert.Equal(t, 0, plan.ExecutionStats.TotalDocsExamined, "the hash lookup should be answered from the index")
+
+	require.NoError(t, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{}))
+	_, found, err := connection.ReadHash("methode", resource.UUID)
+	require.NoError(t, err)
+	assert.False(t, found)
+}
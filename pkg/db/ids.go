@@ -0,0 +1,162 @@
+package db
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/pborman/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+const (
+	contentTypeName    = "content-type"
+	originSystemIDName = "origin-system-id"
+
+	defaultIDsBatchSize = 32
+)
+
+// ID is a native document as listed by ReadIDs. An ID with an Err, and nothing else, ends the ids of a read that failed before
+// its last one.
+type ID struct {
+	UUID         string
+	Hash         string
+	LastModified time.Time
+	Err          error
+}
+
+// IDsQuery narrows down and pages through the ids read by ReadIDs, which come in uuid order
+type IDsQuery struct {
+	// After skips the ids up to and including this one, to carry on from the last id of a previous page
+	After string
+	// Limit caps the number of ids read, none if 0
+	Limit int64
+
+	// OriginSystemID and ContentType only match the documents with exactly the given values, unless empty
+	OriginSystemID string
+	ContentType    string
+	// ModifiedSince and ModifiedBefore only match the documents last modified within them, unless zero. Documents written by
+	// previous releases have no modification time, and never match either.
+	ModifiedSince  time.Time
+	ModifiedBefore time.Time
+
+	// Shard only matches the ids of one of Shards contiguous ranges of the uuid keyspace, unless Shards is 0. The shards of a
+	// collection add up to all of its ids, without overlapping.
+	Shard  int
+	Shards int
+
+	// BatchSize is the number of ids the cursor reads at once, defaultIDsBatchSize if 0
+	BatchSize int32
+}
+
+// shardBounds returns the first uuid of the shard, and the first one of the next shard. Either is nil when the shard starts or
+// ends the keyspace.
+func (q IDsQuery) shardBounds() (lower uuid.UUID, upper uuid.UUID) {
+	if q.Shards <= 1 {
+		return nil, nil
+	}
+
+	// the shards split the keyspace on the first 4 bytes of the uuids, which is plenty for any number of workers
+	bound := func(shard int) uuid.UUID {
+		id := make(uuid.UUID, 16)
+		binary.BigEndian.PutUint32(id, uint32((uint64(shard)<<32)/uint64(q.Shards)))
+		return id
+	}
+
+	if q.Shard > 0 {
+		lower = bound(q.Shard)
+	}
+	if q.Shard < q.Shards-1 {
+		upper = bound(q.Shard + 1)
+	}
+	return lower, upper
+}
+
+// lastModifiedIndex serves the queries for the documents modified within a period
+var lastModifiedIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: lastModifiedName, Value: 1}},
+	Options: options.Index().SetName("last-modified-index").SetBackground(true),
+}
+
+// filter matches the live documents of the query, after the one it carries on from
+func (q IDsQuery) filter() bson.D {
+	filter := bson.D{notDeleted}
+
+	ids := bson.D{}
+	if q.After != "" {
+		ids = append(ids, bson.E{Key: "$gt", Value: bsonUUID(q.After)})
+	}
+
+	lower, upper := q.shardBounds()
+	if lower != nil {
+		ids = append(ids, bson.E{Key: "$gte", Value: bsonUUID(lower.String())})
+	}
+	if upper != nil {
+		ids = append(ids, bson.E{Key: "$lt", Value: bsonUUID(upper.String())})
+	}
+
+	if len(ids) > 0 {
+		filter = append(filter, bson.E{Key: uuidName, Value: ids})
+	}
+
+	if q.OriginSystemID != "" {
+		filter = append(filter, bson.E{Key: originSystemIDName, Value: q.OriginSystemID})
+	}
+
+	if q.ContentType != "" {
+		filter = append(filter, bson.E{Key: contentTypeName, Value: q.ContentType})
+	}
+
+	modified := bson.D{}
+	if !q.ModifiedSince.IsZero() {
+		modified = append(modified, bson.E{Key: "$gte", Value: q.ModifiedSince})
+	}
+	if !q.ModifiedBefore.IsZero() {
+		modified = append(modified, bson.E{Key: "$lt", Value: q.ModifiedBefore})
+	}
+	if len(modified) > 0 {
+		filter = append(filter, bson.E{Key: lastModifiedName, Value: modified})
+	}
+	return filter
+}
+
+// options sorts the ids on the uuid index, which holds the same order as their strings as they all are binary uuids
+func (q IDsQuery) options() *options.FindOptions {
+	opts := options.Find().
+		SetProjection(bson.D{{Key: uuidName, Value: 1}, {Key: hashName, Value: 1}, {Key: lastModifiedName, Value: 1}}).
+		SetSort(bson.D{{Key: uuidName, Value: 1}}).
+		SetBatchSize(defaultIDsBatchSize)
+	if q.BatchSize > 0 {
+		opts.SetBatchSize(q.BatchSize)
+	}
+	if q.Limit > 0 {
+		opts.SetLimit(q.Limit)
+	}
+	return opts
+}
+
+// matches is filter for the documents kept in memory, whose ids are compared as strings, in the same order as uuids
+func (q IDsQuery) matches(id string, resource *mapper.Resource) bool {
+	lower, upper := q.shardBounds()
+
+	switch {
+	case q.After != "" && id <= uuid.Parse(q.After).String():
+		return false
+	case lower != nil && id < lower.String():
+		return false
+	case upper != nil && id >= upper.String():
+		return false
+	case q.OriginSystemID != "" && resource.OriginSystemID != q.OriginSystemID:
+		return false
+	case q.ContentType != "" && resource.ContentType != q.ContentType:
+		return false
+	case !q.ModifiedSince.IsZero() && (resource.LastModified.IsZero() || resource.LastModified.Before(q.ModifiedSince)):
+		return false
+	case !q.ModifiedBefore.IsZero() && (resource.LastModified.IsZero() || !resource.LastModified.Before(q.ModifiedBefore)):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,25 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardBounds(t *testing.T) {
+	lower, upper := IDsQuery{}.shardBounds()
+	assert.Nil(t, lower)
+	assert.Nil(t, upper)
+
+	lower, upper = IDsQuery{Shard: 0, Shards: 4}.shardBounds()
+	assert.Nil(t, lower)
+	assert.Equal(t, "40000000-0000-0000-0000-000000000000", upper.String())
+
+	lower, upper = IDsQuery{Shard: 1, Shards: 4}.shardBounds()
+	assert.Equal(t, "40000000-0000-0000-0000-000000000000", lower.String())
+	assert.Equal(t, "80000000-0000-0000-0000-000000000000", upper.String())
+
+	lower, upper = IDsQuery{Shard: 2, Shards: 3}.shardBounds()
+	assert.Equal(t, "aaaaaaaa-0000-0000-0000-000000000000", lower.String())
+	assert.Nil(t, upper)
+}
@@ -0,0 +1,659 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/Financial-Times/nativerw/pkg/config"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+type memoryDB struct {
+	config     *config.Configuration
+	connection *memoryConnection
+	mutex      *sync.Mutex
+}
+
+type memoryConnection struct {
+	collections map[string]*Collection
+	history     map[string]int
+	documents   map[string]map[string]*memoryDocument
+	versions    map[string]map[string][]*memoryVersion
+	changes     map[string][]*Change
+	purged      map[string]int64 // sequence of the last change purged from the changes of each collection
+	outboxes    map[string]*outboxDocument
+	subs        map[string]*Subscription
+	deliveries  map[string][]*Delivery
+	mutex       *sync.RWMutex
+
+	tombstoneRetention func(collection string) time.Duration // of the deleted documents of each collection
+}
+
+type memoryDocument struct {
+	resource  *mapper.Resource
+	tombstone *tombstone
+}
+
+type memoryVersion struct {
+	version  *Version
+	resource *mapper.Resource
+}
+
+// NewInMemoryDB returns a DB which keeps all native documents in process memory. It is intended for local runs and tests,
+// and nothing written to it survives a restart.
+func NewInMemoryDB(config *config.Configuration) DB {
+	return &memoryDB{config: config, mutex: &sync.Mutex{}}
+}
+
+func (m *memoryDB) Await() (Connection, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.connection == nil {
+		return nil, errNotOpened
+	}
+	return m.connection, nil
+}
+
+func (m *memoryDB) Open() (Connection, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.connection == nil {
+		m.connection = &memoryConnection{
+			collections: registerCollections(m.config.Collections),
+			history:     m.config.HistoryDepths(),
+			documents:   make(map[string]map[string]*memoryDocument),
+			versions:    make(map[string]map[string][]*memoryVersion),
+			changes:     make(map[string][]*Change),
+			purged:      make(map[string]int64),
+			outboxes:    make(map[string]*outboxDocument),
+			subs:        make(map[string]*Subscription),
+			deliveries:  make(map[string][]*Delivery),
+			mutex:       &sync.RWMutex{},
+
+			tombstoneRetention: m.config.RetentionPeriod,
+		}
+	}
+	return m.connection, nil
+}
+
+func registerCollections(names []string) map[string]*Collection {
+	collections := make(map[string]*Collection, len(names))
+	for _, name := range names {
+		collections[name] = &Collection{Name: name, Created: now()}
+	}
+	return collections
+}
+
+func (mc *memoryConnection) GetSupportedCollections() map[string]bool {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	names := make(map[string]bool, len(mc.collections))
+	for name := range mc.collections {
+		names[name] = true
+	}
+	return names
+}
+
+func (mc *memoryConnection) Close() {}
+
+func (mc *memoryConnection) EnsureIndex() {}
+
+func (mc *memoryConnection) Delete(collection string, uuidString string, tid string, precondition Precondition) error {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	doc, found := mc.documents[collection][uuid.Parse(uuidString).String()]
+	if !found || doc.tombstone != nil {
+		return ErrNotFound
+	}
+
+	if err := precondition.check(doc.resource); err != nil {
+		return err
+	}
+
+	ts, err := newTombstone(doc.resource, tid)
+	if err != nil {
+		return err
+	}
+
+	doc.tombstone = ts
+	mc.recordChange(collection, newChange(OperationDelete, doc.resource, ts.DeletedAt))
+	return nil
+}
+
+func (mc *memoryConnection) Restore(collection string, uuidString string, tid string) error {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	id := uuid.Parse(uuidString).String()
+	doc, found := mc.documents[collection][id]
+	if !found || doc.tombstone == nil || doc.tombstone.DeletedAt.Before(now().Add(-mc.tombstoneRetention(collection))) {
+		return ErrNotFound
+	}
+
+	restored := &memoryDocument{resource: copyResource(doc.resource, id)}
+	restored.resource.TransactionID = tid
+	restored.resource.Revision = mc.nextRevision(collection, id)
+	restored.resource.LastModified = now()
+	if err := setHash(restored.resource); err != nil {
+		return err
+	}
+	if err := mc.recordVersion(collection, restored); err != nil {
+		return err
+	}
+
+	mc.documents[collection][id] = restored
+	mc.recordChange(collection, newChange(OperationRestore, restored.resource, restored.resource.LastModified))
+	return nil
+}
+
+func (mc *memoryConnection) PurgeTombstones(collection string, deletedBefore time.Time) (int64, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	var purged int64
+	for id, doc := range mc.documents[collection] {
+		if doc.tombstone != nil && doc.tombstone.DeletedAt.Before(deletedBefore) {
+			delete(mc.documents[collection], id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (mc *memoryConnection) Write(collection string, resource *mapper.Resource, precondition Precondition, validator Validator) error {
+	if err := readStream(resource); err != nil {
+		return err
+	}
+
+	if err := setHash(resource); err != nil {
+		return err
+	}
+
+	if err := validator.validate(resource.Content); err != nil {
+		return err
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	id := uuid.Parse(resource.UUID).String()
+	var live *mapper.Resource
+	if current, found := mc.documents[collection][id]; found && current.tombstone == nil {
+		live = current.resource
+	}
+
+	if err := precondition.check(live); err != nil {
+		return err
+	}
+
+	return mc.put(collection, id, resource, live)
+}
+
+// put stores the resource over the live document, if any, and must be called with the write lock held
+func (mc *memoryConnection) put(collection string, id string, resource *mapper.Resource, live *mapper.Resource) error {
+	docs, found := mc.documents[collection]
+	if !found {
+		docs = make(map[string]*memoryDocument)
+		mc.documents[collection] = docs
+	}
+
+	doc := &memoryDocument{resource: copyResource(resource, id)}
+	doc.resource.Revision = mc.nextRevision(collection, id)
+	doc.resource.LastModified = now()
+	doc.resource.Created = doc.resource.LastModified
+	if live != nil {
+		doc.resource.Created = live.Created
+	}
+
+	if err := mc.recordVersion(collection, doc); err != nil {
+		return err
+	}
+
+	docs[id] = doc
+	mc.recordChange(collection, newChange(OperationPut, doc.resource, doc.resource.LastModified))
+
+	resource.Revision = doc.resource.Revision
+	resource.Created = doc.resource.Created
+	resource.LastModified = doc.resource.LastModified
+	return nil
+}
+
+func (mc *memoryConnection) Patch(collection string, resource *mapper.Resource, precondition Precondition, validator Validator) (*mapper.Resource, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	id := uuid.Parse(resource.UUID).String()
+	current, found := mc.documents[collection][id]
+	if !found || current.tombstone != nil {
+		return nil, ErrNotFound
+	}
+
+	if err := precondition.check(current.resource); err != nil {
+		return nil, err
+	}
+
+	if isBinary(current.resource.Content) {
+		return nil, ErrBinaryContent
+	}
+
+	patched := mapper.Wrap(patchContent(resource, current.resource.Content), id, resource.ContentType, resource.OriginSystemID)
+	if err := validator.validate(patched.Content); err != nil {
+		return nil, err
+	}
+
+	patched.TransactionID = resource.TransactionID
+	patched.Revision = mc.nextRevision(collection, id)
+	patched.Created = current.resource.Created
+	patched.LastModified = now()
+	if err := setHash(patched); err != nil {
+		return nil, err
+	}
+
+	doc := &memoryDocument{resource: patched}
+	if err := mc.recordVersion(collection, doc); err != nil {
+		return nil, err
+	}
+
+	mc.documents[collection][id] = doc
+	mc.recordChange(collection, newChange(OperationPatch, patched, patched.LastModified))
+	return copyResource(patched, id), nil
+}
+
+func (mc *memoryConnection) Read(collection string, uuidString string) (res *mapper.Resource, found bool, err error) {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	doc, found := mc.documents[collection][uuid.Parse(uuidString).String()]
+	if !found || doc.tombstone != nil {
+		return res, false, nil
+	}
+
+	return copyResource(doc.resource, doc.resource.UUID), true, nil
+}
+
+func (mc *memoryConnection) ReadMany(collection string, uuids []string) ([]*mapper.Resource, error) {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	resources := make([]*mapper.Resource, 0, len(uuids))
+	for _, uuidString := range uuids {
+		id := uuid.Parse(uuidString).String()
+		if doc, found := mc.documents[collection][id]; found && doc.tombstone == nil {
+			resources = append(resources, copyResource(doc.resource, id))
+		}
+	}
+	return resources, nil
+}
+
+func (mc *memoryConnection) ReadHash(collection string, uuidString string) (hash string, found bool, err error) {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	doc, found := mc.documents[collection][uuid.Parse(uuidString).String()]
+	if !found || doc.tombstone != nil {
+		return "", false, nil
+	}
+
+	return doc.resource.Hash, true, nil
+}
+
+// BackfillHashes has nothing to do, as every document in memory has been written with its hash
+func (mc *memoryConnection) BackfillHashes(ctx context.Context, collection string) (int64, error) {
+	return 0, nil
+}
+
+// nextRevision mirrors the mongo implementation, and must be called with the write lock held
+func (mc *memoryConnection) nextRevision(collection string, id string) int64 {
+	var revision int64
+	if doc, found := mc.documents[collection][id]; found {
+		revision = doc.resource.Revision
+	}
+
+	if versions := mc.versions[collection][id]; len(versions) > 0 && versions[len(versions)-1].version.Version > revision {
+		revision = versions[len(versions)-1].version.Version
+	}
+
+	return revision + 1
+}
+
+// recordVersion mirrors the mongo implementation, and must be called with the write lock held
+func (mc *memoryConnection) recordVersion(collection string, doc *memoryDocument) error {
+	max := mc.history[collection]
+	if max <= 0 {
+		return nil
+	}
+
+	if _, found := mc.versions[collection]; !found {
+		mc.versions[collection] = make(map[string][]*memoryVersion)
+	}
+
+	id := doc.resource.UUID
+	versions := append(mc.versions[collection][id], &memoryVersion{
+		version: &Version{
+			Version:        doc.resource.Revision,
+			Timestamp:      now(),
+			Hash:           doc.resource.Hash,
+			ContentType:    doc.resource.ContentType,
+			OriginSystemID: doc.resource.OriginSystemID,
+			TransactionID:  doc.resource.TransactionID,
+		},
+		resource: copyResource(doc.resource, id),
+	})
+
+	if len(versions) > max {
+		versions = versions[len(versions)-max:]
+	}
+	mc.versions[collection][id] = versions
+	return nil
+}
+
+func (mc *memoryConnection) ReadVersions(collection string, uuidString string) ([]*Version, error) {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	stored := mc.versions[collection][uuid.Parse(uuidString).String()]
+	versions := make([]*Version, 0, len(stored))
+	for i := len(stored) - 1; i >= 0; i-- {
+		version := *stored[i].version
+		versions = append(versions, &version)
+	}
+
+	return versions, nil
+}
+
+func (mc *memoryConnection) ReadVersion(collection string, uuidString string, version int64) (res *mapper.Resource, found bool, err error) {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	for _, v := range mc.versions[collection][uuid.Parse(uuidString).String()] {
+		if v.version.Version == version {
+			return copyResource(v.resource, v.resource.UUID), true, nil
+		}
+	}
+
+	return res, false, nil
+}
+
+func (mc *memoryConnection) ReadIDs(ctx context.Context, collection string, query IDsQuery) (chan *ID, error) {
+	mc.mutex.RLock()
+	snapshot := make([]*ID, 0, len(mc.documents[collection]))
+	for id, doc := range mc.documents[collection] {
+		if doc.tombstone == nil && query.matches(id, doc.resource) {
+			snapshot = append(snapshot, &ID{UUID: id, Hash: doc.resource.Hash, LastModified: doc.resource.LastModified})
+		}
+	}
+	mc.mutex.RUnlock()
+
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].UUID < snapshot[j].UUID })
+	if query.Limit > 0 && int64(len(snapshot)) > query.Limit {
+		snapshot = snapshot[:query.Limit]
+	}
+	ids := make(chan *ID, 8)
+
+	go func() {
+		defer close(ids)
+
+		for _, id := range snapshot {
+			if err := ctx.Err(); err != nil {
+				return
+			}
+
+			select {
+			case ids <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ids, nil
+}
+
+// recordChange numbers the change right away, as the writes are serialized by the lock anyway, and must be called with the write
+// lock held
+func (mc *memoryConnection) recordChange(collection string, change *Change) {
+	if mc.collections[collection] == nil {
+		return
+	}
+
+	change.Sequence = mc.purged[collection] + int64(len(mc.changes[collection])) + 1
+	mc.changes[collection] = append(mc.changes[collection], change)
+}
+
+func (mc *memoryConnection) ReadChanges(ctx context.Context, collection string, since int64) (chan *Change, error) {
+	changes := make(chan *Change, 8)
+
+	mc.mutex.RLock()
+	purged := mc.purged[collection]
+	if since > 0 && since < purged {
+		mc.mutex.RUnlock()
+		return changes, ErrChangesPurged
+	}
+
+	var snapshot []*Change
+	if from := since - purged; from < int64(len(mc.changes[collection])) {
+		if from < 0 {
+			from = 0
+		}
+		snapshot = append(snapshot, mc.changes[collection][from:]...)
+	}
+	mc.mutex.RUnlock()
+
+	go func() {
+		defer close(changes)
+
+		for _, change := range snapshot {
+			c := *change
+			select {
+			case changes <- &c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+func (mc *memoryConnection) ClaimOutbox(name string, owner string, from int64, until time.Time) (int64, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	outbox, found := mc.outboxes[name]
+	if !found {
+		outbox = &outboxDocument{Name: name, Position: from}
+		mc.outboxes[name] = outbox
+	} else if outbox.Owner != owner && !outbox.LeaseUntil.Before(now()) {
+		return 0, ErrOutboxClaimed
+	}
+
+	outbox.Owner = owner
+	outbox.LeaseUntil = until
+	return outbox.Position, nil
+}
+
+func (mc *memoryConnection) AdvanceOutbox(name string, owner string, sequence int64) error {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	outbox, found := mc.outboxes[name]
+	if !found || outbox.Owner != owner {
+		return ErrOutboxClaimed
+	}
+
+	if sequence > outbox.Position {
+		outbox.Position = sequence
+	}
+	return nil
+}
+
+func (mc *memoryConnection) LastSequence(collection string) (int64, error) {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	return mc.purged[collection] + int64(len(mc.changes[collection])), nil
+}
+
+func (mc *memoryConnection) PurgeChanges(collection string, before time.Time) (int64, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	kept := mc.changes[collection]
+	purged := mc.purged[collection]
+
+	cutoff := purged + int64(len(kept))
+	for i, change := range kept {
+		if !change.Timestamp.Before(before) {
+			cutoff = purged + int64(i)
+			break
+		}
+	}
+
+	subscriptions := make([]*Subscription, 0, len(mc.subs))
+	for _, subscription := range mc.subs {
+		subscriptions = append(subscriptions, subscription)
+	}
+	if consumed := consumedUpTo(collection, subscriptions, mc.outboxes, before); consumed < cutoff {
+		cutoff = consumed
+	}
+	if cutoff <= purged {
+		return 0, nil
+	}
+
+	n := cutoff - purged
+	mc.changes[collection] = append([]*Change(nil), kept[n:]...)
+	mc.purged[collection] = cutoff
+	return n, nil
+}
+
+func (mc *memoryConnection) CreateSubscription(subscription *Subscription) error {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	s := *subscription
+	mc.subs[s.ID] = &s
+	return nil
+}
+
+func (mc *memoryConnection) ReadSubscriptions() ([]*Subscription, error) {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	subscriptions := make([]*Subscription, 0, len(mc.subs))
+	for _, subscription := range mc.subs {
+		s := *subscription
+		subscriptions = append(subscriptions, &s)
+	}
+
+	sort.SliceStable(subscriptions, func(i, j int) bool {
+		if subscriptions[i].Created.Equal(subscriptions[j].Created) {
+			return subscriptions[i].ID < subscriptions[j].ID
+		}
+		return subscriptions[i].Created.Before(subscriptions[j].Created)
+	})
+	return subscriptions, nil
+}
+
+func (mc *memoryConnection) ReadSubscription(id string) (*Subscription, bool, error) {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	subscription, found := mc.subs[id]
+	if !found {
+		return nil, false, nil
+	}
+
+	s := *subscription
+	return &s, true, nil
+}
+
+func (mc *memoryConnection) DeleteSubscription(id string) error {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	if _, found := mc.subs[id]; !found {
+		return ErrNotFound
+	}
+
+	delete(mc.subs, id)
+	delete(mc.deliveries, id)
+	return nil
+}
+
+// RecordDelivery mirrors the mongo implementation
+func (mc *memoryConnection) RecordDelivery(delivery *Delivery) error {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	d := *delivery
+	kept := make([]*Delivery, 0, len(mc.deliveries[d.Subscription])+1)
+	for _, previous := range mc.deliveries[d.Subscription] {
+		if previous.Status != DeliveryDelivered || previous.Sequence > d.Sequence-maxDeliveries {
+			kept = append(kept, previous)
+		}
+	}
+	mc.deliveries[d.Subscription] = append(kept, &d)
+	return nil
+}
+
+func (mc *memoryConnection) ReadDeliveries(id string, status string) ([]*Delivery, error) {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	deliveries := make([]*Delivery, 0)
+	for _, delivery := range mc.deliveries[id] {
+		if status == "" || delivery.Status == status {
+			d := *delivery
+			deliveries = append(deliveries, &d)
+		}
+	}
+
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].Sequence > deliveries[j].Sequence
+	})
+	return deliveries, nil
+}
+
+func copyResource(resource *mapper.Resource, id string) *mapper.Resource {
+	return &mapper.Resource{
+		UUID:           id,
+		Content:        copyContent(resource.Content),
+		ContentType:    resource.ContentType,
+		OriginSystemID: resource.OriginSystemID,
+		TransactionID:  resource.TransactionID,
+		Revision:       resource.Revision,
+		Hash:           resource.Hash,
+		Created:        resource.Created,
+		LastModified:   resource.LastModified,
+	}
+}
+
+// copyContent deep copies the json and binary shapes produced by the mappers, so that callers never share state with the store
+func copyContent(content interface{}) interface{} {
+	switch c := content.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(c))
+		for k, v := range c {
+			res[k] = copyContent(v)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(c))
+		for i, v := range c {
+			res[i] = copyContent(v)
+		}
+		return res
+	case []byte:
+		return append([]byte{}, c...)
+	default:
+		return content
+	}
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/config"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func openInMemory(t *testing.T) Connection {
+	memory := NewInMemoryDB(&config.Configuration{Collections: []string{"methode"}})
+	connection, err := memory.Open()
+	require.NoError(t, err)
+	return connection
+}
+
+func TestNewSelectsBackend(t *testing.T) {
+	mongo, err := New(&config.Configuration{})
+	assert.NoError(t, err)
+	assert.IsType(t, &mongoDB{}, mongo)
+
+	memory, err := New(&config.Configuration{Backend: InMemoryBackend})
+	assert.NoError(t, err)
+	assert.IsType(t, &memoryDB{}, memory)
+
+	_, err = New(&config.Configuration{Backend: "cassandra"})
+	assert.Error(t, err)
+}
+
+func TestInMemoryReadWriteDelete(t *testing.T) {
+	connection := openInMemory(t)
+	defer connection.Close()
+
+	expectedResource := generateResource()
+	expectedResource.OriginSystemID = "methode-web-pub"
+
+	err := connection.Write("methode", expectedResource, Precondition{}, nil)
+	assert.NoError(t, err)
+
+	res, found, err := connection.Read("methode", expectedResource.UUID)
+	assert.True(t, found)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedResource, res)
+
+	err = connection.Delete("methode", expectedResource.UUID, "tid_delete", Precondition{})
+	assert.NoError(t, err)
+
+	_, found, err = connection.Read("methode", expectedResource.UUID)
+	assert.False(t, found)
+	assert.NoError(t, err)
+}
+
+func TestInMemoryReadMany(t *testing.T) {
+	testReadMany(t, openInMemory(t))
+}
+
+func TestInMemoryWriteUpsertsByUUID(t *testing.T) {
+	connection := openInMemory(t)
+
+	resource := generateResource()
+	assert.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+
+	updated := &mapper.Resource{UUID: strings.ToUpper(resource.UUID), Content: []byte("binary"), ContentType: "application/octet-stream"}
+	assert.NoError(t, connection.Write("methode", updated, Precondition{}, nil))
+
+	res, found, err := connection.Read("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, resource.UUID, res.UUID)
+	assert.Equal(t, []byte("binary"), res.Content)
+	assert.Equal(t, "application/octet-stream", res.ContentType)
+}
+
+func TestInMemoryDoesNotShareContent(t *testing.T) {
+	connection := openInMemory(t)
+
+	content := map[string]interface{}{"nested": map[string]interface{}{"list": []interface{}{"a"}}}
+	resource := &mapper.Resource{UUID: generateResource().UUID, Content: content, ContentType: "application/json"}
+	assert.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+
+	content["nested"].(map[string]interface{})["list"] = []interface{}{"b"}
+
+	res, _, err := connection.Read("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"nested": map[string]interface{}{"list": []interface{}{"a"}}}, res.Content)
+
+	res.Content.(map[string]interface{})["nested"] = "changed by the caller"
+
+	res, _, err = connection.Read("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"nested": map[string]interface{}{"list": []interface{}{"a"}}}, res.Content)
+}
+
+func TestInMemoryReadNotFound(t *testing.T) {
+	connection := openInMemory(t)
+
+	_, found, err := connection.Read("methode", generateResource().UUID)
+	assert.False(t, found)
+	assert.NoError(t, err)
+}
+
+func TestInMemoryDeleteNotFound(t *testing.T) {
+	connection := openInMemory(t)
+
+	err := connection.Delete("methode", generateResource().UUID, "tid_delete", Precondition{})
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestInMemoryGetSupportedCollections(t *testing.T) {
+	connection := openInMemory(t)
+	assert.Equal(t, map[string]bool{"methode": true}, connection.GetSupportedCollections())
+}
+
+func TestInMemoryAwait(t *testing.T) {
+	memory := NewInMemoryDB(&config.Configuration{})
+
+	_, err := memory.Await()
+	assert.Error(t, err)
+
+	opened, err := memory.Open()
+	assert.NoError(t, err)
+
+	awaited, err := memory.Await()
+	assert.NoError(t, err)
+	assert.True(t, opened == awaited)
+}
+
+func TestInMemoryReadIDs(t *testing.T) {
+	connection := openInMemory(t)
+
+	expected := make(map[string]bool)
+	for range make([]struct{}, 64) {
+		resource := generateResource()
+		expected[resource.UUID] = true
+		assert.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+	}
+
+	ids, err := connection.ReadIDs(context.Background(), "methode", IDsQuery{})
+	assert.NoError(t, err)
+
+	actual := make(map[string]bool)
+	for id := range ids {
+		actual[id.UUID] = true
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func TestInMemoryReadIDsPages(t *testing.T) {
+	testReadIDsPages(t, openInMemory(t))
+}
+
+func TestInMemoryReadIDsFilters(t *testing.T) {
+	testReadIDsFilters(t, openInMemory(t))
+}
+
+func TestInMemoryReadIDsShards(t *testing.T) {
+	testReadIDsShards(t, openInMemory(t))
+}
+
+func TestInMemoryCancelReadIDs(t *testing.T) {
+	connection := openInMemory(t)
+
+	for range make([]struct{}, 64) {
+		assert.NoError(t, connection.Write("methode", generateResource(), Precondition{}, nil))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ids, err := connection.ReadIDs(ctx, "methode", IDsQuery{})
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond) // allow the channel to fill
+
+	id := <-ids
+	assert.NotEqual(t, "", id.UUID)
+	cancel()
+
+	count := 0
+	for range ids {
+		count++
+	}
+	assert.True(t, count <= 9, "expected at most the channel buffer and one in-flight id, got %d", count)
+}
+
+func TestInMemoryConcurrentWrites(t *testing.T) {
+	connection := openInMemory(t)
+
+	wg := &sync.WaitGroup{}
+	for range make([]struct{}, 32) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resource := generateResource()
+			assert.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+			_, found, err := connection.Read("methode", resource.UUID)
+			assert.NoError(t, err)
+			assert.True(t, found)
+		}()
+	}
+	wg.Wait()
+
+	ids, err := connection.ReadIDs(context.Background(), "methode", IDsQuery{})
+	assert.NoError(t, err)
+
+	count := 0
+	for range ids {
+		count++
+	}
+	assert.Equal(t, 32, count)
+}
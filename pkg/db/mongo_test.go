@@ -1,14 +1,22 @@
 package db
 
 import (
+	"context"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
 	"github.com/Financial-Times/nativerw/pkg/config"
 )
 
-func startMongo(t *testing.T) DB {
+var initiateReplicaSet = &sync.Once{}
+
+func startMongo(t testing.TB) DB {
 	if testing.Short() {
 		t.Skip("Mongo integration for long tests only.")
 	}
@@ -18,13 +26,42 @@ func startMongo(t *testing.T) DB {
 		t.Fatal("Please set the environment variable MONGO_TEST_URL to run mongo integration tests (e.g. export MONGO_TEST_URL=localhost:27017). Alternatively, run `go test -short` to skip them.")
 	}
 
+	initiateReplicaSet.Do(func() {
+		ensureReplicaSet(t, mongoURL)
+	})
+
 	conf := config.Configuration{
 		Mongos:      mongoURL,
 		DbName:      "native-store",
 		Collections: []string{"methode"},
+		History:     map[string]int{"methode": 3},
+		Policies:    map[string]*config.Policy{"compressed": {StorageCodec: config.StorageCodecZstd}},
+
+		BlobThreshold: 1 << 10,
 	}
 
 	mgo := NewDBConnection(&conf)
 
 	return mgo
 }
+
+// ensureReplicaSet initiates a single node replica set if the test mongo was started with --replSet, as writes need transactions
+func ensureReplicaSet(t testing.TB, mongoURL string) {
+	ctx := context.Background()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI(mongoURL)).SetDirect(true))
+	if err != nil {
+		t.Fatalf("could not connect to the test mongo: %v", err)
+	}
+	defer client.Disconnect(ctx)
+
+	host := strings.TrimPrefix(mongoURI(mongoURL), "mongodb://")
+	cmd := bson.D{{Key: "replSetInitiate", Value: bson.D{
+		{Key: "_id", Value: "rs0"},
+		{Key: "members", Value: bson.A{bson.D{{Key: "_id", Value: 0}, {Key: "host", Value: host}}}},
+	}}}
+
+	if err = client.Database("admin").RunCommand(ctx, cmd).Err(); err != nil {
+		t.Logf("replica set not initiated: %v", err)
+	}
+}
@@ -4,19 +4,42 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/pborman/uuid"
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 
 	"github.com/Financial-Times/go-logger"
 	"github.com/Financial-Times/nativerw/pkg/config"
 	"github.com/Financial-Times/nativerw/pkg/mapper"
 )
 
-const uuidName = "uuid"
+const (
+	uuidName     = "uuid"
+	mongoTimeout = 30 * time.Second
+)
+
+// minServerVersion is the oldest MongoDB release with $bsonSize and $binarySize, which the stats and the compression job need
+var minServerVersion = []int32{4, 4}
+
+const (
+	// MongoBackend stores native documents in mongoDB, and is the default
+	MongoBackend = "mongo"
+	// InMemoryBackend keeps native documents in process memory, for local runs and tests
+	InMemoryBackend = "memory"
+)
+
+var (
+	// ErrNotFound is returned when the native document to act upon does not exist
+	ErrNotFound = errors.New("not found")
+
+	errNotOpened = errors.New("please Open() a new connection before awaiting")
+)
 
 type mongoDB struct {
 	config     *config.Configuration
@@ -25,8 +48,16 @@ type mongoDB struct {
 
 type mongoConnection struct {
 	dbName      string
-	session     *mgo.Session
-	collections map[string]bool
+	client      *mongo.Client
+	collections *collectionSet
+	history     map[string]int
+
+	blobThreshold      int64                                 // size from which octet streams are stored in blobs
+	storageCodec       func(collection string) string        // compressing the content of the documents of each collection
+	compressThreshold  int64                                 // size of the content, encoded in bson, from which it is compressed
+	statsSampleFrom    int64                                 // number of documents from which the stats are estimated from a sample
+	tombstoneRetention func(collection string) time.Duration // of the deleted documents of each collection
+	stopRefresh        context.CancelFunc
 }
 
 // DB handles opening the initial connection to Mongo
@@ -39,13 +70,51 @@ type DB interface {
 type Connection interface {
 	EnsureIndex()
 	GetSupportedCollections() map[string]bool
-	Delete(collection string, uuidString string) error
-	Write(collection string, resource *mapper.Resource) error
+	ReadCollections() ([]*Collection, error)
+	RegisterCollection(name string) (*Collection, bool, error)
+	UnregisterCollection(name string) error
+	Delete(collection string, uuidString string, tid string, precondition Precondition) error
+	Restore(collection string, uuidString string, tid string) error
+	PurgeTombstones(collection string, deletedBefore time.Time) (int64, error)
+	PurgeBlobs(collection string, uploadedBefore time.Time) (int64, error)
+	Write(collection string, resource *mapper.Resource, precondition Precondition, validator Validator) error
+	BulkWrite(collection string, resources []*mapper.Resource) ([]bool, error)
+	Patch(collection string, resource *mapper.Resource, precondition Precondition, validator Validator) (*mapper.Resource, error)
 	Read(collection string, uuidString string) (res *mapper.Resource, found bool, err error)
-	ReadIDs(ctx context.Context, collection string) (chan string, error)
+	ReadMany(collection string, uuids []string) ([]*mapper.Resource, error)
+	ReadHash(collection string, uuidString string) (hash string, found bool, err error)
+	BackfillHashes(ctx context.Context, collection string) (int64, error)
+	ReencodeContent(ctx context.Context, collection string) (int64, error)
+	ReadIDs(ctx context.Context, collection string, query IDsQuery) (chan *ID, error)
+	ReadChanges(ctx context.Context, collection string, since int64) (chan *Change, error)
+	LastSequence(collection string) (int64, error)
+	PurgeChanges(collection string, before time.Time) (int64, error)
+	Stats(collection string) (*Stats, error)
+	ClaimOutbox(name string, owner string, from int64, until time.Time) (int64, error)
+	AdvanceOutbox(name string, owner string, sequence int64) error
+	CreateSubscription(subscription *Subscription) error
+	ReadSubscriptions() ([]*Subscription, error)
+	ReadSubscription(id string) (*Subscription, bool, error)
+	DeleteSubscription(id string) error
+	RecordDelivery(delivery *Delivery) error
+	ReadDeliveries(id string, status string) ([]*Delivery, error)
+	ReadVersions(collection string, uuidString string) ([]*Version, error)
+	ReadVersion(collection string, uuidString string, version int64) (res *mapper.Resource, found bool, err error)
 	Close()
 }
 
+// New returns the DB implementation selected by the configured backend
+func New(config *config.Configuration) (DB, error) {
+	switch config.Backend {
+	case "", MongoBackend:
+		return NewDBConnection(config), nil
+	case InMemoryBackend:
+		return NewInMemoryDB(config), nil
+	default:
+		return nil, fmt.Errorf("unsupported db backend %q", config.Backend)
+	}
+}
+
 // NewDBConnection dials the mongo cluster, and returns a new handler DB instance
 func NewDBConnection(config *config.Configuration) DB {
 	return &mongoDB{config: config}
@@ -53,7 +122,7 @@ func NewDBConnection(config *config.Configuration) DB {
 
 func (m *mongoDB) Await() (Connection, error) {
 	if m.connection == nil {
-		return nil, errors.New("please Open() a new connection before awaiting")
+		return nil, errNotOpened
 	}
 
 	if m.connection.Nil() {
@@ -95,24 +164,107 @@ func (m *mongoDB) Open() (Connection, error) {
 }
 
 func (m *mongoDB) openMongoSession() (*mongoConnection, error) {
-	session, err := mgo.DialWithTimeout(m.config.Mongos, 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	opts := options.Client().
+		ApplyURI(mongoURI(m.config.Mongos)).
+		SetConnectTimeout(30 * time.Second).
+		SetReadPreference(readpref.Primary())
+
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	session.SetMode(mgo.Strong, true)
-	collections := createMapWithAllowedCollections(m.config.Collections)
-	connection := &mongoConnection{m.config.DbName, session, collections}
+	if err = client.Ping(ctx, readpref.Primary()); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	connection := &mongoConnection{
+		dbName:      m.config.DbName,
+		client:      client,
+		collections: newCollectionSet(m.config.Collections),
+		history:     m.config.HistoryDepths(),
+
+		blobThreshold:      m.config.BlobSizeThreshold(),
+		storageCodec:       m.config.StorageCodec,
+		compressThreshold:  m.config.StorageCodecSizeThreshold(),
+		statsSampleFrom:    defaultStatsSampleFrom,
+		tombstoneRetention: m.config.RetentionPeriod,
+	}
+
+	connection.checkServerVersion(ctx)
+
+	if err = connection.seedCollections(ctx, m.config.Collections); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	refreshCtx, stopRefresh := context.WithCancel(context.Background())
+	connection.stopRefresh = stopRefresh
+	go connection.refreshCollections(refreshCtx)
 
 	return connection, nil
 }
 
+// checkServerVersion warns when the server is older than minServerVersion, which leaves everything but the stats and the
+// compression job working
+func (ma *mongoConnection) checkServerVersion(ctx context.Context) {
+	var buildInfo struct {
+		Version      string  `bson:"version"`
+		VersionArray []int32 `bson:"versionArray"`
+	}
+	if err := ma.client.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&buildInfo); err != nil {
+		logger.WithError(err).Warn("could not read the version of mongoDB")
+		return
+	}
+
+	if olderThan(buildInfo.VersionArray, minServerVersion) {
+		logger.Warnf("mongoDB %s is older than %d.%d, the stats and the compression of stored documents will fail", buildInfo.Version, minServerVersion[0], minServerVersion[1])
+	}
+}
+
+// olderThan compares versions component by component, from the major one
+func olderThan(version []int32, min []int32) bool {
+	for i, m := range min {
+		if i >= len(version) || version[i] < m {
+			return true
+		}
+		if version[i] > m {
+			return false
+		}
+	}
+	return false
+}
+
+// mongoURI accepts either a full connection string (mongodb:// or mongodb+srv://) or the legacy host1:port1,host2:port2 list
+func mongoURI(mongos string) string {
+	if isMongoURI(mongos) {
+		return mongos
+	}
+	return "mongodb://" + mongos
+}
+
+func isMongoURI(mongos string) bool {
+	return strings.HasPrefix(mongos, "mongodb://") || strings.HasPrefix(mongos, "mongodb+srv://")
+}
+
+// GetSupportedCollections returns the registered collections, as last refreshed from the registry
 func (ma *mongoConnection) GetSupportedCollections() map[string]bool {
-	return ma.collections
+	return ma.collections.get()
 }
 
 func (ma *mongoConnection) Close() {
-	ma.session.Close()
+	ma.stopRefresh()
+
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	if err := ma.client.Disconnect(ctx); err != nil {
+		logger.WithError(err).Warn("could not disconnect from mongoDB")
+	}
 }
 
 func createMapWithAllowedCollections(collections []string) map[string]bool {
@@ -123,123 +275,261 @@ func createMapWithAllowedCollections(collections []string) map[string]bool {
 	return collectionMap
 }
 
+func (ma *mongoConnection) collection(name string) *mongo.Collection {
+	return ma.client.Database(ma.dbName).Collection(name)
+}
+
+// withTransaction runs the given function in a transaction, which is retried on transient errors like write conflicts
+func (ma *mongoConnection) withTransaction(fn func(ctx mongo.SessionContext) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	session, err := ma.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
 func (ma *mongoConnection) EnsureIndex() {
-	newSession := ma.session.Copy()
-	defer newSession.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
 
-	index := mgo.Index{
-		Name:       "uuid-index",
-		Key:        []string{"uuid"},
-		Background: true,
-		Unique:     true,
+	if _, err := ma.collection(deliveriesCollection).Indexes().CreateOne(ctx, deliveriesIndex); err != nil {
+		logger.WithError(err).Infof("could not EnsureIndex: %s", *deliveriesIndex.Options.Name)
 	}
 
-	for coll := range ma.collections {
-		if err := newSession.DB(ma.dbName).C(coll).EnsureIndex(index); err != nil {
-			logger.WithError(err).Info("could not EnsureIndex: %s ", index)
+	for coll := range ma.GetSupportedCollections() {
+		if err := ma.ensureIndexes(ctx, coll); err != nil {
+			logger.WithError(err).Errorf("could not EnsureIndex for collection: %s", coll)
 		}
 	}
 }
 
-func (ma *mongoConnection) Delete(collection string, uuidString string) error {
-	newSession := ma.session.Copy()
-	defer newSession.Close()
+// ensureIndexes creates the indexes of the collection, and of its changes and versions. It fails as soon as one of them can't be
+// created, as writes rely on the unique ones to keep a single document per uuid and a single change per sequence.
+func (ma *mongoConnection) ensureIndexes(ctx context.Context, coll string) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: uuidName, Value: 1}},
+		Options: options.Index().SetName("uuid-index").SetBackground(true).SetUnique(true),
+	}
 
-	coll := newSession.DB(ma.dbName).C(collection)
-	bsonUUID := bson.Binary{Kind: 0x04, Data: []byte(uuid.Parse(uuidString))}
+	versionsIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: uuidName, Value: 1}, {Key: revisionName, Value: -1}},
+		Options: options.Index().SetName("uuid-revision-index").SetBackground(true).SetUnique(true),
+	}
 
-	return coll.Remove(bson.D{bson.DocElem{Name: uuidName, Value: bsonUUID}})
-}
+	if _, err := ma.collection(coll).Indexes().CreateMany(ctx, []mongo.IndexModel{index, tombstoneIndex, hashIndex, lastModifiedIndex, blobIndex}); err != nil {
+		return fmt.Errorf("could not create the indexes of collection %s: %w", coll, err)
+	}
 
-func (ma *mongoConnection) Write(collection string, resource *mapper.Resource) error {
-	newSession := ma.session.Copy()
-	defer newSession.Close()
+	if _, err := ma.collection(coll).Indexes().DropOne(ctx, legacyHashIndex); err != nil && !isIndexNotFound(err) {
+		logger.WithError(err).Infof("could not drop index %s of collection: %s", legacyHashIndex, coll)
+	}
 
-	coll := newSession.DB(ma.dbName).C(collection)
+	if _, err := ma.collection(changesCollection(coll)).Indexes().CreateOne(ctx, changesIndex); err != nil {
+		return fmt.Errorf("could not create index %s of collection %s: %w", *changesIndex.Options.Name, changesCollection(coll), err)
+	}
 
-	bsonUUID := bson.Binary{Kind: 0x04, Data: []byte(uuid.Parse(resource.UUID))}
-	bsonResource := map[string]interface{}{
-		"uuid":             bsonUUID,
-		"content":          resource.Content,
-		"content-type":     resource.ContentType,
-		"origin-system-id": resource.OriginSystemID,
+	if _, err := ma.collection(pendingChangesCollection(coll)).Indexes().CreateOne(ctx, pendingChangesIndex); err != nil {
+		return fmt.Errorf("could not create index %s of collection %s: %w", *pendingChangesIndex.Options.Name, pendingChangesCollection(coll), err)
 	}
 
-	_, err := coll.Upsert(bson.D{bson.DocElem{Name: uuidName, Value: bsonUUID}}, bsonResource)
+	if ma.history[coll] > 0 {
+		if _, err := ma.collection(versionsCollection(coll)).Indexes().CreateMany(ctx, []mongo.IndexModel{versionsIndex, blobIndex}); err != nil {
+			return fmt.Errorf("could not create the indexes of collection %s: %w", versionsCollection(coll), err)
+		}
+	}
+	return nil
+}
+
+// Write upserts the resource if the validator accepts its content and the precondition holds, and sets the revision, hash and timestamps it has been stored with.
+// Streamed content over the blob threshold is stored in a blob, before the document, and other content is compressed by the
+// storage codec of the collection.
+func (ma *mongoConnection) Write(collection string, resource *mapper.Resource, precondition Precondition, validator Validator) error {
+	contentBlob, err := ma.storeStream(collection, resource)
+	if err != nil {
+		return err
+	}
 
+	if err = ma.write(collection, resource, contentBlob, precondition, validator); err != nil && contentBlob != nil {
+		ma.deleteBlob(collection, contentBlob)
+	}
 	return err
 }
 
-func (ma *mongoConnection) Read(collection string, uuidString string) (res *mapper.Resource, found bool, err error) {
-	newSession := ma.session.Copy()
-	defer newSession.Close()
+func (ma *mongoConnection) write(collection string, resource *mapper.Resource, contentBlob *blob, precondition Precondition, validator Validator) error {
+	if contentBlob == nil {
+		if err := setHash(resource); err != nil {
+			return err
+		}
+	}
 
-	coll := newSession.DB(ma.dbName).C(collection)
+	if err := validator.validate(resource.Content); err != nil {
+		return err
+	}
 
-	bsonUUID := bson.Binary{Kind: 0x04, Data: []byte(uuid.Parse(uuidString))}
+	var stored *document
+	err := ma.withTransaction(func(ctx mongo.SessionContext) error {
+		current, err := ma.current(ctx, collection, resource.UUID)
+		if err != nil {
+			return err
+		}
 
-	var bsonResource map[string]interface{}
+		var live *mapper.Resource
+		if current != nil && current.Tombstone == nil {
+			live = current.resource()
+		}
 
-	if err = coll.Find(bson.M{uuidName: bsonUUID}).One(&bsonResource); err != nil {
-		if err == mgo.ErrNotFound {
-			return res, false, nil
+		if err = precondition.check(live); err != nil {
+			return err
+		}
+
+		revision, err := ma.nextRevision(ctx, collection, resource.UUID, current)
+		if err != nil {
+			return err
+		}
+
+		doc := newDocument(resource, revision)
+		if contentBlob != nil {
+			doc.Content = nil
+			doc.Blob = contentBlob
 		}
-		return res, false, err
-	}
 
-	uuidData := bsonResource["uuid"].(bson.Binary).Data
-	originSystemID, found := bsonResource["origin-system-id"]
-	if !found {
-		res = &mapper.Resource{
-			UUID:        uuid.UUID(uuidData).String(),
-			Content:     bsonResource["content"],
-			ContentType: bsonResource["content-type"].(string),
+		if err = ma.compress(collection, doc); err != nil {
+			return err
 		}
-	} else {
-		res = &mapper.Resource{
-			UUID:           uuid.UUID(uuidData).String(),
-			Content:        bsonResource["content"],
-			ContentType:    bsonResource["content-type"].(string),
-			OriginSystemID: originSystemID.(string),
+
+		doc.LastModified = now()
+		doc.Created = doc.LastModified
+		if live != nil {
+			doc.Created = live.Created
+		}
+
+		stored = doc
+		if _, err = ma.collection(collection).ReplaceOne(ctx, uuidFilter(resource.UUID), doc, options.Replace().SetUpsert(true)); err != nil {
+			return err
 		}
+
+		if err = ma.recordVersion(ctx, collection, doc); err != nil {
+			return err
+		}
+
+		return ma.recordChange(ctx, collection, newChange(OperationPut, resource, doc.LastModified))
+	})
+
+	if err == nil {
+		resource.Revision = stored.Revision
+		resource.Created = stored.Created
+		resource.LastModified = stored.LastModified
 	}
+	return err
+}
+
+func (ma *mongoConnection) Read(collection string, uuidString string) (res *mapper.Resource, found bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
 
-	return res, true, nil
+	doc := &document{}
+	if err = ma.collection(collection).FindOne(ctx, liveFilter(uuidString)).Decode(doc); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return res, false, nil
+		}
+		return res, false, err
+	}
+
+	res, err = ma.resource(collection, doc)
+	return res, err == nil, err
 }
 
-func (ma *mongoConnection) ReadIDs(ctx context.Context, collection string) (chan string, error) {
-	ids := make(chan string, 8)
+// ReadMany returns the live documents among the given uuids, in no particular order, from a single query
+func (ma *mongoConnection) ReadMany(collection string, uuids []string) ([]*mapper.Resource, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
 
-	newSession := ma.session.Copy()
-	coll := newSession.DB(ma.dbName).C(collection)
+	ids := make(bson.A, 0, len(uuids))
+	for _, uuidString := range uuids {
+		ids = append(ids, bsonUUID(uuidString))
+	}
 
-	iter := coll.Find(nil).Select(bson.M{uuidName: true}).Batch(32).Iter()
+	filter := bson.D{{Key: uuidName, Value: bson.D{{Key: "$in", Value: ids}}}, notDeleted}
+	cursor, err := ma.collection(collection).Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
 
-	if err := iter.Err(); err != nil {
-		newSession.Close()
+	resources := make([]*mapper.Resource, 0, len(uuids))
+	for cursor.Next(ctx) {
+		doc := &document{}
+		if err = cursor.Decode(doc); err != nil {
+			return nil, err
+		}
+		res, err := ma.resource(collection, doc)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, res)
+	}
+	return resources, cursor.Err()
+}
+
+// ReadIDs streams the ids of the live documents of the collection in uuid order, narrowed down by the query. A cursor failing
+// partway, or a document which can't be decoded, ends the ids with an ID carrying the error.
+func (ma *mongoConnection) ReadIDs(ctx context.Context, collection string, query IDsQuery) (chan *ID, error) {
+	ids := make(chan *ID, 8)
+
+	cursor, err := ma.collection(collection).Find(ctx, query.filter(), query.options())
+	if err != nil {
 		return ids, err
 	}
 
 	go func() {
-		defer newSession.Close()
-		defer iter.Close()
+		defer cursor.Close(context.Background())
 		defer close(ids)
 
-		var result map[string]interface{}
-
-		for iter.Next(&result) {
+		for cursor.Next(ctx) {
 			if err := ctx.Err(); err != nil {
-				break
+				return
+			}
+
+			result := &document{}
+			if err := cursor.Decode(result); err != nil {
+				ids <- &ID{Err: fmt.Errorf("could not decode uuid from mongoDB: %w", err)}
+				return
 			}
 
-			ids <- uuid.UUID(result["uuid"].(bson.Binary).Data).String()
+			ids <- &ID{UUID: uuid.UUID(result.UUID.Data).String(), Hash: result.Hash, LastModified: result.LastModified}
+		}
+
+		// the handler tells a timeout from the context itself
+		if err := cursor.Err(); err != nil && ctx.Err() == nil {
+			ids <- &ID{Err: err}
 		}
 	}()
 
 	return ids, nil
 }
 
+// CheckMongoUrls validates the mongo addresses. A full connection string only needs a host, as the node count
+// cannot be known upfront for SRV records; the legacy host:port list must have the expected number of nodes.
 func CheckMongoUrls(providedMongoUrls string, expectedMongoNodeCount int) error {
+	if isMongoURI(providedMongoUrls) {
+		u, err := url.Parse(providedMongoUrls)
+		if err != nil {
+			return fmt.Errorf("the provided MongoDB connection string is invalid: %v", err)
+		}
+		if u.Host == "" {
+			return errors.New("the provided MongoDB connection string has no host")
+		}
+		return nil
+	}
+
 	mongoUrls := strings.Split(providedMongoUrls, ",")
 	actualMongoNodeCount := len(mongoUrls)
 	if actualMongoNodeCount != expectedMongoNodeCount {
@@ -3,11 +3,15 @@ package db
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
 
 	"github.com/Financial-Times/go-logger"
 	"github.com/Financial-Times/nativerw/pkg/mapper"
@@ -32,7 +36,7 @@ func TestReadWriteDelete(t *testing.T) {
 	assert.NoError(t, err)
 	defer connection.Close()
 	expectedResource := generateResource()
-	err = connection.Write("methode", expectedResource)
+	err = connection.Write("methode", expectedResource, Precondition{}, nil)
 	assert.NoError(t, err)
 
 	res, found, err := connection.Read("methode", expectedResource.UUID)
@@ -42,7 +46,7 @@ func TestReadWriteDelete(t *testing.T) {
 	assert.Equal(t, expectedResource.UUID, res.UUID)
 	assert.Equal(t, expectedResource.Content, res.Content)
 
-	err = connection.Delete("methode", expectedResource.UUID)
+	err = connection.Delete("methode", expectedResource.UUID, "tid_delete", Precondition{})
 	assert.NoError(t, err)
 
 	_, found, err = connection.Read("methode", expectedResource.UUID)
@@ -51,6 +55,112 @@ func TestReadWriteDelete(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func testReadMany(t *testing.T, connection Connection) {
+	first := generateResource()
+	require.NoError(t, connection.Write("methode", first, Precondition{}, nil))
+
+	binary := &mapper.Resource{UUID: uuid.NewUUID().String(), Content: []byte("hello"), ContentType: "application/octet-stream", OriginSystemID: "methode-web-pub"}
+	require.NoError(t, connection.Write("methode", binary, Precondition{}, nil))
+
+	deleted := generateResource()
+	require.NoError(t, connection.Write("methode", deleted, Precondition{}, nil))
+	require.NoError(t, connection.Delete("methode", deleted.UUID, "tid_delete", Precondition{}))
+
+	resources, err := connection.ReadMany("methode", []string{first.UUID, deleted.UUID, uuid.NewUUID().String(), binary.UUID})
+	require.NoError(t, err)
+	require.Len(t, resources, 2, "only the live documents should be read")
+
+	read := make(map[string]*mapper.Resource)
+	for _, resource := range resources {
+		read[resource.UUID] = resource
+	}
+
+	require.Contains(t, read, first.UUID)
+	assert.Equal(t, first.Content, read[first.UUID].Content)
+	assert.Equal(t, first.Hash, read[first.UUID].Hash)
+
+	require.Contains(t, read, binary.UUID)
+	assert.Equal(t, []byte("hello"), read[binary.UUID].Content)
+	assert.Equal(t, "application/octet-stream", read[binary.UUID].ContentType)
+	assert.Equal(t, "methode-web-pub", read[binary.UUID].OriginSystemID)
+
+	resources, err = connection.ReadMany("methode", []string{})
+	require.NoError(t, err)
+	assert.Empty(t, resources)
+}
+
+func TestReadMany(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testReadMany(t, connection)
+}
+
+func TestReadWriteVersions(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	assert.NoError(t, err)
+
+	defer connection.Close()
+
+	resource := generateResource()
+	for i := 1; i <= 4; i++ {
+		resource.Content = map[string]interface{}{"revision": float64(i)}
+		resource.TransactionID = fmt.Sprintf("tid_%d", i)
+		assert.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+	}
+
+	versions, err := connection.ReadVersions("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.Len(t, versions, 3) // history is capped at 3 in mongo_test.go
+	assert.Equal(t, int64(4), versions[0].Version)
+	assert.Equal(t, "tid_4", versions[0].TransactionID)
+
+	res, found, err := connection.ReadVersion("methode", resource.UUID, 3)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, map[string]interface{}{"revision": float64(3)}, res.Content)
+
+	_, found, err = connection.ReadVersion("methode", resource.UUID, 1)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestDeleteRestore(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	assert.NoError(t, err)
+
+	defer connection.Close()
+
+	resource := generateResource()
+	assert.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+	assert.NoError(t, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{}))
+	assert.Equal(t, ErrNotFound, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{}))
+
+	_, found, err := connection.Read("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, connection.Restore("methode", resource.UUID, "tid_restore"))
+	assert.Equal(t, ErrNotFound, connection.Restore("methode", resource.UUID, "tid_restore"))
+
+	res, found, err := connection.Read("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, resource.Content, res.Content)
+	assert.Equal(t, "tid_restore", res.TransactionID)
+
+	assert.NoError(t, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{}))
+	purged, err := connection.PurgeTombstones("methode", time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, purged >= 1)
+	assert.Equal(t, ErrNotFound, connection.Restore("methode", resource.UUID, "tid_restore"))
+}
+
 func TestGetSupportedCollections(t *testing.T) {
 	mongo := startMongo(t)
 	connection, err := mongo.Open()
@@ -71,15 +181,19 @@ func TestEnsureIndexes(t *testing.T) {
 	defer connection.Close()
 
 	connection.EnsureIndex()
-	indexes, err := connection.(*mongoConnection).session.DB("native-store").C("methode").Indexes()
+	cursor, err := connection.(*mongoConnection).collection("methode").Indexes().List(context.Background())
+	assert.NoError(t, err)
 
+	var indexes []bson.M
+	err = cursor.All(context.Background(), &indexes)
 	assert.NoError(t, err)
+
 	count := 0
 	for _, index := range indexes {
-		if index.Name == "uuid-index" {
-			assert.True(t, index.Background)
-			assert.True(t, index.Unique)
-			assert.Equal(t, []string{"uuid"}, index.Key)
+		if index["name"] == "uuid-index" {
+			assert.Equal(t, true, index["background"])
+			assert.Equal(t, true, index["unique"])
+			assert.Equal(t, bson.M{"uuid": int32(1)}, index["key"])
 			count = count + 1
 		}
 	}
@@ -178,19 +292,19 @@ func TestReadIDs(t *testing.T) {
 
 	expectedResource := generateResource()
 
-	err = connection.Write("methode", expectedResource)
+	err = connection.Write("methode", expectedResource, Precondition{}, nil)
 	assert.NoError(t, err)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	ids, err := connection.ReadIDs(ctx, "methode")
+	ids, err := connection.ReadIDs(ctx, "methode", IDsQuery{})
 
 	assert.NoError(t, err)
 	found := false
 
-	for uuid := range ids {
-		if uuid == expectedResource.UUID {
+	for id := range ids {
+		if id.UUID == expectedResource.UUID {
 			found = true
 		}
 	}
@@ -209,14 +323,14 @@ func TestReadMoreThanOneBatch(t *testing.T) {
 	for range make([]struct{}, 64) {
 		expectedResource := generateResource()
 
-		err = connection.Write("methode", expectedResource)
+		err = connection.Write("methode", expectedResource, Precondition{}, nil)
 		assert.NoError(t, err)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	ids, err := connection.ReadIDs(ctx, "methode")
+	ids, err := connection.ReadIDs(ctx, "methode", IDsQuery{})
 
 	assert.NoError(t, err)
 	count := 0
@@ -228,6 +342,148 @@ func TestReadMoreThanOneBatch(t *testing.T) {
 	assert.True(t, count >= 64)
 }
 
+func readIDs(t *testing.T, connection Connection, query IDsQuery) []string {
+	ids, err := connection.ReadIDs(context.Background(), "methode", query)
+	require.NoError(t, err)
+
+	read := make([]string, 0)
+	for id := range ids {
+		read = append(read, id.UUID)
+	}
+	return read
+}
+
+func testReadIDsPages(t *testing.T, connection Connection) {
+	for range make([]struct{}, 10) {
+		require.NoError(t, connection.Write("methode", generateResource(), Precondition{}, nil))
+	}
+
+	all := readIDs(t, connection, IDsQuery{})
+	require.True(t, len(all) >= 10)
+	assert.True(t, sort.StringsAreSorted(all), "ids should be read in uuid order")
+
+	var paged []string
+	query := IDsQuery{Limit: 3}
+	for {
+		page := readIDs(t, connection, query)
+		require.True(t, len(page) <= 3)
+		paged = append(paged, page...)
+		if len(page) < 3 {
+			break
+		}
+		query.After = page[len(page)-1]
+	}
+	assert.Equal(t, all, paged, "the pages should add up to all the ids")
+}
+
+func testReadIDsFilters(t *testing.T, connection Connection) {
+	// unique origin systems keep the documents of previous runs out of the results
+	origin := "origin-" + uuid.New()
+	other := "other-" + uuid.New()
+
+	write := func(originSystemID string, contentType string, content interface{}) *mapper.Resource {
+		resource := &mapper.Resource{UUID: uuid.NewUUID().String(), Content: content, ContentType: contentType, OriginSystemID: originSystemID}
+		require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+		return resource
+	}
+
+	first := write(origin, "application/json", map[string]interface{}{"first": true})
+	time.Sleep(5 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	binary := write(origin, "application/octet-stream", []byte("binary"))
+	write(other, "application/json", map[string]interface{}{"other": true})
+
+	uuids := func(query IDsQuery) []string {
+		ids, err := connection.ReadIDs(context.Background(), "methode", query)
+		require.NoError(t, err)
+
+		read := make([]string, 0)
+		for id := range ids {
+			read = append(read, id.UUID)
+		}
+		sort.Strings(read)
+		return read
+	}
+
+	both := []string{first.UUID, binary.UUID}
+	sort.Strings(both)
+
+	assert.Equal(t, both, uuids(IDsQuery{OriginSystemID: origin}))
+	assert.Equal(t, []string{binary.UUID}, uuids(IDsQuery{OriginSystemID: origin, ContentType: "application/octet-stream"}))
+	assert.Equal(t, []string{binary.UUID}, uuids(IDsQuery{OriginSystemID: origin, ModifiedSince: since}))
+	assert.Equal(t, []string{first.UUID}, uuids(IDsQuery{OriginSystemID: origin, ModifiedBefore: since}))
+	assert.Empty(t, uuids(IDsQuery{OriginSystemID: origin, ModifiedSince: since, ModifiedBefore: since}))
+
+	ids, err := connection.ReadIDs(context.Background(), "methode", IDsQuery{OriginSystemID: origin, ContentType: "application/json"})
+	require.NoError(t, err)
+
+	id := <-ids
+	require.NotNil(t, id)
+	assert.Equal(t, first.UUID, id.UUID)
+	assert.Equal(t, first.Hash, id.Hash)
+	assert.True(t, first.LastModified.Equal(id.LastModified))
+}
+
+func TestReadIDsFilters(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testReadIDsFilters(t, connection)
+}
+
+func testReadIDsShards(t *testing.T, connection Connection) {
+	// random uuids spread over the keyspace, unlike the time based ones of generateResource
+	for range make([]struct{}, 32) {
+		resource := generateResource()
+		resource.UUID = uuid.New()
+		require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+	}
+
+	all := readIDs(t, connection, IDsQuery{})
+
+	var sharded []string
+	for shard := 0; shard < 5; shard++ {
+		sharded = append(sharded, readIDs(t, connection, IDsQuery{Shard: shard, Shards: 5, BatchSize: 4})...)
+	}
+	assert.Equal(t, all, sharded, "the shards should add up to all the ids, without overlapping")
+
+	paged := make([]string, 0)
+	query := IDsQuery{Shard: 3, Shards: 5, Limit: 2}
+	for {
+		page := readIDs(t, connection, query)
+		paged = append(paged, page...)
+		if len(page) < 2 {
+			break
+		}
+		query.After = page[len(page)-1]
+	}
+	assert.Equal(t, readIDs(t, connection, IDsQuery{Shard: 3, Shards: 5}), paged)
+}
+
+func TestReadIDsShards(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testReadIDsShards(t, connection)
+}
+
+func TestReadIDsPages(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testReadIDsPages(t, connection)
+}
+
 func TestCancelReadIDs(t *testing.T) {
 	mongo := startMongo(t).(*mongoDB)
 	connection, err := mongo.Open()
@@ -239,22 +495,22 @@ func TestCancelReadIDs(t *testing.T) {
 	for range make([]struct{}, 64) {
 		expectedResource := generateResource()
 
-		err = connection.Write("methode", expectedResource)
+		err = connection.Write("methode", expectedResource, Precondition{}, nil)
 		assert.NoError(t, err)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel() // just in case
 
-	ids, err := connection.ReadIDs(ctx, "methode")
+	ids, err := connection.ReadIDs(ctx, "methode", IDsQuery{})
 
 	assert.NoError(t, err)
 
 	time.Sleep(1 * time.Second) // allow the channel to fill
 
-	uuid := <-ids
-	assert.NotEqual(t, "", uuid) // prove one uuid has been retrieved, but let the channel fill and block after
-	cancel()                     // cancel the request
+	id := <-ids
+	assert.NotEqual(t, "", id.UUID) // prove one uuid has been retrieved, but let the channel fill and block after
+	cancel()                        // cancel the request
 
 	count := 0
 	for {
@@ -299,3 +555,27 @@ func TestCheckMongoUrlsInvalidSeparator(t *testing.T) {
 
 	assert.NotNil(t, err)
 }
+
+func TestCheckMongoUrlsConnectionString(t *testing.T) {
+	err := CheckMongoUrls("mongodb://host:27017,host2:27017/?replicaSet=rs0", 3)
+	assert.NoError(t, err)
+
+	err = CheckMongoUrls("mongodb+srv://cluster.example.com", 3)
+	assert.NoError(t, err)
+
+	err = CheckMongoUrls("mongodb:///native-store", 1)
+	assert.Error(t, err)
+}
+
+func TestMongoURI(t *testing.T) {
+	assert.Equal(t, "mongodb://host:27017,host2:27017", mongoURI("host:27017,host2:27017"))
+	assert.Equal(t, "mongodb+srv://cluster.example.com", mongoURI("mongodb+srv://cluster.example.com"))
+}
+
+func TestOlderThan(t *testing.T) {
+	assert.True(t, olderThan([]int32{4, 2, 12, 0}, minServerVersion))
+	assert.True(t, olderThan([]int32{3, 6, 0, 0}, minServerVersion))
+	assert.True(t, olderThan(nil, minServerVersion))
+	assert.False(t, olderThan([]int32{4, 4, 0, 0}, minServerVersion))
+	assert.False(t, olderThan([]int32{5, 0, 3, 0}, minServerVersion))
+}
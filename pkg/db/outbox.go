@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrOutboxClaimed is returned when another instance holds the lease on an outbox
+var ErrOutboxClaimed = errors.New("outbox claimed by another instance")
+
+// outboxCollection holds one document per outbox, with the sequence of the last change delivered from the change feed it reads
+// and the instance delivering them. The outbox of a native collection is named after it.
+const outboxCollection = "__outbox"
+
+type outboxDocument struct {
+	Name       string    `bson:"_id"`
+	Position   int64     `bson:"position"`
+	Owner      string    `bson:"owner"`
+	LeaseUntil time.Time `bson:"lease-until"`
+}
+
+// ClaimOutbox takes, or extends, the lease of the owner on the named outbox until the given time, and returns the sequence of the
+// last change delivered from it, which starts from the given one for a new outbox. It fails with ErrOutboxClaimed while another
+// owner holds an unexpired lease.
+func (ma *mongoConnection) ClaimOutbox(name string, owner string, from int64, until time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "_id", Value: name},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "owner", Value: owner}},
+			bson.D{{Key: "lease-until", Value: bson.D{{Key: "$lt", Value: now()}}}},
+		}},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "owner", Value: owner}, {Key: "lease-until", Value: until}}},
+		{Key: "$setOnInsert", Value: bson.D{{Key: "position", Value: from}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	doc := &outboxDocument{}
+	if err := ma.collection(outboxCollection).FindOneAndUpdate(ctx, filter, update, opts).Decode(doc); err != nil {
+		// the filter only misses an existing document when it is leased to someone else, so the upsert clashes with it
+		if mongo.IsDuplicateKeyError(err) {
+			return 0, ErrOutboxClaimed
+		}
+		return 0, err
+	}
+	return doc.Position, nil
+}
+
+// AdvanceOutbox records that the changes up to the given sequence have been delivered from the named outbox, as long as the owner
+// still holds the lease on it
+func (ma *mongoConnection) AdvanceOutbox(name string, owner string, sequence int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: name}, {Key: "owner", Value: owner}}
+	res, err := ma.collection(outboxCollection).UpdateOne(ctx, filter, bson.D{{Key: "$max", Value: bson.D{{Key: "position", Value: sequence}}}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrOutboxClaimed
+	}
+	return nil
+}
+
+// LastSequence returns the sequence of the latest change of the collection, or 0 if it has none, once it has numbered the
+// pending ones
+func (ma *mongoConnection) LastSequence(collection string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	if err := ma.numberChanges(ctx, collection); err != nil {
+		return 0, err
+	}
+
+	counter, err := ma.readCounter(ctx, collection)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Sequence, nil
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testOutbox(t *testing.T, connection Connection) {
+	collection := "outbox-" + uuid.New()
+	lease := time.Now().Add(time.Minute)
+
+	position, err := connection.ClaimOutbox(collection, "a", 0, lease)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), position)
+
+	_, err = connection.ClaimOutbox(collection, "b", 0, lease)
+	assert.Equal(t, ErrOutboxClaimed, err)
+
+	require.NoError(t, connection.AdvanceOutbox(collection, "a", 5))
+	assert.Equal(t, ErrOutboxClaimed, connection.AdvanceOutbox(collection, "b", 6))
+	require.NoError(t, connection.AdvanceOutbox(collection, "a", 3), "an older position should be ignored")
+
+	position, err = connection.ClaimOutbox(collection, "a", 0, time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), position)
+
+	// the lease of a has expired
+	position, err = connection.ClaimOutbox(collection, "b", 0, lease)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), position)
+
+	assert.Equal(t, ErrOutboxClaimed, connection.AdvanceOutbox(collection, "a", 7))
+	_, err = connection.ClaimOutbox(collection, "a", 0, lease)
+	assert.Equal(t, ErrOutboxClaimed, err)
+
+	position, err = connection.ClaimOutbox(collection+"-from", "a", 42, lease)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), position, "a new outbox should start from the given sequence")
+}
+
+func testLastSequence(t *testing.T, connection Connection) {
+	last, err := connection.LastSequence("methode")
+	require.NoError(t, err)
+	assert.Equal(t, lastSequence(t, connection), last)
+
+	require.NoError(t, connection.Write("methode", generateResource(), Precondition{}, nil))
+	require.NoError(t, connection.Write("methode", generateResource(), Precondition{}, nil))
+
+	next, err := connection.LastSequence("methode")
+	require.NoError(t, err)
+	assert.Equal(t, last+2, next)
+
+	none, err := connection.LastSequence("wordpress")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), none)
+}
+
+func TestInMemoryOutbox(t *testing.T) {
+	testOutbox(t, openInMemory(t))
+}
+
+func TestInMemoryLastSequence(t *testing.T) {
+	testLastSequence(t, openInMemoryWithHistory(t, 0))
+}
+
+func TestOutbox(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testOutbox(t, connection)
+}
+
+func TestLastSequence(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testLastSequence(t, connection)
+}
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+const contentName = "content"
+
+// ErrBinaryContent is returned when patching a document whose content is binary, which has no fields to merge the patch into
+var ErrBinaryContent = errors.New("binary content can't be patched")
+
+// isBinary tells whether the stored content is binary, in the document or in a blob
+func isBinary(content interface{}) bool {
+	switch content.(type) {
+	case []byte, *mapper.Stream:
+		return true
+	default:
+		return false
+	}
+}
+
+// patchContent merges the content of the patch into the original content, following the rules of mapper.MergeContent. Json
+// content which is not an object, on either side, counts as an empty one. Neither side is modified.
+func patchContent(patch *mapper.Resource, original interface{}) map[string]interface{} {
+	merged, ok := copyContent(original).(map[string]interface{})
+	if !ok {
+		merged = make(map[string]interface{})
+	}
+
+	patchC, _ := copyContent(patch.Content).(map[string]interface{})
+	return mapper.MergeContent(patchC, merged)
+}
+
+// Patch merges the content of the given resource into the stored document, and sets its content type, origin system and
+// transaction id on it. The merge is applied as $set/$unset updates of the fields it changes, in a transaction which is retried
+// on concurrent writes, so that concurrent patches of different fields never clobber each other. It returns the patched resource.
+// Binary content has no fields to merge into, and fails with ErrBinaryContent rather than being replaced.
+func (ma *mongoConnection) Patch(collection string, resource *mapper.Resource, precondition Precondition, validator Validator) (*mapper.Resource, error) {
+	var patched *mapper.Resource
+	err := ma.withTransaction(func(ctx mongo.SessionContext) error {
+		current := &document{}
+		if err := ma.collection(collection).FindOne(ctx, liveFilter(resource.UUID)).Decode(current); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		original, err := ma.resource(collection, current)
+		if err != nil {
+			return err
+		}
+
+		if err = precondition.check(original); err != nil {
+			return err
+		}
+
+		if isBinary(original.Content) {
+			return ErrBinaryContent
+		}
+
+		patched = mapper.Wrap(patchContent(resource, original.Content), original.UUID, resource.ContentType, resource.OriginSystemID)
+		if err = validator.validate(patched.Content); err != nil {
+			return err
+		}
+
+		revision, err := ma.nextRevision(ctx, collection, resource.UUID, current)
+		if err != nil {
+			return err
+		}
+
+		patched.TransactionID = resource.TransactionID
+		patched.Revision = revision
+		patched.Created = original.Created
+		patched.LastModified = now()
+		if err = setHash(patched); err != nil {
+			return err
+		}
+
+		stored := newDocument(patched, revision)
+		if err = ma.compress(collection, stored); err != nil {
+			return err
+		}
+
+		set := bson.D{
+			{Key: "content-type", Value: patched.ContentType},
+			{Key: "origin-system-id", Value: patched.OriginSystemID},
+			{Key: "transaction-id", Value: patched.TransactionID},
+			{Key: revisionName, Value: patched.Revision},
+			{Key: hashName, Value: patched.Hash},
+			{Key: lastModifiedName, Value: patched.LastModified},
+		}
+		var unset bson.D
+
+		// compressed content can only be set as a whole
+		originalC, ok := original.Content.(map[string]interface{})
+		switch {
+		case ok && current.Compression == nil && stored.Compression == nil:
+			set, unset = contentUpdates(contentName, originalC, patched.Content.(map[string]interface{}), set, unset)
+		case stored.Compression != nil:
+			set = append(set, bson.E{Key: contentName, Value: stored.Content}, bson.E{Key: compressionName, Value: stored.Compression})
+		default:
+			set = append(set, bson.E{Key: contentName, Value: patched.Content})
+			if current.Compression != nil {
+				unset = append(unset, bson.E{Key: compressionName, Value: ""})
+			}
+		}
+
+		update := bson.D{{Key: "$set", Value: set}}
+		if len(unset) > 0 {
+			update = append(update, bson.E{Key: "$unset", Value: unset})
+		}
+
+		// the transaction already guards against concurrent writes, the revision makes sure the merge was based on the stored content
+		filter := append(liveFilter(resource.UUID), revisionFilter(current.Revision))
+		res, err := ma.collection(collection).UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+
+		if res.MatchedCount == 0 {
+			return ErrPreconditionFailed
+		}
+
+		if err = ma.recordVersion(ctx, collection, stored); err != nil {
+			return err
+		}
+
+		return ma.recordChange(ctx, collection, newChange(OperationPatch, patched, patched.LastModified))
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return patched, nil
+}
+
+// revisionFilter matches the given revision, bearing in mind that documents written by previous releases have none
+func revisionFilter(revision int64) bson.E {
+	if revision == 0 {
+		return bson.E{Key: revisionName, Value: bson.D{{Key: "$exists", Value: false}}}
+	}
+	return bson.E{Key: revisionName, Value: revision}
+}
+
+// contentUpdates appends the $set and $unset updates which turn the original object at the given path into the patched one.
+// Nested objects are updated field by field, unless one of their field names can't be part of a dotted path, in which case the
+// object is set as a whole.
+func contentUpdates(path string, original, patched map[string]interface{}, set bson.D, unset bson.D) (bson.D, bson.D) {
+	if !dottable(original) || !dottable(patched) {
+		return append(set, bson.E{Key: path, Value: patched}), unset
+	}
+
+	for _, key := range sortedKeys(original) {
+		if _, found := patched[key]; !found {
+			unset = append(unset, bson.E{Key: path + "." + key, Value: ""})
+		}
+	}
+
+	for _, key := range sortedKeys(patched) {
+		o, found := original[key]
+		if !found {
+			set = append(set, bson.E{Key: path + "." + key, Value: patched[key]})
+			continue
+		}
+
+		om, oIsMap := o.(map[string]interface{})
+		pm, pIsMap := patched[key].(map[string]interface{})
+		if oIsMap && pIsMap {
+			set, unset = contentUpdates(path+"."+key, om, pm, set, unset)
+			continue
+		}
+
+		if !reflect.DeepEqual(o, patched[key]) {
+			set = append(set, bson.E{Key: path + "." + key, Value: patched[key]})
+		}
+	}
+
+	return set, unset
+}
+
+func dottable(content map[string]interface{}) bool {
+	for key := range content {
+		if key == "" || strings.Contains(key, ".") || strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(content map[string]interface{}) []string {
+	keys := make([]string, 0, len(content))
+	for key := range content {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func TestContentUpdates(t *testing.T) {
+	original := map[string]interface{}{
+		"title":  "Title",
+		"remove": "me",
+		"nested": map[string]interface{}{"keep": 1.0, "change": "a"},
+		"list":   []interface{}{"a"},
+	}
+	patched := map[string]interface{}{
+		"title":  "Title",
+		"nested": map[string]interface{}{"keep": 1.0, "change": "b"},
+		"list":   []interface{}{"a", "b"},
+		"added":  true,
+	}
+
+	set, unset := contentUpdates("content", original, patched, nil, nil)
+	assert.Equal(t, bson.D{
+		{Key: "content.added", Value: true},
+		{Key: "content.list", Value: []interface{}{"a", "b"}},
+		{Key: "content.nested.change", Value: "b"},
+	}, set)
+	assert.Equal(t, bson.D{{Key: "content.remove", Value: ""}}, unset)
+}
+
+func TestContentUpdatesOfUndottableFields(t *testing.T) {
+	original := map[string]interface{}{"nested": map[string]interface{}{"a.b": 1.0}}
+	patched := map[string]interface{}{"nested": map[string]interface{}{"a.b": 2.0}}
+
+	set, unset := contentUpdates("content", original, patched, nil, nil)
+	assert.Equal(t, bson.D{{Key: "content.nested", Value: map[string]interface{}{"a.b": 2.0}}}, set)
+	assert.Empty(t, unset)
+}
+
+func testPatch(t *testing.T, connection Connection) {
+	resource := generateResource()
+	resource.Content = map[string]interface{}{"title": "Title", "body": "Body", "nested": map[string]interface{}{"a": "a"}}
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+
+	patch := &mapper.Resource{
+		UUID:           resource.UUID,
+		Content:        map[string]interface{}{"title": "New title", "body": nil, "nested": map[string]interface{}{"b": "b"}},
+		ContentType:    "application/json",
+		OriginSystemID: "methode-web-pub",
+		TransactionID:  "tid_patch",
+	}
+
+	patched, err := connection.Patch("methode", patch, Precondition{IfMatch: []int64{resource.Revision}}, nil)
+	require.NoError(t, err)
+
+	expected := map[string]interface{}{"title": "New title", "nested": map[string]interface{}{"a": "a", "b": "b"}}
+	assert.Equal(t, expected, patched.Content)
+	assert.Equal(t, "tid_patch", patched.TransactionID)
+	assert.True(t, patched.Revision > resource.Revision)
+
+	res, found, err := connection.Read("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, patched, res)
+
+	_, err = connection.Patch("methode", patch, Precondition{IfMatch: []int64{resource.Revision}}, nil)
+	assert.Equal(t, ErrPreconditionFailed, err)
+
+	require.NoError(t, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{}))
+	_, err = connection.Patch("methode", patch, Precondition{}, nil)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+// testPatchBinary leaves binary content as it is, whether it is stored in the document or in a blob of the mongo tests
+func testPatchBinary(t *testing.T, connection Connection) {
+	patch := &mapper.Resource{Content: map[string]interface{}{"title": "Title"}, ContentType: "application/json"}
+
+	for _, data := range [][]byte{[]byte("small"), bytes.Repeat([]byte{0, 1, 2, 0xff}, 1<<10)} {
+		resource := generateResource()
+		resource.ContentType = "application/octet-stream"
+		resource.Content = binaryStream(data)
+		require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+
+		patch.UUID = resource.UUID
+		_, err := connection.Patch("methode", patch, Precondition{}, nil)
+		assert.Equal(t, ErrBinaryContent, err)
+
+		res, found, err := connection.Read("methode", resource.UUID)
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, resource.Revision, res.Revision)
+		assert.Equal(t, data, binaryContent(t, res.Content))
+	}
+}
+
+// testConcurrentPatches patches different fields of the same document concurrently, none of which may get lost
+func testConcurrentPatches(t *testing.T, connection Connection) {
+	resource := generateResource()
+	resource.Content = map[string]interface{}{}
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+
+	fields := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+	wg := &sync.WaitGroup{}
+	for _, field := range fields {
+		wg.Add(1)
+		go func(field string) {
+			defer wg.Done()
+			patch := &mapper.Resource{UUID: resource.UUID, Content: map[string]interface{}{field: field}, ContentType: "application/json"}
+			_, err := connection.Patch("methode", patch, Precondition{}, nil)
+			assert.NoError(t, err)
+		}(field)
+	}
+	wg.Wait()
+
+	res, found, err := connection.Read("methode", resource.UUID)
+	require.NoError(t, err)
+	require.True(t, found)
+
+	for _, field := range fields {
+		assert.Equal(t, field, res.Content.(map[string]interface{})[field])
+	}
+	assert.Equal(t, resource.Revision+int64(len(fields)), res.Revision)
+}
+
+// testValidate rejects the writes whose content, merged for patches, doesn't have a title
+func testValidate(t *testing.T, connection Connection) {
+	errNoTitle := errors.New("no title")
+	var validated interface{}
+	validator := Validator(func(content interface{}) error {
+		validated = content
+		if _, found := content.(map[string]interface{})["title"]; !found {
+			return errNoTitle
+		}
+		return nil
+	})
+
+	resource := generateResource()
+	resource.Content = map[string]interface{}{"body": "Body"}
+	assert.Equal(t, errNoTitle, connection.Write("methode", resource, Precondition{}, validator))
+	assert.Equal(t, errNoTitle, connection.Write("methode", resource, Precondition{IfMatchAny: true}, validator), "the content should be validated before the precondition is checked")
+
+	_, found, err := connection.Read("methode", resource.UUID)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	resource.Content = map[string]interface{}{"title": "Title", "body": "Body"}
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, validator))
+
+	patch := &mapper.Resource{UUID: resource.UUID, Content: map[string]interface{}{"body": "New body"}, ContentType: "application/json"}
+	_, err = connection.Patch("methode", patch, Precondition{}, validator)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"title": "Title", "body": "New body"}, validated, "the merged content should be validated")
+
+	patch.Content = map[string]interface{}{"title": nil}
+	_, err = connection.Patch("methode", patch, Precondition{}, validator)
+	assert.Equal(t, errNoTitle, err)
+
+	res, _, err := connection.Read("methode", resource.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"title": "Title", "body": "New body"}, res.Content)
+}
+
+func TestInMemoryPatch(t *testing.T) {
+	testPatch(t, openInMemoryWithHistory(t, 10))
+}
+
+func TestInMemoryPatchBinary(t *testing.T) {
+	testPatchBinary(t, openInMemoryWithHistory(t, 10))
+}
+
+func TestInMemoryValidate(t *testing.T) {
+	testValidate(t, openInMemoryWithHistory(t, 10))
+}
+
+func TestInMemoryConcurrentPatches(t *testing.T) {
+	testConcurrentPatches(t, openInMemoryWithHistory(t, 10))
+}
+
+func TestPatch(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testPatch(t, connection)
+	testPatchBinary(t, connection)
+	testConcurrentPatches(t, connection)
+	testValidate(t, connection)
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+// ErrPreconditionFailed is returned when a conditional write does not match the current state of the document
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// Precondition guards a write against concurrent changes of the same document, and is checked atomically with the write.
+// The zero value writes unconditionally.
+type Precondition struct {
+	IfMatch           []int64   // the current revision must be one of these
+	IfMatchAny        bool      // the document must exist
+	IfNoneMatchAny    bool      // the document must not exist
+	IfUnmodifiedSince time.Time // the document must not have been modified after this, to the second
+}
+
+// Validator must accept the content as it is written, which for a patch is the merged content, or its error aborts the write.
+// Both backends run it before they check the precondition of a write, and after they merged the current content for a patch.
+type Validator func(content interface{}) error
+
+// check verifies the precondition against the current resource, which is nil if there is none
+func (p Precondition) check(current *mapper.Resource) error {
+	exists := current != nil
+
+	if p.IfNoneMatchAny && exists {
+		return ErrPreconditionFailed
+	}
+
+	if p.IfMatchAny && !exists {
+		return ErrPreconditionFailed
+	}
+
+	if len(p.IfMatch) > 0 && (!exists || !containsRevision(p.IfMatch, current.Revision)) {
+		return ErrPreconditionFailed
+	}
+
+	// documents written by previous releases have no modification time, so nothing to compare with
+	if !p.IfUnmodifiedSince.IsZero() && exists && current.LastModified.Truncate(time.Second).After(p.IfUnmodifiedSince) {
+		return ErrPreconditionFailed
+	}
+
+	return nil
+}
+
+// validate checks the content about to be written, if there is a validator
+func (v Validator) validate(content interface{}) error {
+	if v == nil {
+		return nil
+	}
+	return v(content)
+}
+
+func containsRevision(revisions []int64, revision int64) bool {
+	for _, r := range revisions {
+		if r == revision {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func TestPreconditionCheck(t *testing.T) {
+	modified := time.Date(2020, 3, 4, 10, 30, 15, 500000000, time.UTC)
+	current := &mapper.Resource{Revision: 3, LastModified: modified}
+
+	var tests = []struct {
+		name         string
+		precondition Precondition
+		current      *mapper.Resource
+		expected     error
+	}{
+		{"unconditional write of a new document", Precondition{}, nil, nil},
+		{"unconditional write of an existing document", Precondition{}, current, nil},
+		{"if-match on the current revision", Precondition{IfMatch: []int64{2, 3}}, current, nil},
+		{"if-match on a stale revision", Precondition{IfMatch: []int64{2}}, current, ErrPreconditionFailed},
+		{"if-match on a missing document", Precondition{IfMatch: []int64{0}}, nil, ErrPreconditionFailed},
+		{"if-match any on an existing document", Precondition{IfMatchAny: true}, current, nil},
+		{"if-match any on a missing document", Precondition{IfMatchAny: true}, nil, ErrPreconditionFailed},
+		{"if-none-match any on a missing document", Precondition{IfNoneMatchAny: true}, nil, nil},
+		{"if-none-match any on an existing document", Precondition{IfNoneMatchAny: true}, current, ErrPreconditionFailed},
+		{"if-unmodified-since the same second", Precondition{IfUnmodifiedSince: modified.Truncate(time.Second)}, current, nil},
+		{"if-unmodified-since an earlier second", Precondition{IfUnmodifiedSince: modified.Add(-time.Second)}, current, ErrPreconditionFailed},
+		{"if-unmodified-since on a missing document", Precondition{IfUnmodifiedSince: modified.Add(-time.Second)}, nil, nil},
+		{"if-unmodified-since on a document without modification time", Precondition{IfUnmodifiedSince: modified.Add(-time.Second)}, &mapper.Resource{Revision: 3}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.precondition.check(test.current))
+		})
+	}
+}
+
+func testConditionalWrites(t *testing.T, connection Connection) {
+	resource := generateResource()
+
+	require.NoError(t, connection.Write("methode", resource, Precondition{IfNoneMatchAny: true}, nil))
+	created := resource.Revision
+	assert.True(t, created > 0)
+	assert.Equal(t, ErrPreconditionFailed, connection.Write("methode", resource, Precondition{IfNoneMatchAny: true}, nil))
+
+	require.NoError(t, connection.Write("methode", resource, Precondition{IfMatch: []int64{created}}, nil))
+	updated := resource.Revision
+	assert.True(t, updated > created)
+
+	res, found, err := connection.Read("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, updated, res.Revision)
+
+	assert.Equal(t, ErrPreconditionFailed, connection.Write("methode", resource, Precondition{IfMatch: []int64{created}}, nil))
+	assert.Equal(t, ErrPreconditionFailed, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{IfMatch: []int64{created}}))
+	assert.Equal(t, updated, resource.Revision)
+
+	require.NoError(t, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{IfMatch: []int64{updated}}))
+
+	// a deleted document no longer exists as far as preconditions go
+	assert.Equal(t, ErrPreconditionFailed, connection.Write("methode", resource, Precondition{IfMatchAny: true}, nil))
+	assert.NoError(t, connection.Write("methode", resource, Precondition{IfNoneMatchAny: true}, nil))
+	assert.True(t, resource.Revision > updated)
+}
+
+func TestInMemoryConditionalWrites(t *testing.T) {
+	testConditionalWrites(t, openInMemoryWithHistory(t, 10))
+}
+
+func TestConditionalWrites(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testConditionalWrites(t, connection)
+}
@@ -0,0 +1,263 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// defaultStatsSampleFrom is the number of documents, tombstones included, from which a collection is too large to aggregate
+	// all of its documents within mongoTimeout, and its stats are estimated from a sample instead
+	defaultStatsSampleFrom = 1000000
+	// statsSampleSize is kept under 5% of the sampled collections, for $sample to pick the documents at random off an index
+	// rather than sorting the whole collection
+	statsSampleSize = 10000
+)
+
+// Stats describe the live documents of a collection, and its indexes
+type Stats struct {
+	Count           int64
+	Size            int64
+	AverageSize     int64
+	ContentTypes    map[string]int64
+	OriginSystemIDs map[string]int64
+	// Indexes are the sizes of the indexes of the collection by name, which also index the tombstones
+	Indexes        map[string]int64
+	OldestModified time.Time
+	NewestModified time.Time
+	// Compressed counts the documents stored compressed, whose content takes CompressedSize bytes instead of UncompressedSize
+	Compressed       int64
+	CompressedSize   int64
+	UncompressedSize int64
+	// Estimated stats are extrapolated from a sample of the documents, apart from the modification times and the indexes
+	Estimated bool
+}
+
+// Stats aggregates the live documents of the collection in a single pass, sizing them as stored in bson. The collections of more
+// than statsSampleFrom documents are only sampled, and their counts and sizes scaled up to the number of documents mongo keeps
+// in $collStats.
+func (ma *mongoConnection) Stats(collection string) (*Stats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	storage, err := ma.storageStats(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	byField := func(field string) bson.A {
+		return bson.A{bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$" + field}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}}}
+	}
+
+	var pipeline bson.A
+	estimated := storage.Count > ma.statsSampleFrom
+	if estimated {
+		pipeline = append(pipeline, bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: statsSampleSize}}}})
+	}
+
+	pipeline = append(pipeline,
+		bson.D{{Key: "$match", Value: bson.D{notDeleted}}},
+		bson.D{{Key: "$facet", Value: bson.D{
+			{Key: "totals", Value: bson.A{bson.D{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: nil},
+				{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+				{Key: "size", Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$bsonSize", Value: "$$ROOT"}}}}},
+				{Key: "oldest", Value: bson.D{{Key: "$min", Value: "$" + lastModifiedName}}},
+				{Key: "newest", Value: bson.D{{Key: "$max", Value: "$" + lastModifiedName}}},
+			}}}}},
+			{Key: "contentTypes", Value: byField(contentTypeName)},
+			{Key: "originSystemIds", Value: byField(originSystemIDName)},
+			{Key: "compression", Value: bson.A{
+				bson.D{{Key: "$match", Value: bson.D{{Key: compressionName, Value: bson.D{{Key: "$exists", Value: true}}}}}},
+				bson.D{{Key: "$group", Value: bson.D{
+					{Key: "_id", Value: nil},
+					{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+					{Key: "compressedSize", Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$binarySize", Value: "$" + contentName}}}}},
+					{Key: "uncompressedSize", Value: bson.D{{Key: "$sum", Value: "$compression.size"}}},
+				}}},
+			}},
+		}}},
+	)
+
+	cursor, err := ma.collection(collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	type group struct {
+		ID    string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+
+	var facets []struct {
+		Totals []struct {
+			Count  int64     `bson:"count"`
+			Size   int64     `bson:"size"`
+			Oldest time.Time `bson:"oldest"`
+			Newest time.Time `bson:"newest"`
+		} `bson:"totals"`
+		ContentTypes    []group `bson:"contentTypes"`
+		OriginSystemIDs []group `bson:"originSystemIds"`
+		Compression     []struct {
+			Count            int64 `bson:"count"`
+			CompressedSize   int64 `bson:"compressedSize"`
+			UncompressedSize int64 `bson:"uncompressedSize"`
+		} `bson:"compression"`
+	}
+	if err = cursor.All(ctx, &facets); err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{ContentTypes: make(map[string]int64), OriginSystemIDs: make(map[string]int64), Indexes: storage.IndexSizes, Estimated: estimated}
+	if len(facets) > 0 {
+		if len(facets[0].Totals) > 0 {
+			totals := facets[0].Totals[0]
+			stats.Count = totals.Count
+			stats.Size = totals.Size
+			stats.OldestModified = totals.Oldest
+			stats.NewestModified = totals.Newest
+		}
+		for _, g := range facets[0].ContentTypes {
+			stats.ContentTypes[g.ID] = g.Count
+		}
+		for _, g := range facets[0].OriginSystemIDs {
+			stats.OriginSystemIDs[g.ID] = g.Count
+		}
+		if len(facets[0].Compression) > 0 {
+			compression := facets[0].Compression[0]
+			stats.Compressed = compression.Count
+			stats.CompressedSize = compression.CompressedSize
+			stats.UncompressedSize = compression.UncompressedSize
+		}
+	}
+
+	if stats.Count > 0 {
+		stats.AverageSize = stats.Size / stats.Count
+	}
+
+	if estimated {
+		sampled := storage.Count
+		if sampled > statsSampleSize {
+			sampled = statsSampleSize
+		}
+		stats.scale(float64(storage.Count) / float64(sampled))
+
+		// the extremes are read off the index, as a sample would rarely hold them
+		if stats.OldestModified, err = ma.modified(ctx, collection, 1); err != nil {
+			return nil, err
+		}
+		if stats.NewestModified, err = ma.modified(ctx, collection, -1); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// scale extrapolates the counts and sizes of a sample by the given factor, which keeps their averages and ratios
+func (s *Stats) scale(factor float64) {
+	scaled := func(n int64) int64 {
+		return int64(float64(n)*factor + 0.5)
+	}
+
+	s.Count = scaled(s.Count)
+	s.Size = scaled(s.Size)
+	for contentType, count := range s.ContentTypes {
+		s.ContentTypes[contentType] = scaled(count)
+	}
+	for origin, count := range s.OriginSystemIDs {
+		s.OriginSystemIDs[origin] = scaled(count)
+	}
+	s.Compressed = scaled(s.Compressed)
+	s.CompressedSize = scaled(s.CompressedSize)
+	s.UncompressedSize = scaled(s.UncompressedSize)
+}
+
+type storageStats struct {
+	Count      int64            `bson:"count"`
+	IndexSizes map[string]int64 `bson:"indexSizes"`
+}
+
+// storageStats reads the number of documents and the sizes of the indexes that mongo keeps for the collection, summed across the
+// shards
+func (ma *mongoConnection) storageStats(ctx context.Context, collection string) (*storageStats, error) {
+	cursor, err := ma.collection(collection).Aggregate(ctx, bson.A{bson.D{{Key: "$collStats", Value: bson.D{{Key: "storageStats", Value: bson.D{}}}}}})
+	if err != nil {
+		return nil, err
+	}
+
+	var collStats []struct {
+		StorageStats storageStats `bson:"storageStats"`
+	}
+	if err = cursor.All(ctx, &collStats); err != nil {
+		return nil, err
+	}
+
+	storage := &storageStats{IndexSizes: make(map[string]int64)}
+	for _, s := range collStats {
+		storage.Count += s.StorageStats.Count
+		for name, size := range s.StorageStats.IndexSizes {
+			storage.IndexSizes[name] += size
+		}
+	}
+	return storage, nil
+}
+
+// modified reads the oldest modification time of the live documents of the collection when sorted by 1, or the newest by -1
+func (ma *mongoConnection) modified(ctx context.Context, collection string, sort int) (time.Time, error) {
+	filter := bson.D{{Key: lastModifiedName, Value: bson.D{{Key: "$type", Value: "date"}}}, notDeleted}
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: lastModifiedName, Value: sort}}).
+		SetProjection(bson.D{{Key: lastModifiedName, Value: 1}})
+
+	var doc struct {
+		LastModified time.Time `bson:"last-modified"`
+	}
+	err := ma.collection(collection).FindOne(ctx, filter, opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	return doc.LastModified, err
+}
+
+// Stats sizes the documents as mongo would store them, uncompressed, and has no indexes
+func (mc *memoryConnection) Stats(collection string) (*Stats, error) {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	stats := &Stats{ContentTypes: make(map[string]int64), OriginSystemIDs: make(map[string]int64), Indexes: make(map[string]int64)}
+	for _, doc := range mc.documents[collection] {
+		if doc.tombstone != nil {
+			continue
+		}
+
+		data, err := bson.Marshal(newDocument(doc.resource, doc.resource.Revision))
+		if err != nil {
+			return nil, err
+		}
+
+		stats.Count++
+		stats.Size += int64(len(data))
+		stats.ContentTypes[doc.resource.ContentType]++
+		stats.OriginSystemIDs[doc.resource.OriginSystemID]++
+
+		modified := doc.resource.LastModified
+		if modified.IsZero() {
+			continue
+		}
+		if stats.OldestModified.IsZero() || modified.Before(stats.OldestModified) {
+			stats.OldestModified = modified
+		}
+		if modified.After(stats.NewestModified) {
+			stats.NewestModified = modified
+		}
+	}
+
+	if stats.Count > 0 {
+		stats.AverageSize = stats.Size / stats.Count
+	}
+	return stats, nil
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func testStats(t *testing.T, connection Connection) {
+	before, err := connection.Stats("methode")
+	require.NoError(t, err)
+
+	// a unique origin system tells the documents of this run apart from the ones already stored
+	origin := "origin-" + uuid.New()
+	first := &mapper.Resource{UUID: uuid.NewUUID().String(), Content: map[string]interface{}{"title": "Title"}, ContentType: "application/json", OriginSystemID: origin}
+	require.NoError(t, connection.Write("methode", first, Precondition{}, nil))
+
+	binary := &mapper.Resource{UUID: uuid.NewUUID().String(), Content: []byte("binary"), ContentType: "application/octet-stream", OriginSystemID: origin}
+	require.NoError(t, connection.Write("methode", binary, Precondition{}, nil))
+
+	deleted := generateResource()
+	require.NoError(t, connection.Write("methode", deleted, Precondition{}, nil))
+	require.NoError(t, connection.Delete("methode", deleted.UUID, "tid_delete", Precondition{}))
+
+	stats, err := connection.Stats("methode")
+	require.NoError(t, err)
+
+	assert.Equal(t, before.Count+2, stats.Count, "deleted documents shouldn't be counted")
+	assert.True(t, stats.Size > before.Size)
+	assert.Equal(t, stats.Size/stats.Count, stats.AverageSize)
+	assert.Equal(t, int64(2), stats.OriginSystemIDs[origin])
+	assert.Equal(t, before.ContentTypes["application/octet-stream"]+1, stats.ContentTypes["application/octet-stream"])
+	assert.False(t, stats.OldestModified.After(first.LastModified))
+	assert.True(t, stats.NewestModified.Equal(binary.LastModified))
+}
+
+func TestInMemoryStats(t *testing.T) {
+	connection := openInMemory(t)
+	testStats(t, connection)
+
+	stats, err := connection.Stats("wordpress")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), stats.Count)
+	assert.Equal(t, int64(0), stats.AverageSize)
+	assert.True(t, stats.OldestModified.IsZero())
+}
+
+func TestStats(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	connection.EnsureIndex()
+	testStats(t, connection)
+
+	stats, err := connection.Stats("methode")
+	require.NoError(t, err)
+	assert.Contains(t, stats.Indexes, "uuid-index")
+}
+
+func TestEstimatedStats(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	connection.EnsureIndex()
+	testStats(t, connection)
+
+	exact, err := connection.Stats("methode")
+	require.NoError(t, err)
+	require.False(t, exact.Estimated)
+
+	// sampling collections of any size, with fewer documents than the sample, extrapolates the sample of all of them
+	connection.(*mongoConnection).statsSampleFrom = 0
+	estimated, err := connection.Stats("methode")
+	require.NoError(t, err)
+
+	assert.True(t, estimated.Estimated)
+	assert.Equal(t, exact.Count, estimated.Count)
+	assert.Equal(t, exact.Size, estimated.Size)
+	assert.Equal(t, exact.ContentTypes, estimated.ContentTypes)
+	assert.True(t, exact.OldestModified.Equal(estimated.OldestModified))
+	assert.True(t, exact.NewestModified.Equal(estimated.NewestModified))
+}
+
+func TestScaleStats(t *testing.T) {
+	stats := &Stats{Count: 10, Size: 1000, AverageSize: 100, ContentTypes: map[string]int64{"application/json": 7}, OriginSystemIDs: map[string]int64{}, Compressed: 3, CompressedSize: 100, UncompressedSize: 300}
+	stats.scale(2.5)
+
+	assert.Equal(t, &Stats{Count: 25, Size: 2500, AverageSize: 100, ContentTypes: map[string]int64{"application/json": 18}, OriginSystemIDs: map[string]int64{}, Compressed: 8, CompressedSize: 250, UncompressedSize: 750}, stats)
+}
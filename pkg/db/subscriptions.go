@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The outcomes of a delivery
+const (
+	DeliveryDelivered  = "delivered"
+	DeliveryDeadLetter = "dead-letter"
+)
+
+const (
+	subscriptionsCollection = "__subscriptions"
+	deliveriesCollection    = "__deliveries"
+
+	// maxDeliveries is how far back, in changes of the collection, successful deliveries are kept for. Dead letters are kept
+	// for as long as their subscription.
+	maxDeliveries = 100
+)
+
+// Subscription registers a webhook called for the changes of a collection, optionally only the ones from an origin system
+type Subscription struct {
+	ID             string    `bson:"_id"`
+	Collection     string    `bson:"collection"`
+	URL            string    `bson:"url"`
+	OriginSystemID string    `bson:"origin-system-id,omitempty"`
+	Secret         string    `bson:"secret"`
+	Since          int64     `bson:"since"` // the sequence of the last change before the subscription
+	Created        time.Time `bson:"created"`
+}
+
+// Delivery records the outcome of calling the webhook of a subscription for a change
+type Delivery struct {
+	Subscription string    `bson:"subscription"`
+	Sequence     int64     `bson:"sequence"`
+	UUID         string    `bson:"uuid"`
+	Operation    string    `bson:"operation"`
+	Status       string    `bson:"status"`
+	Attempts     int       `bson:"attempts"`
+	StatusCode   int       `bson:"status-code,omitempty"`
+	Error        string    `bson:"error,omitempty"`
+	Timestamp    time.Time `bson:"timestamp"`
+}
+
+var deliveriesIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: "subscription", Value: 1}, {Key: sequenceName, Value: -1}},
+	Options: options.Index().SetName("subscription-sequence-index").SetBackground(true),
+}
+
+// SubscriptionOutbox names the outbox of a subscription, which can't clash with the ones of the native collections
+func SubscriptionOutbox(id string) string {
+	return "__subscription/" + id
+}
+
+func (ma *mongoConnection) CreateSubscription(subscription *Subscription) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	_, err := ma.collection(subscriptionsCollection).InsertOne(ctx, subscription)
+	return err
+}
+
+func (ma *mongoConnection) ReadSubscriptions() ([]*Subscription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	cursor, err := ma.collection(subscriptionsCollection).Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "created", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	subscriptions := make([]*Subscription, 0)
+	if err = cursor.All(ctx, &subscriptions); err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+func (ma *mongoConnection) ReadSubscription(id string) (*Subscription, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	subscription := &Subscription{}
+	if err := ma.collection(subscriptionsCollection).FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(subscription); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return subscription, true, nil
+}
+
+// DeleteSubscription removes the subscription with its deliveries
+func (ma *mongoConnection) DeleteSubscription(id string) error {
+	return ma.withTransaction(func(ctx mongo.SessionContext) error {
+		res, err := ma.collection(subscriptionsCollection).DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+		if err != nil {
+			return err
+		}
+
+		if res.DeletedCount == 0 {
+			return ErrNotFound
+		}
+
+		_, err = ma.collection(deliveriesCollection).DeleteMany(ctx, bson.D{{Key: "subscription", Value: id}})
+		return err
+	})
+}
+
+// RecordDelivery stores the delivery, and drops the successful deliveries of the subscription which are too old to keep
+func (ma *mongoConnection) RecordDelivery(delivery *Delivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	deliveries := ma.collection(deliveriesCollection)
+	if _, err := deliveries.InsertOne(ctx, delivery); err != nil {
+		return err
+	}
+
+	_, err := deliveries.DeleteMany(ctx, bson.D{
+		{Key: "subscription", Value: delivery.Subscription},
+		{Key: "status", Value: DeliveryDelivered},
+		{Key: sequenceName, Value: bson.D{{Key: "$lte", Value: delivery.Sequence - maxDeliveries}}},
+	})
+	return err
+}
+
+// ReadDeliveries lists the deliveries of the subscription, newest first, optionally only the ones with the given status
+func (ma *mongoConnection) ReadDeliveries(id string, status string) ([]*Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	filter := bson.D{{Key: "subscription", Value: id}}
+	if status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: status})
+	}
+
+	cursor, err := ma.collection(deliveriesCollection).Find(ctx, filter, options.Find().SetSort(bson.D{{Key: sequenceName, Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := make([]*Delivery, 0)
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSubscriptions(t *testing.T, connection Connection) {
+	created := now()
+	first := &Subscription{ID: uuid.New(), Collection: "methode", URL: "http://localhost:8080/hook", Secret: "secret", Since: 4, Created: created}
+	second := &Subscription{ID: uuid.New(), Collection: "methode", URL: "http://localhost:8080/hook", OriginSystemID: "methode-web-pub", Secret: "secret", Created: created.Add(time.Millisecond)}
+	require.NoError(t, connection.CreateSubscription(first))
+	require.NoError(t, connection.CreateSubscription(second))
+
+	subscription, found, err := connection.ReadSubscription(first.ID)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, first, subscription)
+
+	subscriptions, err := connection.ReadSubscriptions()
+	require.NoError(t, err)
+
+	var ids []string
+	for _, s := range subscriptions {
+		if s.ID == first.ID || s.ID == second.ID {
+			ids = append(ids, s.ID)
+		}
+	}
+	assert.Equal(t, []string{first.ID, second.ID}, ids, "subscriptions should be listed oldest first")
+
+	for _, sequence := range []int64{1, 2, 150, 151} {
+		status := DeliveryDelivered
+		if sequence == 2 {
+			status = DeliveryDeadLetter
+		}
+		require.NoError(t, connection.RecordDelivery(&Delivery{Subscription: first.ID, Sequence: sequence, UUID: uuid.New(), Operation: OperationPut, Status: status, Attempts: 1, Timestamp: now()}))
+	}
+
+	deliveries, err := connection.ReadDeliveries(first.ID, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 3, "the old successful deliveries should have been dropped, but not the dead letters")
+	assert.Equal(t, int64(151), deliveries[0].Sequence)
+	assert.Equal(t, int64(150), deliveries[1].Sequence)
+	assert.Equal(t, int64(2), deliveries[2].Sequence)
+
+	deadLetters, err := connection.ReadDeliveries(first.ID, DeliveryDeadLetter)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, int64(2), deadLetters[0].Sequence)
+
+	require.NoError(t, connection.DeleteSubscription(first.ID))
+	_, found, err = connection.ReadSubscription(first.ID)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	deliveries, err = connection.ReadDeliveries(first.ID, "")
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+
+	assert.Equal(t, ErrNotFound, connection.DeleteSubscription(first.ID))
+	require.NoError(t, connection.DeleteSubscription(second.ID))
+}
+
+func TestInMemorySubscriptions(t *testing.T) {
+	testSubscriptions(t, openInMemory(t))
+}
+
+func TestSubscriptions(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testSubscriptions(t, connection)
+}
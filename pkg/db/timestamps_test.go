@@ -0,0 +1,60 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func testTimestamps(t *testing.T, connection Connection) {
+	resource := generateResource()
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+
+	created := resource.Created
+	assert.False(t, created.IsZero())
+	assert.Equal(t, created, resource.LastModified)
+
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+	assert.True(t, created.Equal(resource.Created))
+	assert.True(t, resource.LastModified.After(created))
+
+	time.Sleep(5 * time.Millisecond)
+	patched, err := connection.Patch("methode", &mapper.Resource{UUID: resource.UUID, Content: map[string]interface{}{"patched": true}, ContentType: "application/json"}, Precondition{}, nil)
+	require.NoError(t, err)
+	assert.True(t, created.Equal(patched.Created))
+	assert.True(t, patched.LastModified.After(resource.LastModified))
+
+	res, found, err := connection.Read("methode", resource.UUID)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.True(t, created.Equal(res.Created))
+	assert.True(t, patched.LastModified.Equal(res.LastModified))
+
+	assert.Equal(t, ErrPreconditionFailed, connection.Write("methode", resource, Precondition{IfUnmodifiedSince: created.Truncate(time.Second).Add(-time.Second)}, nil))
+	assert.NoError(t, connection.Write("methode", resource, Precondition{IfUnmodifiedSince: time.Now().Add(time.Second)}, nil))
+
+	// a document written again after it has been deleted is a new one
+	require.NoError(t, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{}))
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+	assert.True(t, resource.Created.After(created))
+}
+
+func TestInMemoryTimestamps(t *testing.T) {
+	testTimestamps(t, openInMemoryWithHistory(t, 10))
+}
+
+func TestTimestamps(t *testing.T) {
+	mongo := startMongo(t)
+	connection, err := mongo.Open()
+	require.NoError(t, err)
+
+	defer connection.Close()
+
+	testTimestamps(t, connection)
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+const (
+	tombstoneName          = "tombstone"
+	tombstoneDeletedAtName = "tombstone.deleted-at"
+)
+
+func newTombstone(resource *mapper.Resource, tid string) (*tombstone, error) {
+	if resource.Hash == "" {
+		if err := setHash(resource); err != nil {
+			return nil, err
+		}
+	}
+
+	return &tombstone{DeletedAt: now(), TransactionID: tid, Hash: resource.Hash}, nil
+}
+
+func (ma *mongoConnection) Delete(collection string, uuidString string, tid string, precondition Precondition) error {
+	return ma.withTransaction(func(ctx mongo.SessionContext) error {
+		doc := &document{}
+		if err := ma.collection(collection).FindOne(ctx, liveFilter(uuidString)).Decode(doc); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		if err := doc.decompress(); err != nil {
+			return err
+		}
+
+		resource := doc.resource()
+		if err := precondition.check(resource); err != nil {
+			return err
+		}
+
+		ts, err := newTombstone(resource, tid)
+		if err != nil {
+			return err
+		}
+
+		if _, err = ma.collection(collection).UpdateOne(ctx, liveFilter(uuidString), bson.D{{Key: "$set", Value: bson.D{{Key: tombstoneName, Value: ts}}}}); err != nil {
+			return err
+		}
+
+		return ma.recordChange(ctx, collection, newChange(OperationDelete, resource, ts.DeletedAt))
+	})
+}
+
+func (ma *mongoConnection) Restore(collection string, uuidString string, tid string) error {
+	return ma.withTransaction(func(ctx mongo.SessionContext) error {
+		filter := append(uuidFilter(uuidString), bson.E{Key: tombstoneDeletedAtName, Value: bson.D{{Key: "$gte", Value: now().Add(-ma.tombstoneRetention(collection))}}})
+
+		doc := &document{}
+		if err := ma.collection(collection).FindOne(ctx, filter).Decode(doc); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		revision, err := ma.nextRevision(ctx, collection, uuidString, doc)
+		if err != nil {
+			return err
+		}
+
+		if err = doc.decompress(); err != nil {
+			return err
+		}
+
+		resource := doc.resource()
+		resource.TransactionID = tid
+		resource.LastModified = now()
+		if doc.Blob == nil {
+			if err = setHash(resource); err != nil {
+				return err
+			}
+		}
+
+		restored := newDocument(resource, revision)
+		restored.Blob = doc.Blob
+		if err = ma.compress(collection, restored); err != nil {
+			return err
+		}
+
+		if _, err = ma.collection(collection).ReplaceOne(ctx, uuidFilter(uuidString), restored); err != nil {
+			return err
+		}
+
+		if err = ma.recordVersion(ctx, collection, restored); err != nil {
+			return err
+		}
+
+		return ma.recordChange(ctx, collection, newChange(OperationRestore, resource, resource.LastModified))
+	})
+}
+
+func (ma *mongoConnection) PurgeTombstones(collection string, deletedBefore time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	result, err := ma.collection(collection).DeleteMany(ctx, bson.D{{Key: tombstoneDeletedAtName, Value: bson.D{{Key: "$lt", Value: deletedBefore}}}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+var tombstoneIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: tombstoneDeletedAtName, Value: 1}},
+	Options: options.Index().SetName("tombstone-index").SetBackground(true).SetSparse(true),
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/config"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func openInMemoryWithRetention(t *testing.T, retention time.Duration) *memoryConnection {
+	memory := NewInMemoryDB(&config.Configuration{
+		Collections:        []string{"methode"},
+		History:            map[string]int{"methode": 10},
+		TombstoneRetention: config.Duration{Duration: retention},
+	})
+	connection, err := memory.Open()
+	require.NoError(t, err)
+	return connection.(*memoryConnection)
+}
+
+func TestInMemoryDeleteLeavesTombstone(t *testing.T) {
+	connection := openInMemoryWithRetention(t, time.Hour)
+
+	resource := generateResource()
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+	require.NoError(t, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{}))
+
+	_, found, err := connection.Read("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	ids, err := connection.ReadIDs(context.Background(), "methode", IDsQuery{})
+	assert.NoError(t, err)
+	for id := range ids {
+		assert.NotEqual(t, resource.UUID, id.UUID)
+	}
+
+	ts := connection.documents["methode"][resource.UUID].tombstone
+	require.NotNil(t, ts)
+	assert.Equal(t, "tid_delete", ts.TransactionID)
+	assert.False(t, ts.DeletedAt.IsZero())
+
+	hash, err := mapper.ContentHash(resource.Content)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, ts.Hash)
+
+	assert.Equal(t, ErrNotFound, connection.Delete("methode", resource.UUID, "tid_delete_again", Precondition{}))
+}
+
+func TestInMemoryRestore(t *testing.T) {
+	connection := openInMemoryWithRetention(t, time.Hour)
+
+	resource := generateResource()
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+	require.NoError(t, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{}))
+	require.NoError(t, connection.Restore("methode", resource.UUID, "tid_restore"))
+
+	res, found, err := connection.Read("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, resource.Content, res.Content)
+	assert.Equal(t, "tid_restore", res.TransactionID)
+
+	versions, err := connection.ReadVersions("methode", resource.UUID)
+	assert.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, int64(2), versions[0].Version)
+	assert.Equal(t, "tid_restore", versions[0].TransactionID)
+
+	assert.Equal(t, ErrNotFound, connection.Restore("methode", resource.UUID, "tid_restore_again"))
+}
+
+func TestInMemoryRestoreOutsideRetention(t *testing.T) {
+	connection := openInMemoryWithRetention(t, time.Hour)
+
+	resource := generateResource()
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+	require.NoError(t, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{}))
+
+	connection.documents["methode"][resource.UUID].tombstone.DeletedAt = now().Add(-2 * time.Hour)
+
+	assert.Equal(t, ErrNotFound, connection.Restore("methode", resource.UUID, "tid_restore"))
+	assert.Equal(t, ErrNotFound, connection.Restore("methode", generateResource().UUID, "tid_restore"))
+}
+
+func TestInMemoryRestoreWithinPolicyRetention(t *testing.T) {
+	memory := NewInMemoryDB(&config.Configuration{
+		Collections:        []string{"methode", "wordpress"},
+		TombstoneRetention: config.Duration{Duration: time.Hour},
+		Policies:           map[string]*config.Policy{"wordpress": {Retention: config.Duration{Duration: 3 * time.Hour}}},
+	})
+	c, err := memory.Open()
+	require.NoError(t, err)
+	connection := c.(*memoryConnection)
+
+	for _, collection := range []string{"methode", "wordpress"} {
+		resource := generateResource()
+		require.NoError(t, connection.Write(collection, resource, Precondition{}, nil))
+		require.NoError(t, connection.Delete(collection, resource.UUID, "tid_delete", Precondition{}))
+
+		connection.documents[collection][resource.UUID].tombstone.DeletedAt = now().Add(-2 * time.Hour)
+
+		err = connection.Restore(collection, resource.UUID, "tid_restore")
+		if collection == "wordpress" {
+			assert.NoError(t, err, "the retention of the policy should apply")
+		} else {
+			assert.Equal(t, ErrNotFound, err)
+		}
+	}
+}
+
+func TestInMemoryWriteReplacesTombstone(t *testing.T) {
+	connection := openInMemoryWithRetention(t, time.Hour)
+
+	resource := generateResource()
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+	require.NoError(t, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{}))
+	require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+
+	_, found, err := connection.Read("methode", resource.UUID)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, ErrNotFound, connection.Restore("methode", resource.UUID, "tid_restore"))
+}
+
+func TestInMemoryPurgeTombstones(t *testing.T) {
+	connection := openInMemoryWithRetention(t, time.Hour)
+
+	old := generateResource()
+	recent := generateResource()
+	live := generateResource()
+	for _, resource := range []*mapper.Resource{old, recent, live} {
+		require.NoError(t, connection.Write("methode", resource, Precondition{}, nil))
+	}
+	require.NoError(t, connection.Delete("methode", old.UUID, "tid_delete", Precondition{}))
+	require.NoError(t, connection.Delete("methode", recent.UUID, "tid_delete", Precondition{}))
+
+	connection.documents["methode"][old.UUID].tombstone.DeletedAt = now().Add(-2 * time.Hour)
+
+	purged, err := connection.PurgeTombstones("methode", now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	assert.NotContains(t, connection.documents["methode"], old.UUID)
+	assert.Contains(t, connection.documents["methode"], recent.UUID)
+	assert.Contains(t, connection.documents["methode"], live.UUID)
+}
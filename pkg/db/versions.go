@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+const (
+	revisionName     = "revision"
+	createdName      = "created"
+	lastModifiedName = "last-modified"
+)
+
+// Version describes a stored revision of a native document
+type Version struct {
+	Version        int64
+	Timestamp      time.Time
+	Hash           string
+	ContentType    string
+	OriginSystemID string
+	TransactionID  string
+}
+
+// versionDocument is the stored shape of a revision, kept in a sibling collection named after versionsCollection
+type versionDocument struct {
+	document  `bson:",inline"`
+	Timestamp time.Time `bson:"timestamp"`
+}
+
+func (v *versionDocument) version() *Version {
+	return &Version{
+		Version:        v.Revision,
+		Timestamp:      v.Timestamp,
+		Hash:           v.Hash,
+		ContentType:    v.ContentType,
+		OriginSystemID: v.OriginSystemID,
+		TransactionID:  v.TransactionID,
+	}
+}
+
+func versionsCollection(collection string) string {
+	return collection + "__versions"
+}
+
+// now truncates to milliseconds, which is the precision mongo stores dates with
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// currentProjection is what a write needs to know of the stored document
+var currentProjection = bson.D{
+	{Key: uuidName, Value: 1},
+	{Key: revisionName, Value: 1},
+	{Key: hashName, Value: 1},
+	{Key: tombstoneName, Value: 1},
+	{Key: createdName, Value: 1},
+	{Key: lastModifiedName, Value: 1},
+}
+
+// current returns the revision, hash, timestamps and tombstone of the stored document, or nil if there is none
+func (ma *mongoConnection) current(ctx mongo.SessionContext, collection string, uuidString string) (*document, error) {
+	opts := options.FindOne().SetProjection(currentProjection)
+
+	current := &document{}
+	err := ma.collection(collection).FindOne(ctx, uuidFilter(uuidString), opts).Decode(current)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return current, err
+}
+
+// nextRevision returns the revision the next write of the given document will get. The versions are checked as well as the
+// document itself, so that numbering carries on where it left off if the document has been deleted in between.
+func (ma *mongoConnection) nextRevision(ctx mongo.SessionContext, collection string, uuidString string, current *document) (int64, error) {
+	var revision int64
+	if current != nil {
+		revision = current.Revision
+	}
+
+	if ma.history[collection] > 0 {
+		opts := options.FindOne().
+			SetProjection(bson.D{{Key: revisionName, Value: 1}}).
+			SetSort(bson.D{{Key: revisionName, Value: -1}})
+
+		latest := &document{}
+		err := ma.collection(versionsCollection(collection)).FindOne(ctx, uuidFilter(uuidString), opts).Decode(latest)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return 0, err
+		}
+
+		if latest.Revision > revision {
+			revision = latest.Revision
+		}
+	}
+
+	return revision + 1, nil
+}
+
+// recordVersion stores the given document as a revision, and drops the ones beyond the history configured for the collection
+func (ma *mongoConnection) recordVersion(ctx mongo.SessionContext, collection string, doc *document) error {
+	return ma.recordVersions(ctx, collection, []*document{doc})
+}
+
+// recordVersions is recordVersion for documents with distinct uuids, in bulk
+func (ma *mongoConnection) recordVersions(ctx mongo.SessionContext, collection string, docs []*document) error {
+	max := ma.history[collection]
+	if max <= 0 || len(docs) == 0 {
+		return nil
+	}
+
+	timestamp := now()
+	inserts := make([]interface{}, 0, len(docs))
+	deletes := make([]mongo.WriteModel, 0, len(docs))
+	for _, doc := range docs {
+		inserts = append(inserts, &versionDocument{document: *doc, Timestamp: timestamp})
+		deletes = append(deletes, mongo.NewDeleteManyModel().SetFilter(bson.D{
+			{Key: uuidName, Value: doc.UUID},
+			{Key: revisionName, Value: bson.D{{Key: "$lte", Value: doc.Revision - int64(max)}}},
+		}))
+	}
+
+	versions := ma.collection(versionsCollection(collection))
+	if _, err := versions.InsertMany(ctx, inserts); err != nil {
+		return err
+	}
+
+	_, err := versions.BulkWrite(ctx, deletes)
+	return err
+}
+
+func (ma *mongoConnection) ReadVersions(collection string, uuidString string) ([]*Version, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	opts := options.Find().
+		SetProjection(bson.D{{Key: "content", Value: 0}}).
+		SetSort(bson.D{{Key: revisionName, Value: -1}})
+
+	cursor, err := ma.collection(versionsCollection(collection)).Find(ctx, uuidFilter(uuidString), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	versions := make([]*Version, 0)
+	for cursor.Next(ctx) {
+		doc := &versionDocument{}
+		if err = cursor.Decode(doc); err != nil {
+			return nil, err
+		}
+		versions = append(versions, doc.version())
+	}
+
+	return versions, cursor.Err()
+}
+
+func (ma *mongoConnection) ReadVersion(collection string, uuidString string, version int64) (res *mapper.Resource, found bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	filter := append(uuidFilter(uuidString), bson.E{Key: revisionName, Value: version})
+
+	doc := &versionDocument{}
+	if err = ma.collection(versionsCollection(collection)).FindOne(ctx, filter).Decode(doc); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return res, false, nil
+		}
+		return res, false, err
+	}
+
+	res, err = ma.resource(collection, &doc.document)
+	return res, err == nil, err
+}
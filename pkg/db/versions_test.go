@@ -0,0 +1,106 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/config"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func openInMemoryWithHistory(t *testing.T, history int) Connection {
+	memory := NewInMemoryDB(&config.Configuration{
+		Collections: []string{"methode", "wordpress"},
+		History:     map[string]int{"methode": history},
+	})
+	connection, err := memory.Open()
+	require.NoError(t, err)
+	return connection
+}
+
+func writeRevisions(t *testing.T, connection Connection, collection string, resource *mapper.Resource, count int) {
+	for i := 1; i <= count; i++ {
+		resource.Content = map[string]interface{}{"revision": float64(i)}
+		resource.TransactionID = "tid_" + string(rune('a'+i-1))
+		require.NoError(t, connection.Write(collection, resource, Precondition{}, nil))
+	}
+}
+
+func TestInMemoryVersions(t *testing.T) {
+	connection := openInMemoryWithHistory(t, 10)
+
+	resource := generateResource()
+	resource.OriginSystemID = "methode-web-pub"
+	writeRevisions(t, connection, "methode", resource, 3)
+
+	versions, err := connection.ReadVersions("methode", resource.UUID)
+	assert.NoError(t, err)
+	require.Len(t, versions, 3)
+
+	for i, version := range versions {
+		expected := int64(3 - i)
+		assert.Equal(t, expected, version.Version)
+		assert.Equal(t, "methode-web-pub", version.OriginSystemID)
+		assert.Equal(t, "application/json", version.ContentType)
+		assert.Equal(t, "tid_"+string(rune('a'+expected-1)), version.TransactionID)
+		assert.False(t, version.Timestamp.IsZero())
+
+		hash, err := mapper.ContentHash(map[string]interface{}{"revision": float64(expected)})
+		assert.NoError(t, err)
+		assert.Equal(t, hash, version.Hash)
+	}
+
+	res, found, err := connection.ReadVersion("methode", resource.UUID, 2)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, map[string]interface{}{"revision": float64(2)}, res.Content)
+	assert.Equal(t, "tid_b", res.TransactionID)
+
+	_, found, err = connection.ReadVersion("methode", resource.UUID, 4)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestInMemoryVersionsAreCapped(t *testing.T) {
+	connection := openInMemoryWithHistory(t, 2)
+
+	resource := generateResource()
+	writeRevisions(t, connection, "methode", resource, 5)
+
+	versions, err := connection.ReadVersions("methode", resource.UUID)
+	assert.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, int64(5), versions[0].Version)
+	assert.Equal(t, int64(4), versions[1].Version)
+
+	_, found, err := connection.ReadVersion("methode", resource.UUID, 3)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestInMemoryNoVersionsWithoutHistory(t *testing.T) {
+	connection := openInMemoryWithHistory(t, 10)
+
+	resource := generateResource()
+	writeRevisions(t, connection, "wordpress", resource, 3)
+
+	versions, err := connection.ReadVersions("wordpress", resource.UUID)
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+}
+
+func TestInMemoryVersionsSurviveDelete(t *testing.T) {
+	connection := openInMemoryWithHistory(t, 10)
+
+	resource := generateResource()
+	writeRevisions(t, connection, "methode", resource, 2)
+	require.NoError(t, connection.Delete("methode", resource.UUID, "tid_delete", Precondition{}))
+	writeRevisions(t, connection, "methode", resource, 1)
+
+	versions, err := connection.ReadVersions("methode", resource.UUID)
+	assert.NoError(t, err)
+	require.Len(t, versions, 3)
+	assert.Equal(t, int64(3), versions[0].Version)
+}
@@ -0,0 +1,168 @@
+package mapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var errNonFiniteNumber = errors.New("canonical json: NaN and infinite numbers are not supported")
+
+// CanonicalJSON encodes the given native content in its canonical json form, which hashes the same whichever encoder produced
+// the content. It follows the JSON Canonicalization Scheme (RFC 8785):
+//   - no whitespace
+//   - object keys sorted by their UTF-16 code units
+//   - every number written as the shortest form which round trips to the same double, as ECMAScript does, so 1, 1.0 and 1e0
+//     are all written as 1, and -0 as 0
+//   - strings only escape the quote, the backslash and the control characters, using the short escapes where they exist and
+//     \u00XX otherwise, while everything else (<, >, & and non ASCII characters included) is written as is
+//
+// Values which aren't plain json values, such as the raw bytes of binary content, are written as encoding/json writes them.
+func CanonicalJSON(content interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := writeCanonical(buf, content); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case string:
+		writeCanonicalString(buf, v)
+	case float64:
+		return writeCanonicalNumber(buf, v)
+	case float32:
+		return writeCanonicalNumber(buf, float64(v))
+	case int:
+		return writeCanonicalNumber(buf, float64(v))
+	case int32:
+		return writeCanonicalNumber(buf, float64(v))
+	case int64:
+		return writeCanonicalNumber(buf, float64(v))
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		return writeCanonicalNumber(buf, f)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return lessUTF16(keys[i], keys[j]) })
+
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, key)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		// round trip anything else through encoding/json, to bring it back to plain json values
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		var plain interface{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err = decoder.Decode(&plain); err != nil {
+			return err
+		}
+		return writeCanonical(buf, plain)
+	}
+	return nil
+}
+
+func writeCanonicalNumber(buf *bytes.Buffer, f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return errNonFiniteNumber
+	}
+
+	if f == 0 {
+		buf.WriteByte('0')
+		return nil
+	}
+
+	// encoding/json formats doubles as ECMAScript does
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+
+		switch {
+		case r == '"':
+			buf.WriteString(`\"`)
+		case r == '\\':
+			buf.WriteString(`\\`)
+		case r == '\b':
+			buf.WriteString(`\b`)
+		case r == '\f':
+			buf.WriteString(`\f`)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r < 0x20:
+			buf.WriteString(`\u00`)
+			buf.WriteByte(hexDigits[r>>4])
+			buf.WriteByte(hexDigits[r&0xf])
+		default:
+			// invalid UTF-8 is decoded as utf8.RuneError, and written as U+FFFD like encoding/json does
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+}
+
+func lessUTF16(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
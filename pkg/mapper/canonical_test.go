@@ -0,0 +1,59 @@
+package mapper
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	var tests = []struct {
+		name     string
+		content  interface{}
+		expected string
+	}{
+		{"literals", []interface{}{nil, true, false}, `[null,true,false]`},
+		{"sorted keys", map[string]interface{}{"b": 1.0, "a": map[string]interface{}{"d": 1.0, "c": 2.0}}, `{"a":{"c":2,"d":1},"b":1}`},
+		{"utf-16 key order", map[string]interface{}{"\u20ac": 1.0, "\r": 2.0, "\ufb33": 3.0, "1": 4.0, "\U0001F600": 5.0, "\u0080": 6.0, "\u00f6": 7.0},
+			"{\"\\r\":2,\"1\":4,\"\u0080\":6,\"\u00f6\":7,\"\u20ac\":1,\"\U0001F600\":5,\"\ufb33\":3}"},
+		{"numbers", []interface{}{1.0, 4.50, 2e-3, 0.000001, 1e-7, 1e21, 333333333.33333329, math.Copysign(0, -1), -1.5},
+			`[1,4.5,0.002,0.000001,1e-7,1e+21,333333333.3333333,0,-1.5]`},
+		{"number types", []interface{}{int(1), int32(2), int64(3), float32(0.5), json.Number("4.0")}, `[1,2,3,0.5,4]`},
+		{"escaping", "\"\\\b\f\n\r\t\u0001\u001f <>&\u2028 é", "\"\\\"\\\\\\b\\f\\n\\r\\t\\u0001\\u001f <>&\u2028 é\""},
+		{"binary", []byte("hi"), `"aGk="`},
+		{"other values", map[string]interface{}{"list": []string{"b", "a"}}, `{"list":["b","a"]}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := CanonicalJSON(test.content)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, string(actual))
+		})
+	}
+}
+
+func TestCanonicalJSONIsIndependentOfTheEncoder(t *testing.T) {
+	var fromCompact, fromVerbose interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"title":"<b>Title</b>","count":1,"ratio":0.5}`), &fromCompact))
+	assert.NoError(t, json.Unmarshal([]byte(`{ "ratio" : 5e-1, "count" : 1.0, "title" : "\u003cb\u003eTitle\u003c/b\u003e" }`), &fromVerbose))
+
+	compact, err := CanonicalJSON(fromCompact)
+	assert.NoError(t, err)
+
+	verbose, err := CanonicalJSON(fromVerbose)
+	assert.NoError(t, err)
+
+	assert.Equal(t, `{"count":1,"ratio":0.5,"title":"<b>Title</b>"}`, string(compact))
+	assert.Equal(t, compact, verbose)
+}
+
+func TestCanonicalJSONRejectsNonFiniteNumbers(t *testing.T) {
+	_, err := CanonicalJSON([]interface{}{math.NaN()})
+	assert.Equal(t, errNonFiniteNumber, err)
+
+	_, err = CanonicalJSON(map[string]interface{}{"inf": math.Inf(1)})
+	assert.Equal(t, errNonFiniteNumber, err)
+}
@@ -0,0 +1,49 @@
+package mapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// UnmarshalEnvelopeContent maps the content of a resource embedded in a json envelope, where json variants are embedded as they
+// are, and octet streams as base64 strings.
+func UnmarshalEnvelopeContent(contentType string, data json.RawMessage) (interface{}, error) {
+	if isApplicationJSONVariantWithDirectives(contentType) {
+		return jsonVariantInMapper(ioutil.NopCloser(bytes.NewReader(data)))
+	}
+
+	if isOctetStreamWithDirectives(contentType) {
+		var content []byte
+		err := json.Unmarshal(data, &content)
+		return content, err
+	}
+
+	return nil, ErrUnsupportedContentType
+}
+
+// MarshalEnvelopeContent is the reverse of UnmarshalEnvelopeContent, for embedding the content of the resource in a json envelope
+func MarshalEnvelopeContent(resource *Resource) (json.RawMessage, error) {
+	if isApplicationJSONVariantWithDirectives(resource.ContentType) {
+		return json.Marshal(resource.Content)
+	}
+
+	if isOctetStreamWithDirectives(resource.ContentType) {
+		if s, ok := resource.Content.(*Stream); ok {
+			data, err := s.Bytes()
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(data)
+		}
+
+		data, ok := resource.Content.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("expected binary content, got %T", resource.Content)
+		}
+		return json.Marshal(data)
+	}
+
+	return nil, ErrUnsupportedContentType
+}
@@ -0,0 +1,47 @@
+package mapper
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnmarshalEnvelopeContent(t *testing.T) {
+	content, err := UnmarshalEnvelopeContent(articleCt, json.RawMessage(`{"title":"Title"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"title": "Title"}, content)
+
+	content, err = UnmarshalEnvelopeContent(octetStreamCt, json.RawMessage(`"aGVsbG8="`))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), content)
+
+	_, err = UnmarshalEnvelopeContent(articleCt, json.RawMessage(`"not an object"`))
+	assert.Error(t, err)
+
+	_, err = UnmarshalEnvelopeContent(octetStreamCt, json.RawMessage(`{"not":"base64"}`))
+	assert.Error(t, err)
+
+	_, err = UnmarshalEnvelopeContent(textPlainCt, json.RawMessage(`"hello"`))
+	assert.Equal(t, ErrUnsupportedContentType, err)
+}
+
+func TestMarshalEnvelopeContent(t *testing.T) {
+	data, err := MarshalEnvelopeContent(&Resource{Content: map[string]interface{}{"title": "Title"}, ContentType: articleCt})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"title":"Title"}`, string(data))
+
+	data, err = MarshalEnvelopeContent(&Resource{Content: []byte("hello"), ContentType: octetStreamCt})
+	assert.NoError(t, err)
+	assert.Equal(t, `"aGVsbG8="`, string(data))
+
+	content, err := UnmarshalEnvelopeContent(octetStreamCt, data)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), content)
+
+	_, err = MarshalEnvelopeContent(&Resource{Content: "hello", ContentType: octetStreamCt})
+	assert.Error(t, err)
+
+	_, err = MarshalEnvelopeContent(&Resource{Content: "hello", ContentType: textPlainCt})
+	assert.Equal(t, ErrUnsupportedContentType, err)
+}
@@ -0,0 +1,21 @@
+package mapper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ContentHash hashes the canonical json form of the given native content in SHA224 + Hex, which is what publishers send as X-Native-Hash
+func ContentHash(content interface{}) (string, error) {
+	if s, ok := content.(*Stream); ok {
+		return streamHash(s)
+	}
+
+	data, err := CanonicalJSON(content)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum224(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"io/ioutil"
 	"strings"
+	"time"
 )
 
 var (
@@ -19,6 +20,11 @@ type Resource struct {
 	Content        interface{}
 	ContentType    string
 	OriginSystemID string
+	TransactionID  string
+	Revision       int64
+	Hash           string
+	Created        time.Time
+	LastModified   time.Time
 }
 
 // Wrap creates a new resource
@@ -52,6 +58,17 @@ func jsonVariantOutMapper(w io.Writer, resource *Resource) error {
 }
 
 func octetStreamOutMapper(w io.Writer, resource *Resource) error {
+	if s, ok := resource.Content.(*Stream); ok {
+		r, err := s.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		_, err = io.Copy(w, r)
+		return err
+	}
+
 	data := resource.Content.([]byte)
 	_, err := io.Copy(w, bytes.NewReader(data))
 	return err
@@ -75,6 +92,15 @@ func InMapperForContentType(contentType string) (InMapper, error) {
 
 }
 
+// StreamingInMapperForContentType is InMapperForContentType, except that octet streams are mapped to a Stream of the body, which
+// is only read as the content gets stored. The body is then left for the caller to close.
+func StreamingInMapperForContentType(contentType string) (InMapper, error) {
+	if isOctetStreamWithDirectives(contentType) {
+		return octetStreamStreamingInMapper, nil
+	}
+	return InMapperForContentType(contentType)
+}
+
 func jsonVariantInMapper(r io.ReadCloser) (interface{}, error) {
 	var c map[string]interface{}
 	defer r.Close()
@@ -87,6 +113,10 @@ func octetStreamInMapper(r io.ReadCloser) (interface{}, error) {
 	return ioutil.ReadAll(r)
 }
 
+func octetStreamStreamingInMapper(r io.ReadCloser) (interface{}, error) {
+	return streamOf(r), nil
+}
+
 func isApplicationJSONVariantWithDirectives(contentType string) bool {
 	contentType = stripDirectives(contentType)
 
@@ -132,3 +132,12 @@ func TestOctetStreamVariantEval(t *testing.T) {
 
 	assert.False(t, isOctetStreamWithDirectives(articlePlainCt))
 }
+
+func TestContentHash(t *testing.T) {
+	hash, err := ContentHash(map[string]interface{}{"foo": []interface{}{"a", "b"}, "bar": 10.4})
+	assert.NoError(t, err)
+	assert.Equal(t, "dc0c26f472f8099b75e7fc25951af986b6b112074d0419eb29d754e3", hash)
+
+	_, err = ContentHash(func() {})
+	assert.Error(t, err)
+}
@@ -0,0 +1,53 @@
+package mapper
+
+import "reflect"
+
+// MergeContent applies a PATCH body to the original native content, modifying the original in place. Rules to modify content :
+// 1- A field in order to be updated/removed must exists in both data sources (patchC, originalC):
+//
+//	1.1 Besides, in case of being updated, the field must be the same type (basic type, or slice).
+//	1.2 In case of being removed, the field in PatchC must be 'nil' and must exists in originalC.
+//	1.3 An empty patch data will not modify the original data stored in the DB.
+//
+// 2- New fields can also be added, whenever the new field does not exists in originalC and it is not 'nil' in PatchC.
+// Note: This method always returns an object (check rules above), it never panics and does not returns any errors
+// Note: slices are being treated as a single object, therefore a slice in PatchD will always overwrite an originalD's.
+// Note:Whenever a delete operation takes place within a hash struct, there is a need to check whether the result hash (parent) remains empty, in that case is removed.(recursive safe)
+func MergeContent(patchC, originalC map[string]interface{}) map[string]interface{} {
+	res := originalC
+	for key := range patchC {
+		_, oExists := originalC[key]
+		if oExists && compareConditions(patchC[key], originalC[key]) {
+
+			switch patchC[key].(type) {
+			case []interface{}:
+				res[key] = patchC[key]
+			case map[string]interface{}:
+				p, _ := patchC[key].(map[string]interface{})
+				o, _ := originalC[key].(map[string]interface{})
+				res[key] = MergeContent(p, o)
+				if emptyHash(res[key]) {
+					delete(res, key)
+				}
+			default:
+				if patchC[key] == nil {
+					delete(res, key)
+				} else if patchC[key] != res[key] {
+					res[key] = patchC[key]
+				}
+			}
+		} else if !oExists && patchC[key] != nil {
+			res[key] = patchC[key]
+		}
+	}
+	return res
+}
+
+func compareConditions(p, o interface{}) bool {
+	return reflect.TypeOf(p) == nil || reflect.TypeOf(p) == reflect.TypeOf(o)
+}
+
+func emptyHash(v interface{}) bool {
+	m, isMap := v.(map[string]interface{})
+	return isMap && len(m) == 0
+}
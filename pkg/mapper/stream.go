@@ -0,0 +1,93 @@
+package mapper
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+)
+
+var errStreamRead = errors.New("the stream has been read already")
+
+// Stream is binary content which is read from its source as it is stored or written out, rather than held in memory. It is the
+// content of the octet streams mapped by StreamingInMapperForContentType, and of the ones the native store keeps in GridFS.
+type Stream struct {
+	Size int64 // in bytes, or -1 until the stream has been read
+	open func() (io.ReadCloser, error)
+}
+
+// NewStream returns the stream of the given size, read from the readers returned by open
+func NewStream(size int64, open func() (io.ReadCloser, error)) *Stream {
+	return &Stream{Size: size, open: open}
+}
+
+// streamOf returns the stream of the given reader, which can only be read once
+func streamOf(r io.Reader) *Stream {
+	read := false
+	return NewStream(-1, func() (io.ReadCloser, error) {
+		if read {
+			return nil, errStreamRead
+		}
+		read = true
+		return ioutil.NopCloser(r), nil
+	})
+}
+
+// Open starts reading the stream, which must be closed once read
+func (s *Stream) Open() (io.ReadCloser, error) {
+	return s.open()
+}
+
+// Bytes reads the whole stream in memory
+func (s *Stream) Bytes() ([]byte, error) {
+	r, err := s.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// BinaryHash computes the ContentHash of binary content as it gets written, without holding it in memory
+type BinaryHash struct {
+	sum     hash.Hash
+	encoder io.WriteCloser
+}
+
+// NewBinaryHash starts hashing binary content
+func NewBinaryHash() *BinaryHash {
+	// binary content is hashed as the json string of its base64 encoding, which is the canonical json form of []byte
+	sum := sha256.New224()
+	sum.Write([]byte(`"`))
+	return &BinaryHash{sum: sum, encoder: base64.NewEncoder(base64.StdEncoding, sum)}
+}
+
+func (h *BinaryHash) Write(p []byte) (int, error) {
+	return h.encoder.Write(p)
+}
+
+// Sum returns the hash of the content written, in SHA224 + Hex. Nothing can be written after.
+func (h *BinaryHash) Sum() string {
+	_ = h.encoder.Close()
+	h.sum.Write([]byte(`"`))
+	return hex.EncodeToString(h.sum.Sum(nil))
+}
+
+// streamHash hashes the stream as ContentHash hashes the same content read in memory
+func streamHash(s *Stream) (string, error) {
+	r, err := s.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := NewBinaryHash()
+	if _, err = io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return h.Sum(), nil
+}
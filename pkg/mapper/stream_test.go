@@ -0,0 +1,65 @@
+package mapper
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamingMappers(t *testing.T) {
+	inMapper, err := StreamingInMapperForContentType("application/octet-stream; someArbitrary=directive")
+	require.NoError(t, err)
+
+	content, err := inMapper(ioutil.NopCloser(strings.NewReader(`hi`)))
+	require.NoError(t, err)
+
+	stream, ok := content.(*Stream)
+	require.True(t, ok, "octet streams should be mapped to a stream")
+	assert.Equal(t, int64(-1), stream.Size)
+
+	writer := &bytes.Buffer{}
+	outMapper, _ := OutMapperForContentType("application/octet-stream")
+	require.NoError(t, outMapper(writer, &Resource{Content: stream}))
+	assert.Equal(t, "hi", writer.String())
+
+	_, err = stream.Open()
+	assert.Equal(t, errStreamRead, err, "the body can only be read once")
+
+	inMapper, err = StreamingInMapperForContentType("application/json")
+	require.NoError(t, err)
+
+	content, err = inMapper(ioutil.NopCloser(strings.NewReader(`{"title":"Title"}`)))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"title": "Title"}, content)
+}
+
+func TestStreamHash(t *testing.T) {
+	data := bytes.Repeat([]byte("some binary content\x00\xff"), 1000)
+
+	expected, err := ContentHash(data)
+	require.NoError(t, err)
+
+	actual, err := ContentHash(streamOf(bytes.NewReader(data)))
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	h := NewBinaryHash()
+	for i := 0; i < len(data); i += 7 {
+		end := i + 7
+		if end > len(data) {
+			end = len(data)
+		}
+		_, _ = h.Write(data[i:end])
+	}
+	assert.Equal(t, expected, h.Sum(), "the hash shouldn't depend on how the content is written")
+}
+
+func TestMarshalStreamEnvelopeContent(t *testing.T) {
+	content, err := MarshalEnvelopeContent(&Resource{ContentType: "application/octet-stream", Content: streamOf(strings.NewReader("hi"))})
+	require.NoError(t, err)
+	assert.JSONEq(t, `"aGk="`, string(content))
+}
@@ -0,0 +1,53 @@
+package outbox
+
+import (
+	"expvar"
+	"strconv"
+	"sync"
+)
+
+const (
+	deliveredMetric  = "delivered"
+	failuresMetric   = "failures"
+	positionMetric   = "position"
+	lagMetric        = "lag"
+	lagSecondsMetric = "lagSeconds"
+	leaseMetric      = "leased"
+)
+
+// metrics are published by expvar at /debug/vars, under outbox.{collection}
+var (
+	metrics      = expvar.NewMap("outbox")
+	metricsMutex = &sync.Mutex{}
+)
+
+func metricsFor(collection string) *expvar.Map {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	if m, ok := metrics.Get(collection).(*expvar.Map); ok {
+		return m
+	}
+
+	m := new(expvar.Map).Init()
+	metrics.Set(collection, m)
+	return m
+}
+
+type intMetric int64
+
+func (i intMetric) String() string {
+	return strconv.FormatInt(int64(i), 10)
+}
+
+type floatMetric float64
+
+func (f floatMetric) String() string {
+	return strconv.FormatFloat(float64(f), 'f', 3, 64)
+}
+
+type boolMetric bool
+
+func (b boolMetric) String() string {
+	return strconv.FormatBool(bool(b))
+}
@@ -0,0 +1,377 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/config"
+	"github.com/Financial-Times/nativerw/pkg/db"
+)
+
+const (
+	defaultBatchSize    = 100
+	defaultPollInterval = time.Second
+	defaultLease        = 30 * time.Second
+	defaultBatchTime    = defaultLease / 3
+	defaultMinBackoff   = time.Second
+	defaultMaxBackoff   = time.Minute
+	collectionsRefresh  = 10 * time.Second
+)
+
+// The sinks which can be configured
+const (
+	kafkaSink     = "kafka"
+	fileSink      = "file"
+	inProcessSink = "inprocess"
+)
+
+// Event is the message delivered downstream for a change of a native document
+type Event struct {
+	Collection     string    `json:"collection"`
+	Token          string    `json:"token"`
+	UUID           string    `json:"uuid"`
+	Operation      string    `json:"operation"`
+	Hash           string    `json:"hash"`
+	Timestamp      time.Time `json:"timestamp"`
+	OriginSystemID string    `json:"originSystemId"`
+
+	sequence int64
+}
+
+func newEvent(collection string, change *db.Change) *Event {
+	return &Event{
+		Collection:     collection,
+		Token:          strconv.FormatInt(change.Sequence, 10),
+		UUID:           change.UUID,
+		Operation:      change.Operation,
+		Hash:           change.Hash,
+		Timestamp:      change.Timestamp,
+		OriginSystemID: change.OriginSystemID,
+
+		sequence: change.Sequence,
+	}
+}
+
+// Sink delivers events downstream. Deliver must only succeed once every event of the batch has been accepted, as they are not
+// delivered again afterwards; when it fails, the whole batch is delivered again, in the same order.
+type Sink interface {
+	Deliver(ctx context.Context, events []*Event) error
+}
+
+// progressSink is a sink which can take longer than the lease to deliver a batch, as it delivers the events one at a time, with
+// retries. Rather than the whole batch, it records its progress itself, through the lease: it renews the lease before each
+// attempt, advances the outbox past each event it is done with, and returns how many of them it got through. It stops at the
+// first error of the lease, which means another instance may have taken over, and doesn't start new events once the lease is
+// out of time for the batch, leaving them to the next one.
+type progressSink interface {
+	deliverEach(ctx context.Context, events []*Event, lease *batchLease) (int, error)
+}
+
+// batchLease is the lease of a dispatcher on an outbox while a progressSink delivers a batch from it
+type batchLease struct {
+	dispatcher *Dispatcher
+	feed       feed
+	deadline   time.Time
+}
+
+// renew extends the lease, and fails with db.ErrOutboxClaimed if another instance has claimed the outbox in the meantime
+func (l *batchLease) renew() error {
+	if l == nil {
+		return nil
+	}
+	_, err := l.dispatcher.connection.ClaimOutbox(l.feed.outbox, l.dispatcher.owner, l.feed.from, time.Now().Add(l.dispatcher.lease))
+	return err
+}
+
+// advance records that the changes up to the event have been delivered
+func (l *batchLease) advance(event *Event) error {
+	if l == nil {
+		return nil
+	}
+	return l.dispatcher.connection.AdvanceOutbox(l.feed.outbox, l.dispatcher.owner, event.sequence)
+}
+
+// expired tells whether the batch has run for long enough, and should leave its remaining events to the next one
+func (l *batchLease) expired() bool {
+	return l != nil && time.Now().After(l.deadline)
+}
+
+// NewSink returns the sink selected by the configuration, or nil if none is
+func NewSink(conf config.Outbox) (Sink, error) {
+	switch conf.Sink {
+	case "":
+		return nil, nil
+	case kafkaSink:
+		if conf.KafkaProxy == "" || conf.Topic == "" {
+			return nil, fmt.Errorf("the %s outbox sink needs a kafkaProxy and a topic", kafkaSink)
+		}
+		return NewProducerSink(NewRESTProxyProducer(conf.KafkaProxy), conf.Topic), nil
+	case fileSink:
+		if conf.File == "" {
+			return nil, fmt.Errorf("the %s outbox sink needs a file", fileSink)
+		}
+		sink, err := NewFileSink(conf.File)
+		if err != nil {
+			return nil, err
+		}
+		return sink, nil
+	case inProcessSink:
+		return NewInProcessSink(logEvent), nil
+	default:
+		return nil, fmt.Errorf("unsupported outbox sink %q", conf.Sink)
+	}
+}
+
+func logEvent(event *Event) error {
+	logger.Infof("Outbox event %s: %s %s in %s", event.Token, event.Operation, event.UUID, event.Collection)
+	return nil
+}
+
+// Dispatcher delivers the changes of the supported collections to a sink. The changes are recorded in the same transaction as
+// the writes, and the dispatcher only records the position it has delivered up to once the sink has accepted them, so every
+// change is delivered at least once, in sequence order, whatever fails in between. The outbox of a collection is leased to a single
+// instance at a time; an instance which dies with a delivered batch it hasn't recorded yet leaves it to be delivered again.
+type Dispatcher struct {
+	connection db.Connection
+	sink       Sink
+	owner      string
+
+	batchSize    int
+	pollInterval time.Duration
+	lease        time.Duration
+	batchTime    time.Duration // after which a progressSink starts no new event
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	refresh      time.Duration
+}
+
+// NewDispatcher returns a dispatcher of the changes of the connection to the given sink
+func NewDispatcher(connection db.Connection, sink Sink) *Dispatcher {
+	host, _ := os.Hostname()
+	return &Dispatcher{
+		connection: connection,
+		sink:       sink,
+		owner:      host + "/" + uuid.New(),
+
+		batchSize:    defaultBatchSize,
+		pollInterval: defaultPollInterval,
+		lease:        defaultLease,
+		batchTime:    defaultBatchTime,
+		minBackoff:   defaultMinBackoff,
+		maxBackoff:   defaultMaxBackoff,
+		refresh:      collectionsRefresh,
+	}
+}
+
+// feed is what an outbox delivers: the changes of the collection after the given sequence, then after the position it has
+// delivered up to. The outbox of a collection is named after it.
+type feed struct {
+	outbox     string
+	collection string
+	from       int64
+}
+
+// Run dispatches the changes of every supported collection until the context is cancelled. Collections are picked up, or
+// dropped, as they are registered or unregistered.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.refresh)
+	defer ticker.Stop()
+
+	running := make(map[string]context.CancelFunc)
+	wg := &sync.WaitGroup{}
+	for {
+		supported := d.connection.GetSupportedCollections()
+		for collection := range supported {
+			if _, found := running[collection]; found {
+				continue
+			}
+
+			collCtx, cancel := context.WithCancel(ctx)
+			running[collection] = cancel
+
+			wg.Add(1)
+			go func(collection string) {
+				defer wg.Done()
+				d.dispatch(collCtx, feed{outbox: collection, collection: collection})
+			}(collection)
+		}
+
+		for collection, cancel := range running {
+			if !supported[collection] {
+				cancel()
+				delete(running, collection)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, f feed) {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		delivered, err := d.dispatchBatch(ctx, f)
+		if err != nil && ctx.Err() == nil {
+			logger.WithError(err).Errorf("Failed to dispatch outbox %s", f.outbox)
+		}
+
+		// a full batch means there are more changes waiting already
+		if err == nil && delivered == d.batchSize {
+			continue
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// dispatchBatch delivers the next batch of changes of the feed, if this instance holds the lease on its outbox, and returns how
+// many it has delivered
+func (d *Dispatcher) dispatchBatch(ctx context.Context, f feed) (int, error) {
+	m := metricsFor(f.outbox)
+
+	position, err := d.connection.ClaimOutbox(f.outbox, d.owner, f.from, time.Now().Add(d.lease))
+	if err == db.ErrOutboxClaimed {
+		m.Set(leaseMetric, boolMetric(false))
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	m.Set(leaseMetric, boolMetric(true))
+
+	events, err := d.readEvents(ctx, f.collection, position)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = d.recordLag(f, position, events); err != nil {
+		return 0, err
+	}
+
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	if sink, ok := d.sink.(progressSink); ok {
+		return d.deliverEach(ctx, f, sink, events)
+	}
+
+	if err = d.deliver(ctx, f, events); err != nil {
+		return 0, err
+	}
+
+	last := events[len(events)-1].sequence
+	if err = d.connection.AdvanceOutbox(f.outbox, d.owner, last); err != nil {
+		return 0, err
+	}
+
+	m.Add(deliveredMetric, int64(len(events)))
+	m.Set(positionMetric, intMetric(last))
+	return len(events), d.recordLag(f, last, nil)
+}
+
+func (d *Dispatcher) readEvents(ctx context.Context, collection string, position int64) ([]*Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel() // stops the feed once the batch is full
+
+	changes, err := d.connection.ReadChanges(ctx, collection, position)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*Event, 0, d.batchSize)
+	for change := range changes {
+		events = append(events, newEvent(collection, change))
+		if len(events) == d.batchSize {
+			break
+		}
+	}
+	return events, nil
+}
+
+// deliver retries the batch with an exponential backoff until the sink accepts it, as long as this instance keeps the lease
+func (d *Dispatcher) deliver(ctx context.Context, f feed, events []*Event) error {
+	backoff := d.minBackoff
+	for {
+		err := d.sink.Deliver(ctx, events)
+		if err == nil {
+			return nil
+		}
+
+		metricsFor(f.outbox).Add(failuresMetric, 1)
+		logger.WithError(err).Warnf("Failed to deliver %d events of outbox %s, retrying in %s", len(events), f.outbox, backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if backoff *= 2; backoff > d.maxBackoff {
+			backoff = d.maxBackoff
+		}
+
+		if _, err = d.connection.ClaimOutbox(f.outbox, d.owner, f.from, time.Now().Add(d.lease)); err != nil {
+			return err
+		}
+	}
+}
+
+// deliverEach hands the batch to a sink which records its progress itself, and returns how many events it got through. A failed
+// batch is not retried as a whole: the next one starts from the event it failed at, once the poll interval has passed.
+func (d *Dispatcher) deliverEach(ctx context.Context, f feed, sink progressSink, events []*Event) (int, error) {
+	m := metricsFor(f.outbox)
+
+	done, err := sink.deliverEach(ctx, events, &batchLease{dispatcher: d, feed: f, deadline: time.Now().Add(d.batchTime)})
+	if err != nil {
+		m.Add(failuresMetric, 1)
+	}
+
+	if done == 0 {
+		return 0, err
+	}
+
+	last := events[done-1].sequence
+	m.Add(deliveredMetric, int64(done))
+	m.Set(positionMetric, intMetric(last))
+	if err != nil {
+		return done, err
+	}
+	return done, d.recordLag(f, last, nil)
+}
+
+// recordLag measures how many changes are waiting to be delivered, and for how long the oldest of the given ones has been
+func (d *Dispatcher) recordLag(f feed, position int64, pending []*Event) error {
+	last, err := d.connection.LastSequence(f.collection)
+	if err != nil {
+		return err
+	}
+
+	m := metricsFor(f.outbox)
+	m.Set(lagMetric, intMetric(last-position))
+
+	var age float64
+	if len(pending) > 0 {
+		age = time.Since(pending[0].Timestamp).Seconds()
+	}
+	m.Set(lagSecondsMetric, floatMetric(age))
+	return nil
+}
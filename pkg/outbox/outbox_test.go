@@ -0,0 +1,286 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/config"
+	"github.com/Financial-Times/nativerw/pkg/db"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+// recordingSink keeps what it has been delivered, and fails the given number of deliveries first
+type recordingSink struct {
+	mutex    *sync.Mutex
+	events   []*Event
+	failures int
+}
+
+func newRecordingSink(failures int) *recordingSink {
+	return &recordingSink{mutex: &sync.Mutex{}, failures: failures}
+}
+
+func (s *recordingSink) Deliver(ctx context.Context, events []*Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink unavailable")
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *recordingSink) delivered() []*Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*Event{}, s.events...)
+}
+
+func openInMemory(t *testing.T) db.Connection {
+	connection, err := db.NewInMemoryDB(&config.Configuration{Collections: []string{"methode"}}).Open()
+	require.NoError(t, err)
+	return connection
+}
+
+func newTestDispatcher(connection db.Connection, sink Sink) *Dispatcher {
+	d := NewDispatcher(connection, sink)
+	d.batchSize = 3
+	d.pollInterval = 10 * time.Millisecond
+	d.minBackoff = time.Millisecond
+	d.maxBackoff = 4 * time.Millisecond
+	d.refresh = 10 * time.Millisecond
+	return d
+}
+
+func write(t *testing.T, connection db.Connection) string {
+	id := uuid.New()
+	resource := &mapper.Resource{UUID: id, Content: map[string]interface{}{"uuid": id}, ContentType: "application/json", OriginSystemID: "methode-web-pub"}
+	require.NoError(t, connection.Write("methode", resource, db.Precondition{}, nil))
+	return id
+}
+
+var methodeFeed = feed{outbox: "methode", collection: "methode"}
+
+func metricValue(collection string, name string) int64 {
+	if v, ok := metricsFor(collection).Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestDispatchBatches(t *testing.T) {
+	connection := openInMemory(t)
+	sink := newRecordingSink(0)
+	d := newTestDispatcher(connection, sink)
+
+	ids := []string{write(t, connection), write(t, connection), write(t, connection), write(t, connection)}
+	require.NoError(t, connection.Delete("methode", ids[0], "tid_delete", db.Precondition{}))
+
+	delivered, err := d.dispatchBatch(context.Background(), methodeFeed)
+	require.NoError(t, err)
+	assert.Equal(t, 3, delivered)
+
+	delivered, err = d.dispatchBatch(context.Background(), methodeFeed)
+	require.NoError(t, err)
+	assert.Equal(t, 2, delivered)
+
+	delivered, err = d.dispatchBatch(context.Background(), methodeFeed)
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+
+	events := sink.delivered()
+	require.Len(t, events, 5)
+	for i, id := range append(ids, ids[0]) {
+		assert.Equal(t, id, events[i].UUID)
+		assert.Equal(t, "methode", events[i].Collection)
+		assert.Equal(t, "methode-web-pub", events[i].OriginSystemID)
+		assert.Equal(t, int64(i+1), events[i].sequence)
+	}
+	assert.Equal(t, db.OperationPut, events[0].Operation)
+	assert.Equal(t, db.OperationDelete, events[4].Operation)
+	assert.Equal(t, "5", events[4].Token)
+
+	position, err := connection.ClaimOutbox("methode", d.owner, 0, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), position)
+
+	assert.Equal(t, "0", metricsFor("methode").Get(lagMetric).String())
+}
+
+func TestDispatchRetriesUntilDelivered(t *testing.T) {
+	connection := openInMemory(t)
+	sink := newRecordingSink(3)
+	d := newTestDispatcher(connection, sink)
+	failures := metricValue("methode", failuresMetric)
+
+	id := write(t, connection)
+
+	delivered, err := d.dispatchBatch(context.Background(), methodeFeed)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+
+	events := sink.delivered()
+	require.Len(t, events, 1)
+	assert.Equal(t, id, events[0].UUID)
+	assert.Equal(t, failures+3, metricValue("methode", failuresMetric))
+}
+
+func TestDispatchStopsRetryingOnCancel(t *testing.T) {
+	connection := openInMemory(t)
+	d := newTestDispatcher(connection, newRecordingSink(1000))
+
+	write(t, connection)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := d.dispatchBatch(ctx, methodeFeed)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	position, err := connection.ClaimOutbox("methode", d.owner, 0, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), position, "nothing should have been recorded as delivered")
+}
+
+func TestDispatchOnlyWithTheLease(t *testing.T) {
+	connection := openInMemory(t)
+	first := newRecordingSink(0)
+	second := newRecordingSink(0)
+
+	write(t, connection)
+
+	delivered, err := newTestDispatcher(connection, first).dispatchBatch(context.Background(), methodeFeed)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+
+	write(t, connection)
+
+	delivered, err = newTestDispatcher(connection, second).dispatchBatch(context.Background(), methodeFeed)
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+	assert.Empty(t, second.delivered())
+	assert.Equal(t, "false", metricsFor("methode").Get(leaseMetric).String())
+}
+
+func TestRun(t *testing.T) {
+	connection := openInMemory(t)
+	sink := newRecordingSink(1)
+	d := newTestDispatcher(connection, sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+
+	for range make([]struct{}, 7) {
+		write(t, connection)
+	}
+
+	assert.Eventually(t, func() bool { return len(sink.delivered()) == 7 }, 2*time.Second, 10*time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the dispatcher should stop once cancelled")
+	}
+}
+
+func TestRunPicksUpRegisteredCollections(t *testing.T) {
+	connection := openInMemory(t)
+	sink := newRecordingSink(0)
+	d := newTestDispatcher(connection, sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	_, created, err := connection.RegisterCollection("video")
+	require.NoError(t, err)
+	require.True(t, created)
+
+	id := uuid.New()
+	resource := &mapper.Resource{UUID: id, Content: map[string]interface{}{"uuid": id}, ContentType: "application/json", OriginSystemID: "next-video-editor"}
+	require.NoError(t, connection.Write("video", resource, db.Precondition{}, nil))
+
+	assert.Eventually(t, func() bool {
+		events := sink.delivered()
+		return len(events) == 1 && events[0].Collection == "video" && events[0].UUID == id
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestMetricsArePublished(t *testing.T) {
+	collection := "published-" + uuid.New()
+	metricsFor(collection).Add(deliveredMetric, 2)
+	assert.Contains(t, expvar.Get("outbox").String(), `"`+collection+`": {"delivered": 2}`)
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.ndjson")
+	sink, err := NewSink(config.Outbox{Sink: "file", File: path})
+	require.NoError(t, err)
+	defer sink.(*FileSink).Close()
+
+	ts := time.Date(2020, 3, 4, 10, 30, 15, 0, time.UTC)
+	require.NoError(t, sink.Deliver(context.Background(), []*Event{{Collection: "methode", Token: "1", UUID: "a-real-uuid", Operation: "put", Hash: "hash", Timestamp: ts}}))
+	require.NoError(t, sink.Deliver(context.Background(), []*Event{{Collection: "methode", Token: "2", UUID: "a-real-uuid", Operation: "delete", Hash: "hash", Timestamp: ts}}))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `{"collection":"methode","token":"1","uuid":"a-real-uuid","operation":"put","hash":"hash","timestamp":"2020-03-04T10:30:15Z","originSystemId":""}
+{"collection":"methode","token":"2","uuid":"a-real-uuid","operation":"delete","hash":"hash","timestamp":"2020-03-04T10:30:15Z","originSystemId":""}`, strings.TrimSpace(string(data)))
+}
+
+func TestInProcessSink(t *testing.T) {
+	var received []string
+	sink := NewInProcessSink(func(event *Event) error {
+		if event.Token == "2" {
+			return errors.New("not now")
+		}
+		received = append(received, event.Token)
+		return nil
+	})
+
+	err := sink.Deliver(context.Background(), []*Event{{Token: "1"}, {Token: "2"}, {Token: "3"}})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"1"}, received)
+}
+
+func TestNewSink(t *testing.T) {
+	sink, err := NewSink(config.Outbox{})
+	assert.NoError(t, err)
+	assert.Nil(t, sink)
+
+	sink, err = NewSink(config.Outbox{Sink: "kafka", KafkaProxy: "http://kafka-rest-proxy:8082", Topic: "NativeCmsPublicationEvents"})
+	assert.NoError(t, err)
+	assert.IsType(t, &ProducerSink{}, sink)
+
+	sink, err = NewSink(config.Outbox{Sink: "inprocess"})
+	assert.NoError(t, err)
+	assert.IsType(t, InProcessSink(nil), sink)
+
+	for _, conf := range []config.Outbox{{Sink: "kafka", Topic: "topic"}, {Sink: "kafka", KafkaProxy: "http://kafka-rest-proxy:8082"}, {Sink: "file"}, {Sink: "rabbit"}} {
+		_, err = NewSink(conf)
+		assert.Error(t, err, conf.Sink)
+	}
+}
@@ -0,0 +1,135 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Message is a record for a Kafka topic
+type Message struct {
+	Key   []byte
+	Value []byte
+}
+
+// Producer is the part of a Kafka producer the outbox needs, which any Kafka client can be wrapped into. SendMessages must only
+// succeed once every message has been acknowledged by the brokers.
+type Producer interface {
+	SendMessages(ctx context.Context, topic string, messages []*Message) error
+}
+
+// ProducerSink delivers the events as json messages to a Kafka topic, keyed by the uuid of their document so that the changes
+// of a document stay in order in its partition
+type ProducerSink struct {
+	producer Producer
+	topic    string
+}
+
+// NewProducerSink returns a sink producing to the given topic
+func NewProducerSink(producer Producer, topic string) *ProducerSink {
+	return &ProducerSink{producer: producer, topic: topic}
+}
+
+func (s *ProducerSink) Deliver(ctx context.Context, events []*Event) error {
+	messages := make([]*Message, 0, len(events))
+	for _, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, &Message{Key: []byte(event.UUID), Value: value})
+	}
+
+	return s.producer.SendMessages(ctx, s.topic, messages)
+}
+
+// RESTProxyProducer produces to Kafka through the v2 API of a Kafka REST proxy
+type RESTProxyProducer struct {
+	address string
+	client  *http.Client
+}
+
+// NewRESTProxyProducer returns a producer for the REST proxy at the given address
+func NewRESTProxyProducer(address string) *RESTProxyProducer {
+	return &RESTProxyProducer{address: strings.TrimSuffix(address, "/"), client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+type proxyRecords struct {
+	Records []proxyRecord `json:"records"`
+}
+
+type proxyRecord struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type proxyOffsets struct {
+	Offsets []struct {
+		Partition int     `json:"partition"`
+		Offset    int64   `json:"offset"`
+		ErrorCode *int    `json:"error_code"`
+		Error     *string `json:"error"`
+	} `json:"offsets"`
+}
+
+// SendMessages produces the messages as binary records, which the proxy expects base64 encoded as json encodes byte slices
+func (p *RESTProxyProducer) SendMessages(ctx context.Context, topic string, messages []*Message) error {
+	records := proxyRecords{Records: make([]proxyRecord, 0, len(messages))}
+	for _, msg := range messages {
+		records.Records = append(records.Records, proxyRecord{Key: msg.Key, Value: msg.Value})
+	}
+
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", p.address+"/topics/"+url.PathEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/vnd.kafka.binary.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kafka REST proxy responded with status %d: %s", resp.StatusCode, string(data))
+	}
+
+	offsets := proxyOffsets{}
+	if err = json.Unmarshal(data, &offsets); err != nil {
+		return fmt.Errorf("unexpected response from the kafka REST proxy: %v", err)
+	}
+
+	for i, offset := range offsets.Offsets {
+		if offset.ErrorCode != nil || offset.Error != nil {
+			msg := ""
+			if offset.Error != nil {
+				msg = *offset.Error
+			}
+			return fmt.Errorf("kafka REST proxy failed to produce message %d: %s", i, msg)
+		}
+	}
+
+	if len(offsets.Offsets) != len(messages) {
+		return fmt.Errorf("kafka REST proxy acknowledged %d messages out of %d", len(offsets.Offsets), len(messages))
+	}
+	return nil
+}
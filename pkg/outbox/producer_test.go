@@ -0,0 +1,70 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProducerSink(t *testing.T) {
+	var received proxyRecords
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/topics/NativeCmsPublicationEvents", r.URL.Path)
+		assert.Equal(t, "application/vnd.kafka.binary.v2+json", r.Header.Get("Content-Type"))
+
+		body, _ := ioutil.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &received))
+
+		w.Header().Set("Content-Type", "application/vnd.kafka.v2+json")
+		_, _ = w.Write([]byte(`{"offsets":[{"partition":1,"offset":10,"error_code":null,"error":null},{"partition":0,"offset":4,"error_code":null,"error":null}]}`))
+	}))
+	defer server.Close()
+
+	sink := NewProducerSink(NewRESTProxyProducer(server.URL+"/"), "NativeCmsPublicationEvents")
+
+	ts := time.Date(2020, 3, 4, 10, 30, 15, 0, time.UTC)
+	err := sink.Deliver(context.Background(), []*Event{
+		{Collection: "methode", Token: "1", UUID: "a-real-uuid", Operation: "put", Hash: "hash", Timestamp: ts, OriginSystemID: "methode-web-pub"},
+		{Collection: "methode", Token: "2", UUID: "another-uuid", Operation: "patch", Hash: "hash", Timestamp: ts, OriginSystemID: "methode-web-pub"},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, received.Records, 2)
+	assert.Equal(t, "a-real-uuid", string(received.Records[0].Key))
+	assert.Equal(t, `{"collection":"methode","token":"1","uuid":"a-real-uuid","operation":"put","hash":"hash","timestamp":"2020-03-04T10:30:15Z","originSystemId":"methode-web-pub"}`, string(received.Records[0].Value))
+	assert.Equal(t, "another-uuid", string(received.Records[1].Key))
+}
+
+func TestRESTProxyProducerFailures(t *testing.T) {
+	var tests = []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"error status", http.StatusInternalServerError, `{"error_code":50001,"message":"Kafka error"}`},
+		{"failed record", http.StatusOK, `{"offsets":[{"partition":null,"offset":null,"error_code":2,"error":"Kafka retriable error"}]}`},
+		{"missing offsets", http.StatusOK, `{"offsets":[]}`},
+		{"unexpected body", http.StatusOK, `not json`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			err := NewRESTProxyProducer(server.URL).SendMessages(context.Background(), "topic", []*Message{{Key: []byte("key"), Value: []byte("{}")}})
+			assert.Error(t, err)
+		})
+	}
+}
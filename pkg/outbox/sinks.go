@@ -0,0 +1,66 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// FileSink appends the events to a file as newline delimited json, which is meant for local runs and tests
+type FileSink struct {
+	file  *os.File
+	mutex *sync.Mutex
+}
+
+// NewFileSink opens, or creates, the file the events are appended to
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file, mutex: &sync.Mutex{}}, nil
+}
+
+// Deliver only succeeds once the events have been synced to disk
+func (s *FileSink) Deliver(ctx context.Context, events []*Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	bw := bufio.NewWriter(s.file)
+	encoder := json.NewEncoder(bw)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// InProcessSink hands the events one by one to a function of the same process, which is meant for local runs and tests
+type InProcessSink func(event *Event) error
+
+// NewInProcessSink returns a sink calling the given function for every event
+func NewInProcessSink(fn func(event *Event) error) InProcessSink {
+	return fn
+}
+
+// Deliver stops at the first event the function fails on, so the whole batch is delivered again
+func (fn InProcessSink) Deliver(ctx context.Context, events []*Event) error {
+	for _, event := range events {
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenTarget is returned for a webhook which is, or resolves to, a loopback, private or link-local address, unless its
+// host is allowed
+var ErrForbiddenTarget = errors.New("webhooks can't call loopback, private or link-local addresses")
+
+// forbiddenNetworks are the ranges, besides the loopback, link-local, multicast and unspecified addresses, which only reach
+// internal services
+var forbiddenNetworks = parseNetworks("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "198.18.0.0/15", "fc00::/7")
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func forbidden(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+
+	for _, network := range forbiddenNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookTargets keeps the webhooks from calling internal services, like the cloud metadata endpoint, as anyone can subscribe one.
+// Only the hosts it is given are allowed to be loopback, private or link-local addresses.
+type WebhookTargets struct {
+	allowed map[string]bool
+
+	// lookup lets tests resolve hosts without a DNS
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// NewWebhookTargets returns the targets which only allow the given hosts to be internal addresses
+func NewWebhookTargets(allowedHosts []string) *WebhookTargets {
+	allowed := make(map[string]bool, len(allowedHosts))
+	for _, host := range allowedHosts {
+		allowed[strings.ToLower(host)] = true
+	}
+	return &WebhookTargets{allowed: allowed, lookup: net.DefaultResolver.LookupIPAddr}
+}
+
+func (t *WebhookTargets) allows(host string) bool {
+	return t.allowed[strings.ToLower(host)]
+}
+
+// Check rejects the url of a webhook whose host is, or resolves to, a forbidden address. A host which doesn't resolve is accepted,
+// as the calls check the addresses they connect to anyway.
+func (t *WebhookTargets) Check(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	host := u.Hostname()
+	if t.allows(host) {
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if forbidden(ip) {
+			return ErrForbiddenTarget
+		}
+		return nil
+	}
+
+	addrs, err := t.lookup(ctx, host)
+	if err != nil {
+		return nil
+	}
+
+	for _, addr := range addrs {
+		if forbidden(addr.IP) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrForbiddenTarget, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// Client returns the http client of the webhooks, which checks the address of every connection it opens, redirects included, as
+// resolved when it dials: a host which resolved to a public address when it was subscribed can't be pointed at an internal one
+// afterwards. It doesn't go through the proxy of the environment, which would hide the addresses it calls.
+func (t *WebhookTargets) Client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	guarded := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || forbidden(ip) {
+				return fmt.Errorf("%w: %s", ErrForbiddenTarget, host)
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			if host, _, err := net.SplitHostPort(address); err == nil && t.allows(host) {
+				return dialer.DialContext(ctx, network, address)
+			}
+			return guarded.DialContext(ctx, network, address)
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,85 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForbiddenAddresses(t *testing.T) {
+	for _, address := range []string{"127.0.0.1", "::1", "169.254.169.254", "fe80::1", "10.0.0.1", "172.16.5.4", "192.168.1.1", "100.64.0.1", "0.0.0.0", "fd00::1", "::ffff:10.0.0.1", "224.0.0.1"} {
+		assert.True(t, forbidden(net.ParseIP(address)), address)
+	}
+
+	for _, address := range []string{"93.184.216.34", "8.8.8.8", "172.32.0.1", "2606:2800:220:1:248:1893:25c8:1946"} {
+		assert.False(t, forbidden(net.ParseIP(address)), address)
+	}
+}
+
+func TestCheckWebhookTargets(t *testing.T) {
+	targets := NewWebhookTargets([]string{"hooks.internal"})
+	targets.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "metadata.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}, {IP: net.ParseIP("169.254.169.254")}}, nil
+		case "example.com":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	assert.NoError(t, targets.Check(context.Background(), "https://example.com/hook"))
+	assert.NoError(t, targets.Check(context.Background(), "http://hooks.internal:8080/hook"), "allowed hosts may be internal")
+	assert.NoError(t, targets.Check(context.Background(), "https://not-yet.example.com/hook"), "hosts which don't resolve are left to the calls")
+	assert.NoError(t, targets.Check(context.Background(), "https://93.184.216.34/hook"))
+
+	assert.True(t, errors.Is(targets.Check(context.Background(), "https://metadata.example.com/hook"), ErrForbiddenTarget), "any of the addresses of a host is enough")
+	assert.Equal(t, ErrForbiddenTarget, targets.Check(context.Background(), "http://169.254.169.254/latest/meta-data"))
+	assert.Equal(t, ErrForbiddenTarget, targets.Check(context.Background(), "http://[::1]:8080/hook"))
+}
+
+func TestWebhookClientChecksTheAddressItDials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// localhost is resolved when dialing, so it is only caught by the address it resolves to
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	local := "http://localhost:" + u.Port()
+
+	_, err = NewWebhookTargets(nil).Client(webhookTimeout).Get(local)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrForbiddenTarget))
+
+	resp, err := NewWebhookTargets([]string{"localhost"}).Client(webhookTimeout).Get(local)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestWebhookClientChecksRedirects(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer internal.Close()
+
+	redirect := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusFound))
+	defer redirect.Close()
+
+	// only the redirecting host is allowed, by the name the client dials it with
+	u, err := url.Parse(redirect.URL)
+	require.NoError(t, err)
+
+	_, err = NewWebhookTargets([]string{"localhost"}).Client(webhookTimeout).Get("http://localhost:" + u.Port())
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrForbiddenTarget), "the redirect to 127.0.0.1 should be refused")
+}
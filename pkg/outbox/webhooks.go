@@ -0,0 +1,285 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/db"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 of the payload, keyed with the secret of the subscription, as sha256={hex}
+	SignatureHeader    = "X-Nativerw-Signature"
+	subscriptionHeader = "X-Nativerw-Subscription"
+	tokenHeader        = "X-Nativerw-Token"
+
+	defaultWebhookAttempts   = 5
+	defaultWebhookMinBackoff = time.Second
+	defaultWebhookMaxBackoff = 30 * time.Second
+	webhookTimeout           = 10 * time.Second
+	subscriptionsRefresh     = 10 * time.Second
+
+	// the lease is renewed before each call, which can be a timeout and the longest backoff apart
+	webhookLease = 2 * (webhookTimeout + defaultWebhookMaxBackoff)
+	// no new event is posted once a batch has run for this long, the ones left are posted by the next batch
+	webhookBatchTime = webhookLease / 4
+)
+
+// Sign returns the signature of the payload for the given secret, as sent in SignatureHeader
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookSink posts the events a subscription is interested in to its webhook, one at a time. Each one is retried with an
+// exponential backoff, and recorded as a dead letter after the last attempt rather than holding up the ones after it. As the
+// retries of a batch can take minutes, a dispatcher records the position after each event, and renews its lease before each
+// call, so that no other instance takes over the outbox and posts the same events meanwhile.
+type WebhookSink struct {
+	connection   db.Connection
+	subscription *db.Subscription
+	client       *http.Client
+
+	attempts   int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// NewWebhookSink returns the sink of the given subscription
+func NewWebhookSink(connection db.Connection, subscription *db.Subscription, client *http.Client) *WebhookSink {
+	return &WebhookSink{
+		connection:   connection,
+		subscription: subscription,
+		client:       client,
+
+		attempts:   defaultWebhookAttempts,
+		minBackoff: defaultWebhookMinBackoff,
+		maxBackoff: defaultWebhookMaxBackoff,
+	}
+}
+
+// Deliver posts the whole batch, without renewing any lease
+func (s *WebhookSink) Deliver(ctx context.Context, events []*Event) error {
+	_, err := s.deliverEach(ctx, events, nil)
+	return err
+}
+
+func (s *WebhookSink) deliverEach(ctx context.Context, events []*Event, lease *batchLease) (int, error) {
+	// the events of other origin systems are only skipped, and recorded with the next one which is posted
+	var skipped *Event
+	posted := false
+	for i, event := range events {
+		if s.subscription.OriginSystemID != "" && s.subscription.OriginSystemID != event.OriginSystemID {
+			skipped = event
+			continue
+		}
+
+		if posted && lease.expired() {
+			return i, s.advance(lease, skipped)
+		}
+		posted = true
+
+		delivery, err := s.call(ctx, event, lease)
+		if err != nil {
+			return i, err
+		}
+
+		if err = ctx.Err(); err != nil {
+			return i, err
+		}
+
+		if delivery.Status == db.DeliveryDeadLetter {
+			logger.Warnf("Dead lettered event %s of collection %s for subscription %s: %s", event.Token, event.Collection, s.subscription.ID, delivery.Error)
+		}
+
+		if err = s.connection.RecordDelivery(delivery); err != nil {
+			return i, err
+		}
+
+		if err = lease.advance(event); err != nil {
+			return i, err
+		}
+		skipped = nil
+	}
+	return len(events), s.advance(lease, skipped)
+}
+
+// advance records the position after the skipped event, if any
+func (s *WebhookSink) advance(lease *batchLease, skipped *Event) error {
+	if skipped == nil {
+		return nil
+	}
+	return lease.advance(skipped)
+}
+
+// call posts the event until the webhook accepts it or it runs out of attempts. It only fails when the lease can't be renewed.
+func (s *WebhookSink) call(ctx context.Context, event *Event, lease *batchLease) (*db.Delivery, error) {
+	delivery := &db.Delivery{
+		Subscription: s.subscription.ID,
+		Sequence:     event.sequence,
+		UUID:         event.UUID,
+		Operation:    event.Operation,
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		delivery.Status = db.DeliveryDeadLetter
+		delivery.Error = err.Error()
+		delivery.Timestamp = time.Now().UTC()
+		return delivery, nil
+	}
+
+	backoff := s.minBackoff
+	for {
+		if err = lease.renew(); err != nil {
+			return nil, err
+		}
+
+		delivery.Attempts++
+		delivery.StatusCode, err = s.post(ctx, event, payload)
+		delivery.Timestamp = time.Now().UTC()
+
+		if err == nil {
+			delivery.Status = db.DeliveryDelivered
+			delivery.Error = ""
+			return delivery, nil
+		}
+
+		delivery.Error = err.Error()
+		if delivery.Attempts >= s.attempts {
+			delivery.Status = db.DeliveryDeadLetter
+			return delivery, nil
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return delivery, nil
+		}
+
+		if backoff *= 2; backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+	}
+}
+
+// post calls the webhook, which has to respond with a 2xx status for the event to be delivered
+func (s *WebhookSink) post(ctx context.Context, event *Event, payload []byte) (int, error) {
+	req, err := http.NewRequest("POST", s.subscription.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(s.subscription.Secret, payload))
+	req.Header.Set(subscriptionHeader, s.subscription.ID)
+	req.Header.Set(tokenHeader, event.Token)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Webhooks dispatches the changes to the webhooks of the subscriptions, each from its own outbox so that a failing webhook doesn't
+// hold up the others. Subscriptions are picked up, or dropped, as they are created or deleted.
+type Webhooks struct {
+	connection db.Connection
+	client     *http.Client
+	refresh    time.Duration
+
+	// newDispatcher lets tests speed up the dispatchers and their retries
+	newDispatcher func(sink *WebhookSink) *Dispatcher
+
+	running map[string]context.CancelFunc
+	wg      *sync.WaitGroup
+}
+
+// NewWebhooks returns the dispatcher of the webhooks of the subscriptions of the connection, which only calls the given targets
+func NewWebhooks(connection db.Connection, targets *WebhookTargets) *Webhooks {
+	return &Webhooks{
+		connection: connection,
+		client:     targets.Client(webhookTimeout),
+		refresh:    subscriptionsRefresh,
+		newDispatcher: func(sink *WebhookSink) *Dispatcher {
+			d := NewDispatcher(connection, sink)
+			d.lease = webhookLease
+			d.batchTime = webhookBatchTime
+			return d
+		},
+		running: make(map[string]context.CancelFunc),
+		wg:      &sync.WaitGroup{},
+	}
+}
+
+// Run dispatches the changes to the webhooks until the context is cancelled
+func (w *Webhooks) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.refresh)
+	defer ticker.Stop()
+
+	for {
+		w.sync(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			w.wg.Wait()
+			return
+		}
+	}
+}
+
+// sync starts dispatching to the new subscriptions, and stops dispatching to the deleted ones
+func (w *Webhooks) sync(ctx context.Context) {
+	subscriptions, err := w.connection.ReadSubscriptions()
+	if err != nil {
+		logger.WithError(err).Error("Failed to read the webhook subscriptions")
+		return
+	}
+
+	current := make(map[string]bool, len(subscriptions))
+	for _, subscription := range subscriptions {
+		current[subscription.ID] = true
+		if _, found := w.running[subscription.ID]; found {
+			continue
+		}
+
+		subCtx, cancel := context.WithCancel(ctx)
+		w.running[subscription.ID] = cancel
+
+		d := w.newDispatcher(NewWebhookSink(w.connection, subscription, w.client))
+		f := feed{outbox: db.SubscriptionOutbox(subscription.ID), collection: subscription.Collection, from: subscription.Since}
+
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			d.dispatch(subCtx, f)
+		}()
+	}
+
+	for id, cancel := range w.running {
+		if !current[id] {
+			cancel()
+			delete(w.running, id)
+		}
+	}
+}
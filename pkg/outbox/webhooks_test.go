@@ -0,0 +1,259 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/db"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+// webhook records the events it receives, and fails the given number of calls first
+type webhook struct {
+	mutex    *sync.Mutex
+	events   []*Event
+	failures int
+	calls    int
+}
+
+func newWebhook(t *testing.T, failures int) (*webhook, *httptest.Server) {
+	hook := &webhook{mutex: &sync.Mutex{}, failures: failures}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hook.mutex.Lock()
+		defer hook.mutex.Unlock()
+
+		hook.calls++
+		if hook.failures > 0 {
+			hook.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, Sign("secret", body), r.Header.Get(SignatureHeader))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		event := &Event{}
+		require.NoError(t, json.Unmarshal(body, event))
+		assert.Equal(t, event.Token, r.Header.Get(tokenHeader))
+		hook.events = append(hook.events, event)
+	}))
+	return hook, server
+}
+
+func (h *webhook) received() []*Event {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]*Event{}, h.events...)
+}
+
+func newTestSink(connection db.Connection, subscription *db.Subscription) *WebhookSink {
+	sink := NewWebhookSink(connection, subscription, http.DefaultClient)
+	sink.attempts = 3
+	sink.minBackoff = time.Millisecond
+	sink.maxBackoff = 2 * time.Millisecond
+	return sink
+}
+
+func writeFrom(t *testing.T, connection db.Connection, originSystemID string) string {
+	id := uuid.New()
+	resource := &mapper.Resource{UUID: id, Content: map[string]interface{}{"uuid": id}, ContentType: "application/json", OriginSystemID: originSystemID}
+	require.NoError(t, connection.Write("methode", resource, db.Precondition{}, nil))
+	return id
+}
+
+func TestSign(t *testing.T) {
+	assert.Equal(t, "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", Sign("key", []byte("The quick brown fox jumps over the lazy dog")))
+}
+
+func TestWebhookSinkDelivers(t *testing.T) {
+	connection := openInMemory(t)
+	hook, server := newWebhook(t, 2)
+	defer server.Close()
+
+	subscription := &db.Subscription{ID: uuid.New(), Collection: "methode", URL: server.URL, OriginSystemID: "methode-web-pub", Secret: "secret"}
+	d := newTestDispatcher(connection, newTestSink(connection, subscription))
+
+	first := writeFrom(t, connection, "methode-web-pub")
+	writeFrom(t, connection, "wordpress")
+	second := writeFrom(t, connection, "methode-web-pub")
+
+	delivered, err := d.dispatchBatch(context.Background(), feed{outbox: db.SubscriptionOutbox(subscription.ID), collection: "methode"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, delivered)
+
+	events := hook.received()
+	require.Len(t, events, 2, "only the changes from the origin system of the subscription should be delivered")
+	assert.Equal(t, first, events[0].UUID)
+	assert.Equal(t, second, events[1].UUID)
+
+	deliveries, err := connection.ReadDeliveries(subscription.ID, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, db.DeliveryDelivered, deliveries[1].Status)
+	assert.Equal(t, 3, deliveries[1].Attempts)
+	assert.Equal(t, http.StatusOK, deliveries[1].StatusCode)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+}
+
+func TestWebhookSinkDeadLetters(t *testing.T) {
+	connection := openInMemory(t)
+	hook, server := newWebhook(t, 3)
+	defer server.Close()
+
+	subscription := &db.Subscription{ID: uuid.New(), Collection: "methode", URL: server.URL, Secret: "secret"}
+	d := newTestDispatcher(connection, newTestSink(connection, subscription))
+
+	deadLetter := writeFrom(t, connection, "methode-web-pub")
+	next := writeFrom(t, connection, "methode-web-pub")
+
+	delivered, err := d.dispatchBatch(context.Background(), feed{outbox: db.SubscriptionOutbox(subscription.ID), collection: "methode"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, delivered)
+
+	events := hook.received()
+	require.Len(t, events, 1, "the dead letter shouldn't hold up the next change")
+	assert.Equal(t, next, events[0].UUID)
+
+	deadLetters, err := connection.ReadDeliveries(subscription.ID, db.DeliveryDeadLetter)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, deadLetter, deadLetters[0].UUID)
+	assert.Equal(t, 3, deadLetters[0].Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, deadLetters[0].StatusCode)
+	assert.Equal(t, "webhook responded with status 503", deadLetters[0].Error)
+}
+
+func TestWebhookSinkStopsWithoutTheLease(t *testing.T) {
+	connection := openInMemory(t)
+	subscription := &db.Subscription{ID: uuid.New(), Collection: "methode", Secret: "secret"}
+	outbox := db.SubscriptionOutbox(subscription.ID)
+
+	// another instance takes over the outbox while the first event is posted, once the lease has run out
+	hook, server := newWebhook(t, 0)
+	defer server.Close()
+	takeover := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		_, err := connection.ClaimOutbox(outbox, "another instance", 0, time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+		server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer takeover.Close()
+	subscription.URL = takeover.URL
+
+	d := newTestDispatcher(connection, newTestSink(connection, subscription))
+	d.lease = time.Millisecond
+
+	writeFrom(t, connection, "methode-web-pub")
+	writeFrom(t, connection, "methode-web-pub")
+
+	delivered, err := d.dispatchBatch(context.Background(), feed{outbox: outbox, collection: "methode"})
+	assert.Equal(t, db.ErrOutboxClaimed, err)
+	assert.Equal(t, 0, delivered)
+	assert.Len(t, hook.received(), 1, "the next event should be left to the instance which holds the lease")
+}
+
+func TestWebhookSinkRecordsEachEvent(t *testing.T) {
+	connection := openInMemory(t)
+	hook, server := newWebhook(t, 0)
+	defer server.Close()
+
+	subscription := &db.Subscription{ID: uuid.New(), Collection: "methode", URL: server.URL, OriginSystemID: "methode-web-pub", Secret: "secret"}
+	f := feed{outbox: db.SubscriptionOutbox(subscription.ID), collection: "methode"}
+	d := newTestDispatcher(connection, newTestSink(connection, subscription))
+	d.batchTime = 0
+
+	writeFrom(t, connection, "wordpress")
+	first := writeFrom(t, connection, "methode-web-pub")
+	second := writeFrom(t, connection, "methode-web-pub")
+
+	// out of time, a batch only posts its first event, and records the position after it
+	delivered, err := d.dispatchBatch(context.Background(), f)
+	require.NoError(t, err)
+	assert.Equal(t, 2, delivered, "the skipped event and the first one posted")
+
+	position, err := connection.ClaimOutbox(f.outbox, d.owner, 0, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), position)
+
+	delivered, err = d.dispatchBatch(context.Background(), f)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+
+	events := hook.received()
+	require.Len(t, events, 2)
+	assert.Equal(t, first, events[0].UUID)
+	assert.Equal(t, second, events[1].UUID)
+}
+
+func TestWebhookSinkUnreachable(t *testing.T) {
+	connection := openInMemory(t)
+	_, server := newWebhook(t, 0)
+	server.Close()
+
+	subscription := &db.Subscription{ID: uuid.New(), Collection: "methode", URL: server.URL, Secret: "secret"}
+	sink := newTestSink(connection, subscription)
+
+	require.NoError(t, sink.Deliver(context.Background(), []*Event{{Collection: "methode", Token: "1", UUID: uuid.New(), sequence: 1}}))
+
+	deadLetters, err := connection.ReadDeliveries(subscription.ID, db.DeliveryDeadLetter)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, 0, deadLetters[0].StatusCode)
+	assert.NotEmpty(t, deadLetters[0].Error)
+}
+
+func TestWebhooksFollowSubscriptions(t *testing.T) {
+	connection := openInMemory(t)
+	hook, server := newWebhook(t, 0)
+	defer server.Close()
+
+	writeFrom(t, connection, "methode-web-pub")
+	since, err := connection.LastSequence("methode")
+	require.NoError(t, err)
+
+	subscription := &db.Subscription{ID: uuid.New(), Collection: "methode", URL: server.URL, Secret: "secret", Since: since, Created: time.Now()}
+	require.NoError(t, connection.CreateSubscription(subscription))
+
+	webhooks := NewWebhooks(connection, NewWebhookTargets([]string{"127.0.0.1"}))
+	webhooks.refresh = 10 * time.Millisecond
+	webhooks.newDispatcher = func(sink *WebhookSink) *Dispatcher {
+		sink.attempts = 1
+		return newTestDispatcher(connection, sink)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		webhooks.Run(ctx)
+		close(done)
+	}()
+
+	id := writeFrom(t, connection, "methode-web-pub")
+	assert.Eventually(t, func() bool { return len(hook.received()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, id, hook.received()[0].UUID, "changes from before the subscription shouldn't be delivered")
+
+	require.NoError(t, connection.DeleteSubscription(subscription.ID))
+	time.Sleep(100 * time.Millisecond)
+
+	writeFrom(t, connection, "methode-web-pub")
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, hook.received(), 1, "nothing should be delivered once the subscription is deleted")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the webhooks should stop once cancelled")
+	}
+}
@@ -0,0 +1,124 @@
+package resources
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/db"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+const maxBatchRead = 1000
+
+type batchReadRequest struct {
+	UUIDs []string `json:"uuids"`
+}
+
+type batchDocument struct {
+	UUID           string          `json:"uuid"`
+	ContentType    string          `json:"contentType"`
+	OriginSystemID string          `json:"originSystemId"`
+	Hash           string          `json:"hash"`
+	Content        json.RawMessage `json:"content,omitempty"`
+	Error          string          `json:"error,omitempty"`
+}
+
+type batchReadResponse struct {
+	Documents []batchDocument `json:"documents"`
+	Missing   []string        `json:"missing"`
+}
+
+// BatchRead responds with the native documents of the given uuids, in the order they were asked for, and the uuids of the ones
+// which don't exist. The content of each document is embedded as json for json content types, and as base64 for binary content.
+func BatchRead(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		collection := mux.Vars(r)["collection"]
+		tid := obtainTxID(r)
+
+		req := batchReadRequest{}
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, fmt.Sprintf("Invalid batch read request: %v", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		if len(req.UUIDs) > maxBatchRead {
+			writeMessage(w, fmt.Sprintf("Too many uuids, at most %d can be read at once", maxBatchRead), http.StatusBadRequest)
+			return
+		}
+
+		uuids := make([]string, 0, len(req.UUIDs))
+		seen := make(map[string]bool, len(req.UUIDs))
+		for _, id := range req.UUIDs {
+			if err = validateAccess(connection, collection, id); err != nil {
+				writeMessage(w, fmt.Sprintf("Invalid resourceId (%v)", id), http.StatusBadRequest)
+				return
+			}
+
+			if !seen[id] {
+				seen[id] = true
+				uuids = append(uuids, id)
+			}
+		}
+
+		resources, err := connection.ReadMany(collection, uuids)
+		if err != nil {
+			msg := "Reading from mongoDB failed."
+			logger.WithTransactionID(tid).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf(msg+": %v", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		found := make(map[string]*mapper.Resource, len(resources))
+		for _, resource := range resources {
+			found[resource.UUID] = resource
+		}
+
+		resp := batchReadResponse{Documents: make([]batchDocument, 0, len(resources)), Missing: make([]string, 0)}
+		for _, id := range uuids {
+			resource, ok := found[id]
+			if !ok {
+				resp.Missing = append(resp.Missing, id)
+				continue
+			}
+			resp.Documents = append(resp.Documents, newBatchDocument(resource, tid))
+		}
+
+		logger.WithTransactionID(tid).Infof("Read %d native documents of collection %s, %d missing", len(resp.Documents), collection, len(resp.Missing))
+		writeJSON(w, tid, resp, http.StatusOK)
+	}
+}
+
+// newBatchDocument embeds the resource in the response, or the reason why its content can't be
+func newBatchDocument(resource *mapper.Resource, tid string) batchDocument {
+	doc := batchDocument{
+		UUID:           resource.UUID,
+		ContentType:    resource.ContentType,
+		OriginSystemID: resource.OriginSystemID,
+		Hash:           resource.Hash,
+	}
+
+	var err error
+	if doc.Hash == "" {
+		if doc.Hash, err = mapper.ContentHash(resource.Content); err != nil {
+			logger.WithTransactionID(tid).WithUUID(resource.UUID).WithError(err).Warn("Failed to hash native content")
+		}
+	}
+
+	if doc.Content, err = mapper.MarshalEnvelopeContent(resource); err != nil {
+		logger.WithTransactionID(tid).WithUUID(resource.UUID).WithError(err).Warn("Unable to embed native content")
+		doc.Error = err.Error()
+	}
+	return doc
+}
@@ -0,0 +1,132 @@
+package resources
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func batchReadRouter(mongo *MockDB) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/__batch-read", BatchRead(mongo)).Methods("POST")
+	return router
+}
+
+func TestBatchRead(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("GetSupportedCollections").Return(map[string]bool{"methode": true})
+	connection.On("ReadMany", "methode", []string{
+		"9694733e-163a-4393-801f-000ab7de5041",
+		"59b3a4d0-9a17-11e6-8dbb-4b02b9e5d5ff",
+		"8b17c8b6-c1c3-4c5e-8e4d-c2c3d4b0a0f1",
+	}).Return([]*mapper.Resource{
+		{UUID: "8b17c8b6-c1c3-4c5e-8e4d-c2c3d4b0a0f1", Content: []byte("hello"), ContentType: "application/octet-stream", Hash: "binary-hash"},
+		{UUID: "9694733e-163a-4393-801f-000ab7de5041", Content: map[string]interface{}{"title": "Title"}, ContentType: "application/json", OriginSystemID: "methode-web-pub", Hash: "json-hash"},
+	}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/__batch-read", strings.NewReader(`{"uuids":["9694733e-163a-4393-801f-000ab7de5041","59b3a4d0-9a17-11e6-8dbb-4b02b9e5d5ff","8b17c8b6-c1c3-4c5e-8e4d-c2c3d4b0a0f1","9694733e-163a-4393-801f-000ab7de5041"]}`))
+
+	batchReadRouter(mongo).ServeHTTP(w, req)
+
+	mongo.AssertExpectations(t)
+	connection.AssertExpectations(t)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"documents": [
+			{"uuid":"9694733e-163a-4393-801f-000ab7de5041","contentType":"application/json","originSystemId":"methode-web-pub","hash":"json-hash","content":{"title":"Title"}},
+			{"uuid":"8b17c8b6-c1c3-4c5e-8e4d-c2c3d4b0a0f1","contentType":"application/octet-stream","originSystemId":"","hash":"binary-hash","content":"aGVsbG8="}
+		],
+		"missing": ["59b3a4d0-9a17-11e6-8dbb-4b02b9e5d5ff"]
+	}`, w.Body.String())
+}
+
+func TestBatchReadComputesMissingHashes(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("GetSupportedCollections").Return(map[string]bool{"methode": true})
+	connection.On("ReadMany", "methode", []string{"9694733e-163a-4393-801f-000ab7de5041"}).Return([]*mapper.Resource{
+		{UUID: "9694733e-163a-4393-801f-000ab7de5041", Content: map[string]interface{}{"title": "Title"}, ContentType: "application/json"},
+	}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/__batch-read", strings.NewReader(`{"uuids":["9694733e-163a-4393-801f-000ab7de5041"]}`))
+
+	batchReadRouter(mongo).ServeHTTP(w, req)
+
+	hash, _ := mapper.ContentHash(map[string]interface{}{"title": "Title"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"hash":"`+hash+`"`)
+}
+
+func TestBatchReadInvalid(t *testing.T) {
+	var tests = []struct {
+		name string
+		body string
+	}{
+		{"not json", `not json`},
+		{"invalid uuid", `{"uuids":["9694733e-163a-4393-801f-000ab7de5041","not-a-uuid"]}`},
+		{"too many uuids", `{"uuids":[` + strings.Repeat(`"9694733e-163a-4393-801f-000ab7de5041",`, maxBatchRead) + `"9694733e-163a-4393-801f-000ab7de5041"]}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mongo := new(MockDB)
+			connection := new(MockConnection)
+
+			mongo.On("Open").Return(connection, nil)
+			connection.On("GetSupportedCollections").Return(map[string]bool{"methode": true})
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/methode/__batch-read", strings.NewReader(test.body))
+
+			batchReadRouter(mongo).ServeHTTP(w, req)
+
+			connection.AssertNotCalled(t, "ReadMany", mock.Anything, mock.Anything)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestBatchReadFails(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("GetSupportedCollections").Return(map[string]bool{"methode": true})
+	connection.On("ReadMany", "methode", mock.Anything).Return([]*mapper.Resource(nil), errors.New("oh no"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/__batch-read", strings.NewReader(`{"uuids":["9694733e-163a-4393-801f-000ab7de5041"]}`))
+
+	batchReadRouter(mongo).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestBatchReadMongoOpenFails(t *testing.T) {
+	mongo := new(MockDB)
+	mongo.On("Open").Return(nil, errors.New("no data 4 u"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/__batch-read", strings.NewReader(`{"uuids":[]}`))
+
+	batchReadRouter(mongo).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
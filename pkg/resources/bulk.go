@@ -0,0 +1,270 @@
+package resources
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/config"
+	"github.com/Financial-Times/nativerw/pkg/db"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+// The statuses of the lines of a bulk write
+const (
+	bulkOK        = "ok"
+	bulkUnchanged = "unchanged"
+	bulkInvalid   = "invalid"
+	bulkError     = "error"
+)
+
+const bulkBatchSize = 100
+
+const (
+	// bulkLineOverhead leaves room for the uuid, content type and origin system of a line, on top of its content
+	bulkLineOverhead = 4 << 10
+	// defaultBulkLineLimit caps the lines of the collections without a max body size: the content of a mongo document can't be
+	// over 16MB, which base64 grows by a third
+	defaultBulkLineLimit = 16<<20*4/3 + bulkLineOverhead
+)
+
+var errLineTooLong = errors.New("line too long")
+
+type bulkLine struct {
+	UUID           string          `json:"uuid"`
+	ContentType    string          `json:"contentType"`
+	OriginSystemID string          `json:"originSystemId"`
+	Content        json.RawMessage `json:"content"`
+}
+
+type bulkResult struct {
+	Line   int    `json:"line"`
+	UUID   string `json:"uuid,omitempty"`
+	Status string `json:"status"`
+	Hash   string `json:"hash,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkWrite writes the native documents of a newline delimited json body, for migrations and backfills. Each line is written as a
+// PUT would, except the ones whose content hash is the one already stored, and is answered by a line with its outcome. A line
+// which can't be written doesn't stop the ones after it, but a line over the max body size of the collection stops the bulk write
+// before it is read in memory: with 413 if no result has been streamed yet, in which case nothing has been written, or with an
+// error line after the results of the lines before it otherwise.
+func BulkWrite(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		b := &bulkWriter{
+			connection: connection,
+			collection: mux.Vars(r)["collection"],
+			policy:     policyFromContext(r.Context()),
+			tid:        obtainTxID(r),
+			w:          w,
+			bw:         bufio.NewWriter(w),
+			uuids:      make(map[string]bool),
+			counts:     make(map[string]int),
+		}
+
+		lineLimit := defaultBulkLineLimit
+		if limit := b.policy.BodySizeLimit(); limit > 0 {
+			lineLimit = int(limit) + bulkLineOverhead
+		}
+
+		reader := bufio.NewReader(r.Body)
+		for line := 1; ; line++ {
+			data, err := readLine(reader, lineLimit)
+			if len(bytes.TrimSpace(data)) > 0 && (err == nil || err == io.EOF) {
+				b.add(line, data)
+			}
+
+			if err == io.EOF {
+				break
+			}
+
+			if err == errLineTooLong {
+				msg := fmt.Sprintf("Line %d is over the max size of collection %s, of %d bytes", line, b.collection, lineLimit)
+				logger.WithTransactionID(b.tid).Warn(msg)
+				if !b.streamed {
+					writeMessage(w, msg, http.StatusRequestEntityTooLarge)
+					return
+				}
+
+				b.results = append(b.results, &bulkResult{Line: line, Status: bulkError, Error: msg})
+				break
+			}
+
+			if err != nil {
+				logger.WithTransactionID(b.tid).WithError(err).Error("Reading the bulk write body failed")
+				b.results = append(b.results, &bulkResult{Line: line, Status: bulkError, Error: err.Error()})
+				break
+			}
+		}
+		b.flush()
+
+		logger.WithTransactionID(b.tid).Infof("Bulk write to collection %s done: %d written, %d unchanged, %d invalid, %d failed",
+			b.collection, b.counts[bulkOK], b.counts[bulkUnchanged], b.counts[bulkInvalid], b.counts[bulkError])
+	}
+}
+
+// readLine reads the next line, failing with errLineTooLong without reading the rest of it once it is longer than the limit
+func readLine(reader *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > limit {
+			return nil, errLineTooLong
+		}
+
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// bulkWriter batches the resources of a bulk write, and streams the results of their lines once their batch is written
+type bulkWriter struct {
+	connection db.Connection
+	collection string
+	policy     *config.Policy
+	tid        string
+	w          http.ResponseWriter
+	bw         *bufio.Writer
+
+	batch    []*mapper.Resource
+	pending  []*bulkResult
+	uuids    map[string]bool
+	results  []*bulkResult
+	counts   map[string]int
+	streamed bool // whether results have been streamed already
+}
+
+func (b *bulkWriter) add(line int, data []byte) {
+	resource, err := b.parse(data)
+	if err != nil {
+		result := &bulkResult{Line: line, Status: bulkInvalid, Error: err.Error()}
+		if resource != nil {
+			result.UUID = resource.UUID
+		}
+		b.results = append(b.results, result)
+		return
+	}
+
+	// a batch is written from the documents stored before it, so the same uuid can't be written twice in one
+	if b.uuids[resource.UUID] {
+		b.flush()
+	}
+
+	result := &bulkResult{Line: line, UUID: resource.UUID}
+	b.batch = append(b.batch, resource)
+	b.pending = append(b.pending, result)
+	b.results = append(b.results, result)
+	b.uuids[resource.UUID] = true
+
+	if len(b.batch) >= bulkBatchSize {
+		b.flush()
+	}
+}
+
+// parse maps a line to the resource to write, or returns why it can't be written
+func (b *bulkWriter) parse(data []byte) (*mapper.Resource, error) {
+	l := bulkLine{}
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("invalid json: %v", err)
+	}
+
+	resource := mapper.Wrap(nil, l.UUID, l.ContentType, l.OriginSystemID)
+	if err := validateAccess(b.connection, b.collection, l.UUID); err != nil {
+		return resource, fmt.Errorf("invalid uuid %q", l.UUID)
+	}
+
+	if resource.ContentType == "" {
+		resource.ContentType = "application/octet-stream"
+	}
+
+	if v := checkPolicy(b.policy, b.collection, l.UUID, resource.ContentType, l.OriginSystemID); v != nil {
+		return resource, v
+	}
+
+	if limit := b.policy.BodySizeLimit(); limit > 0 && int64(len(l.Content)) > limit {
+		return resource, fmt.Errorf("content over the max size of collection %s, of %d bytes", b.collection, limit)
+	}
+
+	content, err := mapper.UnmarshalEnvelopeContent(resource.ContentType, l.Content)
+	if err != nil {
+		return resource, fmt.Errorf("invalid content for content-type %q: %v", resource.ContentType, err)
+	}
+
+	if violations := validateContent(b.policy, content); len(violations) > 0 {
+		if !b.policy.WarnOnly() {
+			m := schemaMetricsFor(b.collection)
+			m.Add(rejectedMetric, 1)
+			m.Add(violationsMetric, int64(len(violations)))
+			return resource, fmt.Errorf("content doesn't match the schema of collection %s: %s", b.collection, joinViolations(violations))
+		}
+		warnViolations(b.collection, b.tid, l.UUID, violations)
+	}
+
+	resource.Content = content
+	resource.TransactionID = b.tid
+	return resource, nil
+}
+
+// flush writes the current batch, and streams the results of the lines read so far
+func (b *bulkWriter) flush() {
+	if len(b.batch) > 0 {
+		written, err := b.connection.BulkWrite(b.collection, b.batch)
+		if err != nil {
+			logger.WithTransactionID(b.tid).WithError(err).Errorf("Bulk writing %d documents to mongoDB failed", len(b.batch))
+		}
+
+		for i, result := range b.pending {
+			switch {
+			case err != nil:
+				result.Status = bulkError
+				result.Error = err.Error()
+			case written[i]:
+				result.Status = bulkOK
+				result.Hash = b.batch[i].Hash
+			default:
+				result.Status = bulkUnchanged
+				result.Hash = b.batch[i].Hash
+			}
+		}
+	}
+
+	if !b.streamed {
+		b.w.Header().Set("Content-Type", "application/x-ndjson")
+		b.streamed = true
+	}
+
+	for _, result := range b.results {
+		b.counts[result.Status]++
+
+		jd, _ := json.Marshal(result)
+		if _, err := b.bw.WriteString(string(jd) + "\n"); err != nil {
+			logger.WithTransactionID(b.tid).WithError(err).Error("unable to write string")
+		}
+	}
+
+	b.bw.Flush()
+	b.w.(http.Flusher).Flush()
+
+	b.batch = nil
+	b.pending = nil
+	b.results = nil
+	b.uuids = make(map[string]bool)
+}
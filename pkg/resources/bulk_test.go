@@ -0,0 +1,177 @@
+package resources
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/config"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func bulkRouter(mongo *MockDB) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/__bulk", BulkWrite(mongo)).Methods("POST")
+	return router
+}
+
+// hashed sets the hashes of the resources, as the connection does
+func hashed(resources []*mapper.Resource) {
+	for _, resource := range resources {
+		resource.Hash = "hash-of-" + resource.UUID[:8]
+	}
+}
+
+func TestBulkWrite(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("GetSupportedCollections").Return(map[string]bool{"methode": true})
+	connection.On("BulkWrite", "methode", mock.MatchedBy(func(resources []*mapper.Resource) bool {
+		return len(resources) == 3 &&
+			assert.ObjectsAreEqual(map[string]interface{}{"title": "Title"}, resources[0].Content) &&
+			resources[0].ContentType == "application/json" &&
+			resources[0].OriginSystemID == "methode-web-pub" &&
+			resources[0].TransactionID == "tid_bulk" &&
+			assert.ObjectsAreEqual([]byte("hello"), resources[1].Content) &&
+			resources[2].ContentType == "application/octet-stream"
+	})).Run(func(args mock.Arguments) {
+		hashed(args.Get(1).([]*mapper.Resource))
+	}).Return([]bool{true, true, false}, nil)
+
+	body := `{"uuid":"9694733e-163a-4393-801f-000ab7de5041","contentType":"application/json","originSystemId":"methode-web-pub","content":{"title":"Title"}}
+{"uuid":"not-a-uuid","contentType":"application/json","content":{}}
+
+{"uuid":"59b3a4d0-9a17-11e6-8dbb-4b02b9e5d5ff","contentType":"application/octet-stream","content":"aGVsbG8="}
+not json
+{"uuid":"8b17c8b6-c1c3-4c5e-8e4d-c2c3d4b0a0f1","contentType":"text/plain","content":"hello"}
+{"uuid":"8b17c8b6-c1c3-4c5e-8e4d-c2c3d4b0a0f1","content":"aGVsbG8="}`
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/__bulk", strings.NewReader(body))
+	req.Header.Set("X-Request-Id", "tid_bulk")
+
+	bulkRouter(mongo).ServeHTTP(w, req)
+
+	mongo.AssertExpectations(t)
+	connection.AssertExpectations(t)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"line":1,"uuid":"9694733e-163a-4393-801f-000ab7de5041","status":"ok","hash":"hash-of-9694733e"}
+{"line":2,"uuid":"not-a-uuid","status":"invalid","error":"invalid uuid \"not-a-uuid\""}
+{"line":4,"uuid":"59b3a4d0-9a17-11e6-8dbb-4b02b9e5d5ff","status":"ok","hash":"hash-of-59b3a4d0"}
+{"line":5,"status":"invalid","error":"invalid json: invalid character 'o' in literal null (expecting 'u')"}
+{"line":6,"uuid":"8b17c8b6-c1c3-4c5e-8e4d-c2c3d4b0a0f1","status":"invalid","error":"invalid content for content-type \"text/plain\": unsupported content-type, no mapping implementation"}
+{"line":7,"uuid":"8b17c8b6-c1c3-4c5e-8e4d-c2c3d4b0a0f1","status":"unchanged","hash":"hash-of-8b17c8b6"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestBulkWriteBatches(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("GetSupportedCollections").Return(map[string]bool{"methode": true})
+
+	var sizes []int
+	connection.On("BulkWrite", "methode", mock.AnythingOfType("[]*mapper.Resource")).Run(func(args mock.Arguments) {
+		sizes = append(sizes, len(args.Get(1).([]*mapper.Resource)))
+	}).Return(make([]bool, bulkBatchSize), nil)
+
+	lines := make([]string, 0, bulkBatchSize+3)
+	for i := 0; i < bulkBatchSize+2; i++ {
+		lines = append(lines, fmt.Sprintf(`{"uuid":"9694733e-163a-4393-801f-%012d","contentType":"application/json","content":{}}`, i))
+	}
+	lines = append(lines, lines[bulkBatchSize])
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/__bulk", strings.NewReader(strings.Join(lines, "\n")+"\n"))
+
+	bulkRouter(mongo).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []int{bulkBatchSize, 2, 1}, sizes, "a uuid should only be written once per batch")
+	assert.Len(t, strings.Split(strings.TrimSpace(w.Body.String()), "\n"), bulkBatchSize+3)
+}
+
+func TestBulkWriteFails(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("GetSupportedCollections").Return(map[string]bool{"methode": true})
+	connection.On("BulkWrite", "methode", mock.AnythingOfType("[]*mapper.Resource")).Return([]bool(nil), errors.New("oh no"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/__bulk", strings.NewReader(`{"uuid":"9694733e-163a-4393-801f-000ab7de5041","contentType":"application/json","content":{}}`))
+
+	bulkRouter(mongo).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"line":1,"uuid":"9694733e-163a-4393-801f-000ab7de5041","status":"error","error":"oh no"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestBulkWriteMongoOpenFails(t *testing.T) {
+	mongo := new(MockDB)
+	mongo.On("Open").Return(nil, errors.New("no data 4 u"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/__bulk", strings.NewReader(""))
+
+	bulkRouter(mongo).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestBulkWriteLineTooLong(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("GetSupportedCollections").Return(map[string]bool{"methode": true})
+	connection.On("BulkWrite", "methode", mock.AnythingOfType("[]*mapper.Resource")).Return(make([]bool, bulkBatchSize), nil).Once()
+
+	conf, err := config.ReadConfigFromReader(strings.NewReader(`{"maxBodySize": 32}`))
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/__bulk", Filter(BulkWrite(mongo)).EnforcePolicy(conf).Build()).Methods("POST")
+
+	tooLong := `{"uuid":"9694733e-163a-4393-801f-000ab7de5041","contentType":"application/json","content":{"title":"` + strings.Repeat("a", bulkLineOverhead) + `"}}`
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/__bulk", strings.NewReader(`{"uuid":"9694733e-163a-4393-801f-000ab7de5041","contentType":"application/json","content":{}}`+"\n"+tooLong))
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	connection.AssertNotCalled(t, "BulkWrite", "methode", mock.Anything)
+
+	lines := make([]string, 0, bulkBatchSize+1)
+	for i := 0; i < bulkBatchSize; i++ {
+		lines = append(lines, fmt.Sprintf(`{"uuid":"9694733e-163a-4393-801f-%012d","contentType":"application/json","content":{}}`, i))
+	}
+	lines = append(lines, tooLong, lines[0])
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/methode/__bulk", strings.NewReader(strings.Join(lines, "\n")))
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	connection.AssertExpectations(t)
+
+	results := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, results, bulkBatchSize+1, "the lines after the one too long should not be read")
+	assert.Equal(t, `{"line":101,"status":"error","error":"Line 101 is over the max size of collection methode, of 4128 bytes"}`, results[bulkBatchSize])
+}
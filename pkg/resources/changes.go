@@ -0,0 +1,96 @@
+package resources
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/db"
+)
+
+type change struct {
+	Token          string    `json:"token"`
+	UUID           string    `json:"uuid"`
+	Operation      string    `json:"operation"`
+	Hash           string    `json:"hash"`
+	Timestamp      time.Time `json:"timestamp"`
+	OriginSystemID string    `json:"originSystemId"`
+}
+
+// ReadChanges streams the changes of the collection as newline delimited json, in sequence order. Each change carries the token to
+// resume the feed after it, which is passed back as the since parameter; without one, the feed starts from the oldest change kept.
+// A token older than the retention of the changes is gone.
+func ReadChanges(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		coll := mux.Vars(r)["collection"]
+		tid := obtainTxID(r)
+
+		since, err := parseChangeToken(r.URL.Query().Get("since"))
+		if err != nil {
+			writeMessage(w, fmt.Sprintf("Invalid since token: %v", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		changes, err := connection.ReadChanges(ctx, coll, since)
+		if err == db.ErrChangesPurged {
+			writeMessage(w, fmt.Sprintf("The changes of %v after token %v have been purged, read the feed again from the start", coll, since), http.StatusGone)
+			return
+		}
+		if err != nil {
+			msg := fmt.Sprintf(`Failed to read changes from mongo for %v! "%v"`, coll, err.Error())
+			logger.WithTransactionID(tid).WithError(err).Error(msg)
+			http.Error(w, msg, http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+
+		bw := bufio.NewWriter(w)
+		for c := range changes {
+			jd, _ := json.Marshal(change{
+				Token:          strconv.FormatInt(c.Sequence, 10),
+				UUID:           c.UUID,
+				Operation:      c.Operation,
+				Hash:           c.Hash,
+				Timestamp:      c.Timestamp,
+				OriginSystemID: c.OriginSystemID,
+			})
+
+			if _, err = bw.WriteString(string(jd) + "\n"); err != nil {
+				logger.WithTransactionID(tid).WithError(err).Error("unable to write string")
+			}
+
+			bw.Flush()
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+// parseChangeToken returns the sequence of the change the token was given with, or 0 for the start of the feed
+func parseChangeToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	since, err := strconv.ParseInt(token, 10, 64)
+	if err != nil || since < 0 {
+		return 0, fmt.Errorf("%q is not a change token", token)
+	}
+	return since, nil
+}
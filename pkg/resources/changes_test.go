@@ -0,0 +1,141 @@
+package resources
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/Financial-Times/nativerw/pkg/db"
+)
+
+func TestReadChanges(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	changes := make(chan *db.Change, 2)
+	changes <- &db.Change{Sequence: 42, UUID: "a-real-uuid", Operation: db.OperationPut, Hash: "hash", Timestamp: time.Date(2020, 3, 4, 10, 30, 15, 0, time.UTC), OriginSystemID: "methode-web-pub"}
+	changes <- &db.Change{Sequence: 43, UUID: "a-real-uuid", Operation: db.OperationDelete, Hash: "hash", Timestamp: time.Date(2020, 3, 4, 10, 31, 0, 0, time.UTC), OriginSystemID: "methode-web-pub"}
+	close(changes)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadChanges", mock.AnythingOfType("*context.timerCtx"), "methode", int64(41)).Return(changes, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/__changes", ReadChanges(mongo)).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/methode/__changes?since=41", http.NoBody)
+
+	router.ServeHTTP(w, req)
+
+	mongo.AssertExpectations(t)
+	connection.AssertExpectations(t)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"token":"42","uuid":"a-real-uuid","operation":"put","hash":"hash","timestamp":"2020-03-04T10:30:15Z","originSystemId":"methode-web-pub"}
+{"token":"43","uuid":"a-real-uuid","operation":"delete","hash":"hash","timestamp":"2020-03-04T10:31:00Z","originSystemId":"methode-web-pub"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestReadChangesFromTheStart(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	changes := make(chan *db.Change)
+	close(changes)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadChanges", mock.AnythingOfType("*context.timerCtx"), "methode", int64(0)).Return(changes, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/__changes", ReadChanges(mongo)).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/methode/__changes", http.NoBody)
+
+	router.ServeHTTP(w, req)
+
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestReadChangesInvalidToken(t *testing.T) {
+	for _, token := range []string{"abc", "-1", "1.5"} {
+		mongo := new(MockDB)
+		connection := new(MockConnection)
+		mongo.On("Open").Return(connection, nil)
+
+		router := mux.NewRouter()
+		router.HandleFunc("/{collection}/__changes", ReadChanges(mongo)).Methods("GET")
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/methode/__changes?since="+token, http.NoBody)
+
+		router.ServeHTTP(w, req)
+
+		connection.AssertNotCalled(t, "ReadChanges", mock.Anything, mock.Anything, mock.Anything)
+		assert.Equal(t, http.StatusBadRequest, w.Code, token)
+	}
+}
+
+func TestReadChangesMongoOpenFails(t *testing.T) {
+	mongo := new(MockDB)
+	mongo.On("Open").Return(nil, errors.New("no data 4 u"))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/__changes", ReadChanges(mongo)).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/methode/__changes", http.NoBody)
+
+	router.ServeHTTP(w, req)
+	mongo.AssertExpectations(t)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestReadChangesMongoCallFails(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadChanges", mock.AnythingOfType("*context.timerCtx"), "methode", int64(0)).Return(make(chan *db.Change), errors.New("oh no"))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/__changes", ReadChanges(mongo)).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/methode/__changes", http.NoBody)
+
+	router.ServeHTTP(w, req)
+	mongo.AssertExpectations(t)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestReadPurgedChanges(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadChanges", mock.AnythingOfType("*context.timerCtx"), "methode", int64(42)).Return(make(chan *db.Change), db.ErrChangesPurged)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/__changes", ReadChanges(mongo)).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/methode/__changes?since=42", http.NoBody)
+
+	router.ServeHTTP(w, req)
+	connection.AssertExpectations(t)
+
+	assert.Equal(t, http.StatusGone, w.Code)
+}
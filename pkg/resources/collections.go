@@ -0,0 +1,127 @@
+package resources
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/db"
+)
+
+type collection struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+}
+
+func newCollection(c *db.Collection) collection {
+	return collection{Name: c.Name, Created: c.Created}
+}
+
+// ReadCollections lists the registered collections by name
+func ReadCollections(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		tid := obtainTxID(r)
+
+		collections, err := connection.ReadCollections()
+		if err != nil {
+			msg := "Reading collections from mongoDB failed."
+			logger.WithTransactionID(tid).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf(msg+": %v", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		resp := make([]collection, 0, len(collections))
+		for _, c := range collections {
+			resp = append(resp, newCollection(c))
+		}
+		writeJSON(w, tid, resp, http.StatusOK)
+	}
+}
+
+// RegisterCollection starts supporting a collection, on every instance within a few seconds, after creating its indexes.
+// Registering a collection which is registered already does nothing.
+func RegisterCollection(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		tid := obtainTxID(r)
+
+		req := collection{}
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, fmt.Sprintf("Invalid collection: %v", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		registered, created, err := connection.RegisterCollection(req.Name)
+		if err == db.ErrInvalidCollectionName {
+			writeMessage(w, fmt.Sprintf("Invalid collection %q: %v", req.Name, err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		if err != nil {
+			msg := "Registering the collection in mongoDB failed"
+			logger.WithTransactionID(tid).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf("%s\n%v\n", msg, err), http.StatusInternalServerError)
+			return
+		}
+
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+			logger.WithTransactionID(tid).Infof("Registered collection %s", registered.Name)
+		}
+
+		w.Header().Set("Location", "/__collections/"+registered.Name)
+		writeJSON(w, tid, newCollection(registered), status)
+	}
+}
+
+// UnregisterCollection stops supporting the collection, whose documents are kept for when it is registered again
+func UnregisterCollection(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		tid := obtainTxID(r)
+		name := mux.Vars(r)["name"]
+
+		err = connection.UnregisterCollection(name)
+		if err == db.ErrNotFound {
+			writeMessage(w, fmt.Sprintf("Collection not found, name= %v", name), http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			msg := "Unregistering the collection in mongoDB failed"
+			logger.WithTransactionID(tid).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf("%s\n%v\n", msg, err), http.StatusInternalServerError)
+			return
+		}
+
+		logger.WithTransactionID(tid).Infof("Unregistered collection %s", name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
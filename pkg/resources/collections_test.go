@@ -0,0 +1,136 @@
+package resources
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/db"
+)
+
+func collectionsRouter(mongo db.DB) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/__collections", ReadCollections(mongo)).Methods("GET")
+	router.HandleFunc("/__collections", RegisterCollection(mongo)).Methods("POST")
+	router.HandleFunc("/__collections/{name}", UnregisterCollection(mongo)).Methods("DELETE")
+	return router
+}
+
+func TestReadCollections(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadCollections").Return([]*db.Collection{{Name: "methode", Created: created}, {Name: "video", Created: created}}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/__collections", http.NoBody)
+
+	collectionsRouter(mongo).ServeHTTP(w, req)
+
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"name":"methode","created":"2020-01-02T03:04:05Z"},{"name":"video","created":"2020-01-02T03:04:05Z"}]`, w.Body.String())
+}
+
+func TestRegisterCollection(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("RegisterCollection", "video").Return(&db.Collection{Name: "video", Created: time.Now()}, true, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/__collections", strings.NewReader(`{"name":"video"}`))
+
+	collectionsRouter(mongo).ServeHTTP(w, req)
+
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "/__collections/video", w.Header().Get("Location"))
+
+	resp := collection{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "video", resp.Name)
+}
+
+func TestRegisterCollectionAlreadyRegistered(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("RegisterCollection", "methode").Return(&db.Collection{Name: "methode", Created: time.Now()}, false, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/__collections", strings.NewReader(`{"name":"methode"}`))
+
+	collectionsRouter(mongo).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRegisterCollectionInvalid(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("RegisterCollection", "__collections").Return((*db.Collection)(nil), false, db.ErrInvalidCollectionName)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/__collections", strings.NewReader(`{"name":"__collections"}`))
+
+	collectionsRouter(mongo).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/__collections", strings.NewReader(`not json`))
+
+	collectionsRouter(mongo).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRegisterCollectionFails(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("RegisterCollection", "video").Return((*db.Collection)(nil), false, errors.New("no registry"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/__collections", strings.NewReader(`{"name":"video"}`))
+
+	collectionsRouter(mongo).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestUnregisterCollection(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("UnregisterCollection", "video").Return(nil)
+	connection.On("UnregisterCollection", "missing").Return(db.ErrNotFound)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/__collections/video", http.NoBody)
+
+	collectionsRouter(mongo).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/__collections/missing", http.NoBody)
+
+	collectionsRouter(mongo).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	connection.AssertExpectations(t)
+}
@@ -10,7 +10,7 @@ import (
 	"github.com/Financial-Times/nativerw/pkg/db"
 )
 
-// DeleteContent deletes the given resource from the given collection
+// DeleteContent deletes the given resource from the given collection, leaving a tombstone behind so that it can be restored for a while
 func DeleteContent(mongo db.DB) func(writer http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
@@ -26,7 +26,15 @@ func DeleteContent(mongo db.DB) func(writer http.ResponseWriter, req *http.Reque
 		tid := obtainTxID(r)
 		contentTypeHeader := extractAttrFromHeader(r, "Content-Type", "application/octet-stream", tid, resourceID)
 
-		if err := connection.Delete(collectionID, resourceID); err != nil {
+		err = connection.Delete(collectionID, resourceID, tid, preconditionFromRequest(r))
+		if err == db.ErrPreconditionFailed {
+			msg := "Precondition failed, the resource has been modified in the meantime"
+			logger.WithMonitoringEvent("SaveToNative", tid, contentTypeHeader).WithUUID(resourceID).Info(msg)
+			writeMessage(w, msg, http.StatusPreconditionFailed)
+			return
+		}
+
+		if err != nil {
 			msg := "Deleting from mongoDB failed"
 			logger.WithMonitoringEvent("SaveToNative", tid, contentTypeHeader).WithUUID(resourceID).WithError(err).Error(msg)
 			http.Error(w, fmt.Sprintf("%s\n%v\n", msg, err), http.StatusInternalServerError)
@@ -9,13 +9,15 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/Financial-Times/nativerw/pkg/db"
 )
 
 func TestDeleteContent(t *testing.T) {
 	mongo := new(MockDB)
 	connection := new(MockConnection)
 
-	connection.On("Delete", "methode", "a-real-uuid").Return(nil)
+	connection.On("Delete", "methode", "a-real-uuid", testTxID, db.Precondition{}).Return(nil)
 	mongo.On("Open").Return(connection, nil)
 
 	router := mux.NewRouter()
@@ -23,9 +25,11 @@ func TestDeleteContent(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("DELETE", "/methode/a-real-uuid", strings.NewReader(``))
+	req.Header.Add("X-Request-Id", testTxID)
 
 	router.ServeHTTP(w, req)
 	mongo.AssertExpectations(t)
+	connection.AssertExpectations(t)
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
@@ -33,7 +37,7 @@ func TestFailedDelete(t *testing.T) {
 	mongo := new(MockDB)
 	connection := new(MockConnection)
 
-	connection.On("Delete", "methode", "a-real-uuid").Return(errors.New("i failed"))
+	connection.On("Delete", "methode", "a-real-uuid", testTxID, db.Precondition{}).Return(errors.New("i failed"))
 	mongo.On("Open").Return(connection, nil)
 
 	router := mux.NewRouter()
@@ -41,6 +45,7 @@ func TestFailedDelete(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("DELETE", "/methode/a-real-uuid", strings.NewReader(``))
+	req.Header.Add("X-Request-Id", testTxID)
 
 	router.ServeHTTP(w, req)
 	mongo.AssertExpectations(t)
@@ -61,3 +66,23 @@ func TestFailedMongoOnDelete(t *testing.T) {
 	mongo.AssertExpectations(t)
 	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
 }
+
+func TestDeleteContentPreconditionFailed(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	connection.On("Delete", "methode", "a-real-uuid", testTxID, db.Precondition{IfMatch: []int64{2}}).Return(db.ErrPreconditionFailed)
+	mongo.On("Open").Return(connection, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}", DeleteContent(mongo)).Methods("DELETE")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/methode/a-real-uuid", strings.NewReader(``))
+	req.Header.Add("X-Request-Id", testTxID)
+	req.Header.Add("If-Match", `"2"`)
+
+	router.ServeHTTP(w, req)
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+}
@@ -0,0 +1,264 @@
+package resources
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/config"
+)
+
+const (
+	gzipEncoding     = "gzip"
+	zstdEncoding     = "zstd"
+	identityEncoding = "identity"
+)
+
+// encodings are the content codings supported, in order of preference when a client accepts several equally
+var encodings = []string{zstdEncoding, gzipEncoding}
+
+// decodedBody reads the decoded content of an encoded request body, and closes both
+type decodedBody struct {
+	io.Reader
+	body  io.Closer
+	close func()
+}
+
+func (b *decodedBody) Close() error {
+	b.close()
+	return b.body.Close()
+}
+
+func decodeBody(encoding string, body io.ReadCloser) (io.ReadCloser, error) {
+	switch encoding {
+	case gzipEncoding:
+		r, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return &decodedBody{Reader: r, body: body, close: func() { _ = r.Close() }}, nil
+	case zstdEncoding:
+		r, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &decodedBody{Reader: r, body: body, close: r.Close}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content-encoding %q, it should be %s or %s", encoding, gzipEncoding, zstdEncoding)
+	}
+}
+
+// DecodeContent decompresses the request bodies sent with a gzip or zstd Content-Encoding, so that the filters and handlers after
+// it read the content as it was before it got compressed, and its size limits apply to it. Other encodings are rejected with 415.
+func (f *Filters) DecodeContent() *Filters {
+	next := f.next
+	f.next = func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if encoding == "" || encoding == identityEncoding {
+			next(w, r)
+			return
+		}
+
+		body, err := decodeBody(encoding, r.Body)
+		if err != nil {
+			defer r.Body.Close()
+
+			status := http.StatusBadRequest
+			if encoding != gzipEncoding && encoding != zstdEncoding {
+				status = http.StatusUnsupportedMediaType
+			}
+
+			msg := fmt.Sprintf("Decoding the request body failed: %v", err)
+			logger.WithTransactionID(obtainTxID(r)).Warn(msg)
+			writeMessage(w, msg, status)
+			return
+		}
+
+		r.Body = body
+		r.ContentLength = -1
+		r.Header.Del("Content-Encoding")
+		next(w, r)
+	}
+	return f
+}
+
+// acceptedEncoding returns the supported content coding the Accept-Encoding header gives the highest weight to, or identity if
+// it accepts none of them
+func acceptedEncoding(acceptEncoding string) string {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+
+		weight := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					weight = q
+				}
+			}
+		}
+		weights[coding] = weight
+	}
+
+	best, bestWeight := identityEncoding, 0.0
+	for _, encoding := range encodings {
+		weight, found := weights[encoding]
+		if !found {
+			weight, found = weights["*"]
+		}
+
+		if found && weight > bestWeight {
+			best, bestWeight = encoding, weight
+		}
+	}
+	return best
+}
+
+type flusher interface {
+	Flush() error
+}
+
+func newEncoder(encoding string, w io.Writer) (io.WriteCloser, error) {
+	if encoding == zstdEncoding {
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return gzip.NewWriter(w), nil
+}
+
+// encodingWriter compresses the response once it is over the threshold, holding it back until then. Smaller responses are
+// written as they are, as compressing them would not save much, if anything.
+type encodingWriter struct {
+	http.ResponseWriter
+	encoding  string
+	threshold int
+	tid       string
+
+	status  int
+	held    []byte
+	decided bool
+	encoder io.WriteCloser
+}
+
+func (e *encodingWriter) WriteHeader(status int) {
+	if e.status == 0 {
+		e.status = status
+	}
+}
+
+func (e *encodingWriter) Write(p []byte) (int, error) {
+	if e.status == 0 {
+		e.status = http.StatusOK
+	}
+
+	if e.decided {
+		if e.encoder != nil {
+			return e.encoder.Write(p)
+		}
+		return e.ResponseWriter.Write(p)
+	}
+
+	e.held = append(e.held, p...)
+	if len(e.held) >= e.threshold {
+		if err := e.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// start writes the response held back, compressed or not
+func (e *encodingWriter) start(compress bool) error {
+	e.decided = true
+
+	if compress && e.Header().Get("Content-Encoding") == "" {
+		encoder, err := newEncoder(e.encoding, e.ResponseWriter)
+		if err != nil {
+			return err
+		}
+
+		e.encoder = encoder
+		e.Header().Set("Content-Encoding", e.encoding)
+		e.Header().Del("Content-Length")
+	}
+
+	e.ResponseWriter.WriteHeader(e.status)
+
+	held := e.held
+	e.held = nil
+	if len(held) == 0 {
+		return nil
+	}
+
+	var err error
+	if e.encoder != nil {
+		_, err = e.encoder.Write(held)
+	} else {
+		_, err = e.ResponseWriter.Write(held)
+	}
+	return err
+}
+
+// Flush only flushes once the response is over the threshold, as whether to compress it isn't known until then
+func (e *encodingWriter) Flush() {
+	if !e.decided {
+		return
+	}
+
+	if f, ok := e.encoder.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			logger.WithTransactionID(e.tid).WithError(err).Error("could not flush the compressed response")
+		}
+	}
+
+	if f, ok := e.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close writes the rest of the response
+func (e *encodingWriter) close() {
+	var err error
+	if !e.decided && e.status != 0 {
+		err = e.start(false)
+	}
+
+	if err == nil && e.encoder != nil {
+		err = e.encoder.Close()
+	}
+
+	if err != nil {
+		logger.WithTransactionID(e.tid).WithError(err).Error("could not write the compressed response")
+	}
+}
+
+// EncodeContent compresses the responses over the configured threshold with the gzip or zstd content coding, whichever the
+// Accept-Encoding header of the request prefers, falling back to zstd when it accepts both equally
+func (f *Filters) EncodeContent(conf *config.Configuration) *Filters {
+	next := f.next
+	f.next = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == identityEncoding || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		ew := &encodingWriter{ResponseWriter: w, encoding: encoding, threshold: int(conf.CompressionSizeThreshold()), tid: obtainTxID(r)}
+		defer ew.close()
+
+		next(ew, r)
+	}
+	return f
+}
@@ -0,0 +1,193 @@
+package resources
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/config"
+)
+
+func compress(t *testing.T, encoding string, data string) []byte {
+	buf := &bytes.Buffer{}
+	encoder, err := newEncoder(encoding, buf)
+	require.NoError(t, err)
+
+	_, err = encoder.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, encoder.Close())
+	return buf.Bytes()
+}
+
+func decompress(t *testing.T, encoding string, data []byte) string {
+	var r io.Reader
+	switch encoding {
+	case gzipEncoding:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		r = gr
+	case zstdEncoding:
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		defer zr.Close()
+		r = zr
+	default:
+		return string(data)
+	}
+
+	decoded, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	return string(decoded)
+}
+
+func TestAcceptedEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                             identityEncoding,
+		"identity":                     identityEncoding,
+		"br":                           identityEncoding,
+		"gzip":                         gzipEncoding,
+		"GZIP, deflate":                gzipEncoding,
+		"zstd":                         zstdEncoding,
+		"gzip, zstd":                   zstdEncoding,
+		"gzip;q=1.0, zstd;q=0.5":       gzipEncoding,
+		"zstd;q=0, gzip":               gzipEncoding,
+		"*":                            zstdEncoding,
+		"*;q=0.5, gzip":                gzipEncoding,
+		"gzip;q=0, zstd;q=0":           identityEncoding,
+		"deflate, gzip;q=not a number": gzipEncoding,
+	}
+
+	for acceptEncoding, expected := range tests {
+		assert.Equal(t, expected, acceptedEncoding(acceptEncoding), acceptEncoding)
+	}
+}
+
+func TestDecodeContent(t *testing.T) {
+	conf, err := config.ReadConfigFromReader(strings.NewReader(`{"policies": {"methode": {"maxBodySize": 64}}}`))
+	require.NoError(t, err)
+
+	var received string
+	next := func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if v, ok := bodyViolation(err); ok {
+			writeMessage(w, v.msg, v.status)
+			return
+		}
+		received = string(body)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}", Filter(next).EnforcePolicy(conf).DecodeContent().Build()).Methods("PUT")
+
+	content := `{"title":"Title"}`
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		status   int
+		received string
+	}{
+		{"gzip", "gzip", compress(t, gzipEncoding, content), http.StatusOK, content},
+		{"zstd", "zstd", compress(t, zstdEncoding, content), http.StatusOK, content},
+		{"identity", "identity", []byte(content), http.StatusOK, content},
+		{"none", "", []byte(content), http.StatusOK, content},
+		{"unsupported", "br", []byte(content), http.StatusUnsupportedMediaType, ""},
+		{"invalid gzip", "gzip", []byte(content), http.StatusBadRequest, ""},
+		{"decoded over the limit", "gzip", compress(t, gzipEncoding, strings.Repeat("a", 100)), http.StatusRequestEntityTooLarge, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			received = ""
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PUT", "/methode/9694733e-163a-4393-801f-000ab7de5041", bytes.NewReader(test.body))
+			if test.encoding != "" {
+				req.Header.Set("Content-Encoding", test.encoding)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.status, w.Code)
+			assert.Equal(t, test.received, received)
+		})
+	}
+}
+
+func TestEncodeContent(t *testing.T) {
+	conf, err := config.ReadConfigFromReader(strings.NewReader(`{"compressionThreshold": 64}`))
+	require.NoError(t, err)
+
+	large := strings.Repeat(`{"id":"9694733e-163a-4393-801f-000ab7de5041"}`+"\n", 10)
+	small := `{"id":"9694733e-163a-4393-801f-000ab7de5041"}`
+
+	respond := func(body string) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", "1000")
+			// write line by line, flushing like the streaming endpoints do
+			for _, line := range strings.SplitAfter(body, "\n") {
+				_, _ = w.Write([]byte(line))
+				w.(http.Flusher).Flush()
+			}
+		}
+	}
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		body           string
+		encoding       string
+	}{
+		{"gzip", "gzip", large, gzipEncoding},
+		{"zstd", "gzip, zstd", large, zstdEncoding},
+		{"not accepted", "br", large, ""},
+		{"under the threshold", "gzip", small, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/methode/__ids", http.NoBody)
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+
+			Filter(respond(test.body)).EncodeContent(conf).Build()(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+			assert.Equal(t, test.encoding, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, test.body, decompress(t, test.encoding, w.Body.Bytes()))
+			if test.encoding != "" {
+				assert.Empty(t, w.Header().Get("Content-Length"), "the length of the compressed response isn't known")
+			}
+		})
+	}
+}
+
+func TestEncodeContentKeepsStatus(t *testing.T) {
+	conf := &config.Configuration{}
+
+	for _, status := range []int{http.StatusNotModified, http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/methode/9694733e-163a-4393-801f-000ab7de5041", http.NoBody)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		Filter(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}).EncodeContent(conf).Build()(w, req)
+
+		assert.Equal(t, status, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Empty(t, w.Body.String())
+	}
+}
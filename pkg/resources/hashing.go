@@ -12,8 +12,11 @@ import (
 
 	"github.com/Financial-Times/go-logger"
 	"github.com/Financial-Times/nativerw/pkg/db"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
 )
 
+const nativeHashHeader = "X-Native-Hash"
+
 // Hash hashes the given payload in SHA224 + Hex
 func Hash(payload string) string {
 	hash := sha256.New224()
@@ -37,7 +40,7 @@ func (f *Filters) CheckNativeHash(mongo db.DB) *Filters {
 			return
 		}
 
-		nativeHash := r.Header.Get("X-Native-Hash")
+		nativeHash := r.Header.Get(nativeHashHeader)
 
 		if strings.TrimSpace(nativeHash) != "" {
 			defer r.Body.Close()
@@ -70,7 +73,7 @@ func (f *Filters) CheckNativeHash(mongo db.DB) *Filters {
 }
 
 func checkNativeHash(mongo db.Connection, hash string, collection string, id string) (bool, error) {
-	resource, found, err := mongo.Read(collection, id)
+	existingHash, found, err := mongo.ReadHash(collection, id)
 	if err != nil {
 		return false, err
 	}
@@ -81,11 +84,44 @@ func checkNativeHash(mongo db.Connection, hash string, collection string, id str
 		return false, nil // no native document for this id, so save it
 	}
 
-	data, err := json.Marshal(resource.Content)
-	if err != nil {
-		return false, err
-	}
-
-	existingHash := Hash(string(data))
 	return existingHash == hash, nil
 }
+
+// ComputeHash responds with the hash of the native content in the request body, computed from its canonical json form exactly
+// as the native store computes it, so that publishers can send it as X-Native-Hash
+func ComputeHash() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		tid := obtainTxID(r)
+		contentTypeHeader := extractAttrFromHeader(r, "Content-Type", "application/octet-stream", tid, "")
+
+		inMapper, err := mapper.InMapperForContentType(contentTypeHeader)
+		if err != nil {
+			writeMessage(w, fmt.Sprintf("Unsupported content-type %v", contentTypeHeader), http.StatusBadRequest)
+			return
+		}
+
+		content, err := inMapper(r.Body)
+		if err != nil {
+			writeMessage(w, fmt.Sprintf("Extracting content from HTTP body failed: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		hash, err := mapper.ContentHash(content)
+		if err != nil {
+			writeMessage(w, fmt.Sprintf("Hashing the content failed: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		data, _ := json.Marshal(struct {
+			Hash string `json:"hash"`
+		}{hash})
+
+		w.Header().Add("Content-Type", "application/json")
+		w.Header().Set(nativeHashHeader, hash)
+		if _, err = w.Write(data); err != nil {
+			logger.WithTransactionID(tid).WithError(err).Error("could not write the hash response")
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package resources
 
 import (
-	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -31,20 +30,11 @@ func TestHashCheckMatches(t *testing.T) {
 		passed = true
 	}
 
-	content := make(map[string]interface{})
-	json.Unmarshal([]byte(exampleNativePayload), &content)
-
-	expectedResource := &mapper.Resource{
-		UUID:        "a-real-uuid",
-		Content:     content,
-		ContentType: "application/json",
-	}
-
 	mongo := new(MockDB)
 	connection := new(MockConnection)
 
 	mongo.On("Open").Return(connection, nil)
-	connection.On("Read", "methode", "a-real-uuid").Return(expectedResource, true, nil)
+	connection.On("ReadHash", "methode", "a-real-uuid").Return("e6e2ddb24efd029a44b7c2117d172c2e89e88992eb453b3807b693c4", true, nil)
 
 	router := mux.NewRouter()
 	router.HandleFunc("/{collection}/{resource}", Filter(next).CheckNativeHash(mongo).Build()).Methods("PUT")
@@ -69,20 +59,11 @@ func TestHashCheckDoesntMatch(t *testing.T) {
 		passed = true
 	}
 
-	content := make(map[string]interface{})
-	json.Unmarshal([]byte(exampleNativePayload), &content)
-
-	expectedResource := &mapper.Resource{
-		UUID:        "a-real-uuid",
-		Content:     content,
-		ContentType: "application/json",
-	}
-
 	mongo := new(MockDB)
 	connection := new(MockConnection)
 
 	mongo.On("Open").Return(connection, nil)
-	connection.On("Read", "methode", "a-real-uuid").Return(expectedResource, true, nil)
+	connection.On("ReadHash", "methode", "a-real-uuid").Return("e6e2ddb24efd029a44b7c2117d172c2e89e88992eb453b3807b693c4", true, nil)
 
 	router := mux.NewRouter()
 	router.HandleFunc("/{collection}/{resource}", Filter(next).CheckNativeHash(mongo).Build()).Methods("PUT")
@@ -111,7 +92,7 @@ func TestNoContentFound(t *testing.T) {
 	connection := new(MockConnection)
 
 	mongo.On("Open").Return(connection, nil)
-	connection.On("Read", "methode", "a-real-uuid").Return(&mapper.Resource{}, false, nil)
+	connection.On("ReadHash", "methode", "a-real-uuid").Return("", false, nil)
 
 	router := mux.NewRouter()
 	router.HandleFunc("/{collection}/{resource}", Filter(next).CheckNativeHash(mongo).Build()).Methods("PUT")
@@ -140,7 +121,7 @@ func TestHashCheckContentReadFails(t *testing.T) {
 	connection := new(MockConnection)
 
 	mongo.On("Open").Return(connection, nil)
-	connection.On("Read", "methode", "a-real-uuid").Return(&mapper.Resource{}, false, errors.New("i failed"))
+	connection.On("ReadHash", "methode", "a-real-uuid").Return("", false, errors.New("i failed"))
 
 	router := mux.NewRouter()
 	router.HandleFunc("/{collection}/{resource}", Filter(next).CheckNativeHash(mongo).Build()).Methods("PUT")
@@ -165,17 +146,11 @@ func TestHashCheckJsonMarshalFails(t *testing.T) {
 		passed = true
 	}
 
-	expectedResource := &mapper.Resource{
-		UUID:        "a-real-uuid",
-		Content:     func() {},
-		ContentType: "application/json",
-	}
-
 	mongo := new(MockDB)
 	connection := new(MockConnection)
 
 	mongo.On("Open").Return(connection, nil)
-	connection.On("Read", "methode", "a-real-uuid").Return(expectedResource, true, nil)
+	connection.On("ReadHash", "methode", "a-real-uuid").Return("", false, errors.New("json: unsupported type: func()"))
 
 	router := mux.NewRouter()
 	router.HandleFunc("/{collection}/{resource}", Filter(next).CheckNativeHash(mongo).Build()).Methods("PUT")
@@ -242,3 +217,48 @@ func TestFailedMongoDuringHashFilter(t *testing.T) {
 	mock.AssertExpectationsForObjects(t, mongo, body)
 	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
 }
+
+func TestComputeHash(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/__hash", ComputeHash()).Methods("GET", "POST")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/__hash", strings.NewReader(`{ "title": "<b>Title</b>", "count": 1.0 }`))
+	req.Header.Add("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "bc5a3e9ad4ac5e4cebc34f5d952a18fcb71f3d258106893ed020bcdb", w.Header().Get("X-Native-Hash"))
+	assert.JSONEq(t, `{"hash":"bc5a3e9ad4ac5e4cebc34f5d952a18fcb71f3d258106893ed020bcdb"}`, w.Body.String())
+}
+
+func TestComputeHashOfBinaryContent(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/__hash", ComputeHash()).Methods("GET", "POST")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/__hash", strings.NewReader(`hi`))
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "60c0782a8fe3339f893a98dd378e8e4a154b583ece04372da0daed2d", w.Header().Get("X-Native-Hash"))
+}
+
+func TestComputeHashOfInvalidContent(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/__hash", ComputeHash()).Methods("GET", "POST")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/__hash", strings.NewReader(`i am not a json`))
+	req.Header.Add("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/__hash", strings.NewReader(`<xml/>`))
+	req.Header.Add("Content-Type", "text/xml")
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -57,7 +57,7 @@ func checkWritable(mongo db.DB) func() (string, error) {
 			return "Failed to establish connection to MongoDB", err
 		}
 
-		err = connection.Write(healthCheckColl, sampleResource)
+		err = connection.Write(healthCheckColl, sampleResource, db.Precondition{}, nil)
 		if err != nil {
 			return "Failed to write data to MongoDB, please check the connection.", err
 		}
@@ -11,6 +11,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/Financial-Times/nativerw/pkg/db"
 	status "github.com/Financial-Times/service-status-go/httphandlers"
 )
 
@@ -19,7 +20,7 @@ func TestHealthchecks(t *testing.T) {
 	connection := new(MockConnection)
 
 	mongo.On("Open").Return(connection, nil)
-	connection.On("Write", healthCheckColl, sampleResource).Return(nil)
+	connection.On("Write", healthCheckColl, sampleResource, db.Precondition{}, noValidator).Return(nil)
 	connection.On("Read", healthCheckColl, sampleUUID).Return(sampleResource, true, nil)
 
 	router := mux.NewRouter()
@@ -38,7 +39,7 @@ func TestHealthchecksFail(t *testing.T) {
 	connection := new(MockConnection)
 
 	mongo.On("Open").Return(connection, nil)
-	connection.On("Write", healthCheckColl, sampleResource).Return(errors.New("no writes 4 u"))
+	connection.On("Write", healthCheckColl, sampleResource, db.Precondition{}, noValidator).Return(errors.New("no writes 4 u"))
 	connection.On("Read", healthCheckColl, sampleUUID).Return(sampleResource, true, errors.New("no reads 4 u"))
 
 	router := mux.NewRouter()
@@ -83,7 +84,7 @@ func TestGTG(t *testing.T) {
 	connection := new(MockConnection)
 
 	mongo.On("Open").Return(connection, nil)
-	connection.On("Write", healthCheckColl, sampleResource).Return(nil)
+	connection.On("Write", healthCheckColl, sampleResource, db.Precondition{}, noValidator).Return(nil)
 	connection.On("Read", healthCheckColl, sampleUUID).Return(sampleResource, true, nil)
 
 	router := mux.NewRouter()
@@ -105,7 +106,7 @@ func TestGTGFailsOnRead(t *testing.T) {
 
 	mongo.On("Open").Return(connection, nil)
 	connection.On("Read", healthCheckColl, sampleUUID).Return(sampleResource, true, errors.New("no reads 4 u"))
-	connection.On("Write", healthCheckColl, sampleResource).Return(nil)
+	connection.On("Write", healthCheckColl, sampleResource, db.Precondition{}, noValidator).Return(nil)
 
 	router := mux.NewRouter()
 	router.HandleFunc("/__gtg", status.NewGoodToGoHandler(GoodToGo(mongo))).Methods("GET")
@@ -125,7 +126,7 @@ func TestGTGFailsOnWrite(t *testing.T) {
 	connection := new(MockConnection)
 
 	mongo.On("Open").Return(connection, nil)
-	connection.On("Write", healthCheckColl, sampleResource).Return(errors.New("no writes 4 u"))
+	connection.On("Write", healthCheckColl, sampleResource, db.Precondition{}, noValidator).Return(errors.New("no writes 4 u"))
 	connection.On("Read", healthCheckColl, sampleUUID).Return(sampleResource, true, nil)
 
 	router := mux.NewRouter()
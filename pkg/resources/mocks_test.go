@@ -2,6 +2,7 @@ package resources
 
 import (
 	"context"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 
@@ -9,6 +10,11 @@ import (
 	"github.com/Financial-Times/nativerw/pkg/mapper"
 )
 
+const testTxID = "tid_testing"
+
+// noValidator matches the writes which don't validate their content, as testify can't compare functions
+var noValidator = mock.MatchedBy(func(validator db.Validator) bool { return validator == nil })
+
 type MockConnection struct {
 	mock.Mock
 	CallArgs []interface{}
@@ -42,27 +48,157 @@ func (m *MockConnection) GetSupportedCollections() map[string]bool {
 	return args.Get(0).(map[string]bool)
 }
 
+func (m *MockConnection) ReadCollections() ([]*db.Collection, error) {
+	args := m.Called()
+	return args.Get(0).([]*db.Collection), args.Error(1)
+}
+
+func (m *MockConnection) RegisterCollection(name string) (*db.Collection, bool, error) {
+	args := m.Called(name)
+	return args.Get(0).(*db.Collection), args.Bool(1), args.Error(2)
+}
+
+func (m *MockConnection) UnregisterCollection(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+
 func (m *MockConnection) Close() {
 	m.Called()
 }
 
-func (m *MockConnection) Delete(collection string, uuidString string) error {
-	args := m.Called(collection, uuidString)
+func (m *MockConnection) Delete(collection string, uuidString string, tid string, precondition db.Precondition) error {
+	args := m.Called(collection, uuidString, tid, precondition)
+	return args.Error(0)
+}
+
+func (m *MockConnection) Restore(collection string, uuidString string, tid string) error {
+	args := m.Called(collection, uuidString, tid)
 	return args.Error(0)
 }
 
-func (m *MockConnection) ReadIDs(ctx context.Context, collection string) (chan string, error) {
-	args := m.Called(ctx, collection)
-	m.CallArgs = []interface{}{ctx, collection}
-	return args.Get(0).(chan string), args.Error(1)
+func (m *MockConnection) PurgeTombstones(collection string, deletedBefore time.Time) (int64, error) {
+	args := m.Called(collection, deletedBefore)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockConnection) PurgeBlobs(collection string, uploadedBefore time.Time) (int64, error) {
+	args := m.Called(collection, uploadedBefore)
+	return args.Get(0).(int64), args.Error(1)
 }
 
-func (m *MockConnection) Write(collection string, resource *mapper.Resource) error {
-	args := m.Called(collection, resource)
+func (m *MockConnection) ReadIDs(ctx context.Context, collection string, query db.IDsQuery) (chan *db.ID, error) {
+	args := m.Called(ctx, collection, query)
+	m.CallArgs = []interface{}{ctx, collection, query}
+	return args.Get(0).(chan *db.ID), args.Error(1)
+}
+
+func (m *MockConnection) Patch(collection string, resource *mapper.Resource, precondition db.Precondition, validator db.Validator) (*mapper.Resource, error) {
+	args := m.Called(collection, resource, precondition, validator)
+	return args.Get(0).(*mapper.Resource), args.Error(1)
+}
+
+func (m *MockConnection) ReadHash(collection string, uuidString string) (string, bool, error) {
+	args := m.Called(collection, uuidString)
+	return args.String(0), args.Bool(1), args.Error(2)
+}
+
+func (m *MockConnection) BackfillHashes(ctx context.Context, collection string) (int64, error) {
+	args := m.Called(collection)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockConnection) ReencodeContent(ctx context.Context, collection string) (int64, error) {
+	args := m.Called(collection)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockConnection) Write(collection string, resource *mapper.Resource, precondition db.Precondition, validator db.Validator) error {
+	args := m.Called(collection, resource, precondition, validator)
 	return args.Error(0)
 }
 
+func (m *MockConnection) BulkWrite(collection string, resources []*mapper.Resource) ([]bool, error) {
+	args := m.Called(collection, resources)
+	return args.Get(0).([]bool), args.Error(1)
+}
+
+func (m *MockConnection) ReadMany(collection string, uuids []string) ([]*mapper.Resource, error) {
+	args := m.Called(collection, uuids)
+	return args.Get(0).([]*mapper.Resource), args.Error(1)
+}
+
+func (m *MockConnection) Stats(collection string) (*db.Stats, error) {
+	args := m.Called(collection)
+	return args.Get(0).(*db.Stats), args.Error(1)
+}
+
 func (m *MockConnection) Read(collection string, uuidString string) (res *mapper.Resource, found bool, err error) {
 	args := m.Called(collection, uuidString)
 	return args.Get(0).(*mapper.Resource), args.Bool(1), args.Error(2)
 }
+
+func (m *MockConnection) ReadVersions(collection string, uuidString string) ([]*db.Version, error) {
+	args := m.Called(collection, uuidString)
+	return args.Get(0).([]*db.Version), args.Error(1)
+}
+
+func (m *MockConnection) ReadVersion(collection string, uuidString string, version int64) (res *mapper.Resource, found bool, err error) {
+	args := m.Called(collection, uuidString, version)
+	return args.Get(0).(*mapper.Resource), args.Bool(1), args.Error(2)
+}
+
+func (m *MockConnection) ReadChanges(ctx context.Context, collection string, since int64) (chan *db.Change, error) {
+	args := m.Called(ctx, collection, since)
+	return args.Get(0).(chan *db.Change), args.Error(1)
+}
+
+func (m *MockConnection) LastSequence(collection string) (int64, error) {
+	args := m.Called(collection)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockConnection) PurgeChanges(collection string, before time.Time) (int64, error) {
+	args := m.Called(collection, before)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockConnection) ClaimOutbox(name string, owner string, from int64, until time.Time) (int64, error) {
+	args := m.Called(name, owner, from, until)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockConnection) AdvanceOutbox(name string, owner string, sequence int64) error {
+	args := m.Called(name, owner, sequence)
+	return args.Error(0)
+}
+
+func (m *MockConnection) CreateSubscription(subscription *db.Subscription) error {
+	args := m.Called(subscription)
+	return args.Error(0)
+}
+
+func (m *MockConnection) ReadSubscriptions() ([]*db.Subscription, error) {
+	args := m.Called()
+	return args.Get(0).([]*db.Subscription), args.Error(1)
+}
+
+func (m *MockConnection) ReadSubscription(id string) (*db.Subscription, bool, error) {
+	args := m.Called(id)
+	return args.Get(0).(*db.Subscription), args.Bool(1), args.Error(2)
+}
+
+func (m *MockConnection) DeleteSubscription(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockConnection) RecordDelivery(delivery *db.Delivery) error {
+	args := m.Called(delivery)
+	return args.Error(0)
+}
+
+func (m *MockConnection) ReadDeliveries(id string, status string) ([]*db.Delivery, error) {
+	args := m.Called(id, status)
+	return args.Get(0).([]*db.Delivery), args.Error(1)
+}
@@ -0,0 +1,130 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/config"
+)
+
+type policyKey struct{}
+
+// policyViolation is a write the policy of its collection doesn't accept, and the status to reject it with
+type policyViolation struct {
+	status int
+	msg    string
+}
+
+func (v *policyViolation) Error() string {
+	return v.msg
+}
+
+// checkPolicy returns why the policy doesn't accept a document with the given uuid, content type and origin system, if it doesn't
+func checkPolicy(policy *config.Policy, collection, id, contentType, originSystemID string) *policyViolation {
+	if !policy.AllowsID(id) {
+		return &policyViolation{http.StatusBadRequest, fmt.Sprintf("The uuid %s doesn't match the id format of collection %s", id, collection)}
+	}
+
+	if !policy.AllowsContentType(contentType) {
+		return &policyViolation{http.StatusUnsupportedMediaType, fmt.Sprintf("Collection %s doesn't accept content-type %q", collection, contentType)}
+	}
+
+	if !policy.AllowsOriginSystemID(originSystemID) {
+		return &policyViolation{http.StatusForbidden, fmt.Sprintf("Collection %s doesn't accept writes from origin-system-id %q", collection, originSystemID)}
+	}
+	return nil
+}
+
+// policyFromContext returns the policy the request has been checked against, or the zero one which accepts any write
+func policyFromContext(ctx context.Context) *config.Policy {
+	if policy, ok := ctx.Value(policyKey{}).(*config.Policy); ok {
+		return policy
+	}
+	return &config.Policy{}
+}
+
+// limitedBody fails with the violation of the body size limit once the body is read past it
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	violation *policyViolation
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, b.violation
+	}
+
+	// one byte past the limit is read, to tell whether the body is over it
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return 0, b.violation
+	}
+	return n, err
+}
+
+// bodyViolation returns the violation of the body size limit the error is, if it is one
+func bodyViolation(err error) (*policyViolation, bool) {
+	v, ok := err.(*policyViolation)
+	return v, ok
+}
+
+// EnforcePolicy rejects the writes to a read-only collection, and the documents its policy doesn't accept: with 403 for an origin
+// system which can't write, 413 for a body over the max size of the collection or the global one, 415 for an unsupported content
+// type, and 400 for a uuid which doesn't match the id format. The handlers of writes of many documents check each of them against
+// the policy passed on in the context.
+func (f *Filters) EnforcePolicy(conf *config.Configuration) *Filters {
+	next := f.next
+	f.next = func(w http.ResponseWriter, r *http.Request) {
+		collection := mux.Vars(r)["collection"]
+		resourceID, single := mux.Vars(r)["resource"]
+		policy := conf.Policy(collection)
+
+		reject := func(v *policyViolation) {
+			defer r.Body.Close()
+			logger.WithTransactionID(obtainTxID(r)).WithUUID(resourceID).Warn(v.msg)
+			writeMessage(w, v.msg, v.status)
+		}
+
+		if policy.ReadOnly && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			reject(&policyViolation{http.StatusForbidden, fmt.Sprintf("Collection %s is read-only", collection)})
+			return
+		}
+
+		if single && (r.Method == http.MethodPut || r.Method == http.MethodPatch) {
+			contentType := r.Header.Get("Content-Type")
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+
+			if v := checkPolicy(policy, collection, resourceID, contentType, r.Header.Get("Origin-System-Id")); v != nil {
+				reject(v)
+				return
+			}
+
+			if limit := policy.BodySizeLimit(); limit > 0 {
+				tooLarge := &policyViolation{http.StatusRequestEntityTooLarge, fmt.Sprintf("Collection %s accepts documents of up to %d bytes", collection, limit)}
+				if r.ContentLength > limit {
+					reject(tooLarge)
+					return
+				}
+
+				// bodies of unknown length are only found to be too large as they are read, by the handler
+				r.Body = &limitedBody{ReadCloser: r.Body, remaining: limit, violation: tooLarge}
+			}
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), policyKey{}, policy)))
+	}
+	return f
+}
@@ -0,0 +1,197 @@
+package resources
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/config"
+	"github.com/Financial-Times/nativerw/pkg/db"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func testPolicies(t *testing.T) *config.Configuration {
+	conf, err := config.ReadConfigFromReader(strings.NewReader(`{
+		"policies": {
+			"methode": {
+				"contentTypes": ["application/json"],
+				"maxBodySize": 32,
+				"originSystemIds": ["methode-web-pub"],
+				"idFormat": "^[a-f0-9]{8}-[a-f0-9]{4}-4"
+			},
+			"v1-metadata": {"readOnly": true}
+		}
+	}`))
+	require.NoError(t, err)
+	return conf
+}
+
+func TestEnforcePolicy(t *testing.T) {
+	var forwarded string
+	next := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		forwarded = string(body)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}", Filter(next).EnforcePolicy(testPolicies(t)).Build()).Methods("GET", "PUT", "PATCH", "DELETE")
+
+	tests := []struct {
+		name           string
+		method         string
+		collection     string
+		id             string
+		contentType    string
+		originSystemID string
+		body           string
+		status         int
+	}{
+		{"accepted", "PUT", "methode", "9694733e-163a-4393-801f-000ab7de5041", "application/json; charset=utf-8", "methode-web-pub", `{"title":"Title"}`, http.StatusOK},
+		{"patch accepted", "PATCH", "methode", "9694733e-163a-4393-801f-000ab7de5041", "application/json", "methode-web-pub", `{"title":"Title"}`, http.StatusOK},
+		{"id format", "PUT", "methode", "59b3a4d0-9a17-11e6-8dbb-4b02b9e5d5ff", "application/json", "methode-web-pub", `{}`, http.StatusBadRequest},
+		{"content type", "PUT", "methode", "9694733e-163a-4393-801f-000ab7de5041", "application/octet-stream", "methode-web-pub", `{}`, http.StatusUnsupportedMediaType},
+		{"missing content type", "PUT", "methode", "9694733e-163a-4393-801f-000ab7de5041", "", "methode-web-pub", `{}`, http.StatusUnsupportedMediaType},
+		{"origin system", "PUT", "methode", "9694733e-163a-4393-801f-000ab7de5041", "application/json", "wordpress", `{}`, http.StatusForbidden},
+		{"body size", "PATCH", "methode", "9694733e-163a-4393-801f-000ab7de5041", "application/json", "methode-web-pub", `{"title":"A title over the limit"}`, http.StatusRequestEntityTooLarge},
+		{"delete", "DELETE", "methode", "59b3a4d0-9a17-11e6-8dbb-4b02b9e5d5ff", "", "", "", http.StatusOK},
+		{"read only", "PUT", "v1-metadata", "9694733e-163a-4393-801f-000ab7de5041", "application/json", "", `{}`, http.StatusForbidden},
+		{"read only delete", "DELETE", "v1-metadata", "9694733e-163a-4393-801f-000ab7de5041", "", "", "", http.StatusForbidden},
+		{"read only read", "GET", "v1-metadata", "9694733e-163a-4393-801f-000ab7de5041", "", "", "", http.StatusOK},
+		{"no policy", "PUT", "video", "59b3a4d0-9a17-11e6-8dbb-4b02b9e5d5ff", "text/plain", "", "anything", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			forwarded = ""
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(test.method, "/"+test.collection+"/"+test.id, strings.NewReader(test.body))
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			if test.originSystemID != "" {
+				req.Header.Set("Origin-System-Id", test.originSystemID)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.status, w.Code)
+			if test.status == http.StatusOK {
+				assert.Equal(t, test.body, forwarded, "the body should be passed on whole")
+			} else {
+				assert.Empty(t, forwarded)
+				assert.Contains(t, w.Body.String(), `"message"`)
+			}
+		})
+	}
+}
+
+func TestWriteContentLimitsBodySize(t *testing.T) {
+	conf, err := config.ReadConfigFromReader(strings.NewReader(`{
+		"maxBodySize": 16,
+		"policies": {"methode": {"maxBodySize": 32}}
+	}`))
+	require.NoError(t, err)
+
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Write", "methode", mock.AnythingOfType("*mapper.Resource"), db.Precondition{}, noValidator).Return(nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}", Filter(WriteContent(mongo)).EnforcePolicy(conf).Build()).Methods("PUT")
+
+	tests := []struct {
+		name       string
+		collection string
+		body       string
+		chunked    bool
+		status     int
+	}{
+		{"under the policy limit", "methode", `{"title":"A longer title"}`, false, http.StatusOK},
+		{"over the policy limit", "methode", `{"title":"A title over the limit"}`, false, http.StatusRequestEntityTooLarge},
+		{"chunked over the policy limit", "methode", `{"title":"A title over the limit"}`, true, http.StatusRequestEntityTooLarge},
+		{"over the global limit", "video", `{"title":"A longer title"}`, false, http.StatusRequestEntityTooLarge},
+		{"chunked over the global limit", "video", `{"title":"A longer title"}`, true, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PUT", "/"+test.collection+"/9694733e-163a-4393-801f-000ab7de5041", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", "application/json")
+			if test.chunked {
+				req.ContentLength = -1
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.status, w.Code)
+			if test.status != http.StatusOK {
+				assert.Contains(t, w.Body.String(), "accepts documents of up to")
+			}
+		})
+	}
+}
+
+func TestLimitedBody(t *testing.T) {
+	violation := &policyViolation{http.StatusRequestEntityTooLarge, "too large"}
+
+	body := &limitedBody{ReadCloser: ioutil.NopCloser(strings.NewReader("0123456789")), remaining: 10, violation: violation}
+	data, err := ioutil.ReadAll(body)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data), "a body of the size of the limit should be read whole")
+
+	body = &limitedBody{ReadCloser: ioutil.NopCloser(strings.NewReader("0123456789")), remaining: 9, violation: violation}
+	_, err = ioutil.ReadAll(body)
+	assert.Equal(t, violation, err)
+}
+
+func TestBulkWriteEnforcesPolicy(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("GetSupportedCollections").Return(map[string]bool{"methode": true})
+	connection.On("BulkWrite", "methode", mock.MatchedBy(func(resources []*mapper.Resource) bool {
+		return len(resources) == 1 && resources[0].UUID == "9694733e-163a-4393-801f-000ab7de5041"
+	})).Run(func(args mock.Arguments) {
+		hashed(args.Get(1).([]*mapper.Resource))
+	}).Return([]bool{true}, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/__bulk", Filter(BulkWrite(mongo)).EnforcePolicy(testPolicies(t)).Build()).Methods("POST")
+
+	body := `{"uuid":"9694733e-163a-4393-801f-000ab7de5041","contentType":"application/json","originSystemId":"methode-web-pub","content":{}}
+{"uuid":"59b3a4d0-9a17-11e6-8dbb-4b02b9e5d5ff","contentType":"application/json","originSystemId":"methode-web-pub","content":{}}
+{"uuid":"8b17c8b6-c1c3-4c5e-8e4d-c2c3d4b0a0f1","contentType":"application/octet-stream","originSystemId":"methode-web-pub","content":"aGVsbG8="}
+{"uuid":"8b17c8b6-c1c3-4c5e-8e4d-c2c3d4b0a0f1","contentType":"application/json","originSystemId":"wordpress","content":{}}
+{"uuid":"8b17c8b6-c1c3-4c5e-8e4d-c2c3d4b0a0f1","contentType":"application/json","originSystemId":"methode-web-pub","content":{"title":"A title over the limit"}}`
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/__bulk", strings.NewReader(body))
+
+	router.ServeHTTP(w, req)
+
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"line":1,"uuid":"9694733e-163a-4393-801f-000ab7de5041","status":"ok","hash":"hash-of-9694733e"}
+{"line":2,"uuid":"59b3a4d0-9a17-11e6-8dbb-4b02b9e5d5ff","status":"invalid","error":"The uuid 59b3a4d0-9a17-11e6-8dbb-4b02b9e5d5ff doesn't match the id format of collection methode"}
+{"line":3,"uuid":"8b17c8b6-c1c3-4c5e-8e4d-c2c3d4b0a0f1","status":"invalid","error":"Collection methode doesn't accept content-type \"application/octet-stream\""}
+{"line":4,"uuid":"8b17c8b6-c1c3-4c5e-8e4d-c2c3d4b0a0f1","status":"invalid","error":"Collection methode doesn't accept writes from origin-system-id \"wordpress\""}
+{"line":5,"uuid":"8b17c8b6-c1c3-4c5e-8e4d-c2c3d4b0a0f1","status":"invalid","error":"content over the max size of collection methode, of 32 bytes"}`, strings.TrimSpace(w.Body.String()))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/v1-metadata/__bulk", strings.NewReader(body))
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,84 @@
+package resources
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Financial-Times/nativerw/pkg/db"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+// etag formats the revision of a native document as a strong entity tag
+func etag(revision int64) string {
+	return strconv.Quote(strconv.FormatInt(revision, 10))
+}
+
+// preconditionFromRequest maps the If-Match, If-None-Match and If-Unmodified-Since headers onto a db precondition. Entity tags
+// which were not issued by this service, weak ones included, never match. Only the wildcard form of If-None-Match is supported,
+// and If-Unmodified-Since is ignored when If-Match is sent, or is not a valid HTTP date.
+func preconditionFromRequest(r *http.Request) db.Precondition {
+	precondition := db.Precondition{}
+
+	if ifMatch := strings.TrimSpace(r.Header.Get("If-Match")); ifMatch == "*" {
+		precondition.IfMatchAny = true
+	} else if ifMatch != "" {
+		for _, tag := range strings.Split(ifMatch, ",") {
+			precondition.IfMatch = append(precondition.IfMatch, parseETag(strings.TrimSpace(tag)))
+		}
+	}
+
+	precondition.IfNoneMatchAny = strings.TrimSpace(r.Header.Get("If-None-Match")) == "*"
+
+	if r.Header.Get("If-Match") == "" {
+		precondition.IfUnmodifiedSince = headerTime(r, "If-Unmodified-Since")
+	}
+	return precondition
+}
+
+// headerTime parses the HTTP date of the given header, and returns the zero time if it is missing or invalid
+func headerTime(r *http.Request, header string) time.Time {
+	t, err := http.ParseTime(r.Header.Get(header))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// lastModified returns the modification time of the resource as an HTTP date, which has a precision of a second
+func lastModified(resource *mapper.Resource) time.Time {
+	return resource.LastModified.UTC().Truncate(time.Second)
+}
+
+// checkModified evaluates If-Unmodified-Since and If-Modified-Since for reads, and returns the status to respond with if
+// either fails, or zero. Resources written by previous releases have no modification time, and never fail either.
+func checkModified(r *http.Request, resource *mapper.Resource) int {
+	if resource.LastModified.IsZero() {
+		return 0
+	}
+
+	if since := headerTime(r, "If-Unmodified-Since"); !since.IsZero() && lastModified(resource).After(since) {
+		return http.StatusPreconditionFailed
+	}
+
+	if since := headerTime(r, "If-Modified-Since"); !since.IsZero() && !lastModified(resource).After(since) {
+		return http.StatusNotModified
+	}
+
+	return 0
+}
+
+// parseETag returns the revision of an entity tag issued by etag, or -1 which never matches any revision
+func parseETag(tag string) int64 {
+	unquoted, err := strconv.Unquote(tag)
+	if err != nil {
+		return -1
+	}
+
+	revision, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return revision
+}
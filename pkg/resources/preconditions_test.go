@@ -0,0 +1,57 @@
+package resources
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Financial-Times/nativerw/pkg/db"
+)
+
+func TestPreconditionFromRequest(t *testing.T) {
+	var tests = []struct {
+		ifMatch     string
+		ifNoneMatch string
+		expected    db.Precondition
+	}{
+		{"", "", db.Precondition{}},
+		{`"3"`, "", db.Precondition{IfMatch: []int64{3}}},
+		{`"3", "5"`, "", db.Precondition{IfMatch: []int64{3, 5}}},
+		{`W/"3", 3, "abc"`, "", db.Precondition{IfMatch: []int64{-1, -1, -1}}},
+		{"*", "", db.Precondition{IfMatchAny: true}},
+		{"", "*", db.Precondition{IfNoneMatchAny: true}},
+		{"", `"3"`, db.Precondition{}},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("PUT", "/methode/a-real-uuid", http.NoBody)
+		if test.ifMatch != "" {
+			req.Header.Add("If-Match", test.ifMatch)
+		}
+		if test.ifNoneMatch != "" {
+			req.Header.Add("If-None-Match", test.ifNoneMatch)
+		}
+
+		assert.Equal(t, test.expected, preconditionFromRequest(req), "If-Match: %s, If-None-Match: %s", test.ifMatch, test.ifNoneMatch)
+	}
+}
+
+func TestPreconditionFromRequestIfUnmodifiedSince(t *testing.T) {
+	req, _ := http.NewRequest("PUT", "/methode/a-real-uuid", http.NoBody)
+	req.Header.Add("If-Unmodified-Since", "Wed, 04 Mar 2020 10:30:15 GMT")
+	assert.Equal(t, db.Precondition{IfUnmodifiedSince: time.Date(2020, 3, 4, 10, 30, 15, 0, time.UTC)}, preconditionFromRequest(req))
+
+	req.Header.Set("If-Unmodified-Since", "not a date")
+	assert.Equal(t, db.Precondition{}, preconditionFromRequest(req))
+
+	req.Header.Set("If-Unmodified-Since", "Wed, 04 Mar 2020 10:30:15 GMT")
+	req.Header.Add("If-Match", `"3"`)
+	assert.Equal(t, db.Precondition{IfMatch: []int64{3}}, preconditionFromRequest(req))
+}
+
+func TestETag(t *testing.T) {
+	assert.Equal(t, `"42"`, etag(42))
+	assert.Equal(t, int64(42), parseETag(etag(42)))
+}
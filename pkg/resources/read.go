@@ -6,6 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -50,29 +53,118 @@ func ReadContent(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		contentTypeHeader := resource.ContentType
-		w.Header().Add("Content-Type", contentTypeHeader)
-		w.Header().Add("Origin-System-Id", resource.OriginSystemID)
+		switch checkModified(r, resource) {
+		case http.StatusNotModified:
+			setResourceHeaders(w, resource, tid, resourceID)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case http.StatusPreconditionFailed:
+			writeMessage(w, "Precondition failed, the resource has been modified in the meantime", http.StatusPreconditionFailed)
+			return
+		}
 
-		om, err := mapper.OutMapperForContentType(contentTypeHeader)
-		if err != nil {
-			msg := fmt.Sprintf("Unable to handle resource of type %T", resource)
-			logger.WithError(err).WithTransactionID(tid).WithUUID(resourceID).Warn(msg)
-			http.Error(w, msg, http.StatusNotImplemented)
+		if r.Method == http.MethodHead {
+			setResourceHeaders(w, resource, tid, resourceID)
 			return
 		}
 
-		err = om(w, resource)
-		if err != nil {
-			msg := fmt.Sprintf("Unable to extract native content from resource with id %v. %v", resourceID, err.Error())
-			logger.WithTransactionID(tid).WithUUID(resourceID).WithError(err).Errorf(msg)
-			http.Error(w, msg, http.StatusInternalServerError)
-		} else {
-			logger.WithTransactionID(tid).WithUUID(resourceID).Info("Read native content successfully")
+		writeResource(w, resource, tid, resourceID)
+	}
+}
+
+// setNativeHash sets the X-Native-Hash header, computing the hash if the resource has been stored without one
+func setNativeHash(w http.ResponseWriter, resource *mapper.Resource, tid string, resourceID string) {
+	hash := resource.Hash
+	if hash == "" {
+		var err error
+		if hash, err = mapper.ContentHash(resource.Content); err != nil {
+			logger.WithTransactionID(tid).WithUUID(resourceID).WithError(err).Warn("Failed to hash native content")
+			return
 		}
 	}
+
+	w.Header().Set(nativeHashHeader, hash)
+}
+
+// setResourceHeaders sets the headers describing the given resource, without its content
+func setResourceHeaders(w http.ResponseWriter, resource *mapper.Resource, tid string, resourceID string) {
+	w.Header().Add("Content-Type", resource.ContentType)
+	w.Header().Add("Origin-System-Id", resource.OriginSystemID)
+	w.Header().Set("ETag", etag(resource.Revision))
+	if !resource.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified(resource).Format(http.TimeFormat))
+	}
+	setNativeHash(w, resource, tid, resourceID)
+}
+
+// writeResource responds with the native content of the given resource, mapped back from its stored content type
+func writeResource(w http.ResponseWriter, resource *mapper.Resource, tid string, resourceID string) {
+	contentTypeHeader := resource.ContentType
+	setResourceHeaders(w, resource, tid, resourceID)
+
+	// streamed content is written out as it is read, rather than from memory
+	if s, ok := resource.Content.(*mapper.Stream); ok && s.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(s.Size, 10))
+	}
+
+	om, err := mapper.OutMapperForContentType(contentTypeHeader)
+	if err != nil {
+		msg := fmt.Sprintf("Unable to handle resource of type %T", resource)
+		logger.WithError(err).WithTransactionID(tid).WithUUID(resourceID).Warn(msg)
+		http.Error(w, msg, http.StatusNotImplemented)
+		return
+	}
+
+	err = om(w, resource)
+	if err != nil {
+		msg := fmt.Sprintf("Unable to extract native content from resource with id %v. %v", resourceID, err.Error())
+		logger.WithTransactionID(tid).WithUUID(resourceID).WithError(err).Errorf(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+	} else {
+		logger.WithTransactionID(tid).WithUUID(resourceID).Info("Read native content successfully")
+	}
+}
+
+const (
+	defaultIDsLimit = 1000
+	maxIDsLimit     = 10000
+	maxIDsShards    = 1024
+)
+
+type idLine struct {
+	ID           string     `json:"id"`
+	Hash         string     `json:"hash,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+}
+
+// idFields are the optional fields of the id lines, listed in the include parameter
+type idFields struct {
+	hash         bool
+	lastModified bool
+}
+
+func (f idFields) line(docID *db.ID) idLine {
+	line := idLine{ID: docID.UUID}
+	if f.hash {
+		line.Hash = docID.Hash
+	}
+	if f.lastModified && !docID.LastModified.IsZero() {
+		line.LastModified = &docID.LastModified
+	}
+	return line
+}
+
+// idsTrailer ends a page of ids, with either the cursor of the next page or the marker of the last one
+type idsTrailer struct {
+	Next     string `json:"next,omitempty"`
+	Complete bool   `json:"complete,omitempty"`
 }
 
+// ReadIDs streams the ids of the collection as newline delimited json, optionally only the ones of an origin system or content
+// type, modified within a period, or of a shard of the uuid keyspace, and with their hash and last modification time. With a
+// limit or an after cursor, it responds with a page of ids in uuid order, ended by a trailer line with the cursor of the next
+// page, or a completion marker for the last one. Without either, it streams all the ids within 10s, which isn't enough for large
+// collections.
 func ReadIDs(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		connection, err := mongo.Open()
@@ -85,10 +177,31 @@ func ReadIDs(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
 		coll := vars["collection"]
 		tid := obtainTxID(r)
 
+		params := r.URL.Query()
+		paged := params.Get("limit") != "" || params.Get("after") != ""
+
+		query, err := parseIDsQuery(params)
+		if err != nil {
+			writeMessage(w, fmt.Sprintf("Invalid ids query: %v", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		fields, err := parseIDFields(params.Get("include"))
+		if err != nil {
+			writeMessage(w, fmt.Sprintf("Invalid ids query: %v", err.Error()), http.StatusBadRequest)
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		ids, err := connection.ReadIDs(ctx, coll)
+		limit := query.Limit
+		if paged {
+			// one more id tells whether there is a next page
+			query.Limit++
+		}
+
+		ids, err := connection.ReadIDs(ctx, coll, query)
 		if err != nil {
 			msg := fmt.Sprintf(`Failed to read IDs from mongo for %v! "%v"`, coll, err.Error())
 			logger.WithTransactionID(tid).WithError(err).Error(msg)
@@ -96,26 +209,155 @@ func ReadIDs(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		id := struct {
-			ID string `json:"id"`
-		}{}
+		if paged {
+			writeIDsPage(ctx, w, r, ids, limit, fields, tid)
+			return
+		}
 
 		bw := bufio.NewWriter(w)
-		for {
-			docID, ok := <-ids
-			if !ok {
-				break
+		for docID := range ids {
+			if docID.Err != nil {
+				// the status is long sent, and only a page of ids can tell it is incomplete
+				logger.WithTransactionID(tid).WithError(docID.Err).Errorf("Failed to read all the IDs from mongo for %v", coll)
+				continue
 			}
+			writeLine(bw, fields.line(docID), tid)
 
-			id.ID = docID
-			jd, _ := json.Marshal(id)
+			bw.Flush()
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+// writeIDsPage responds with the page of at most limit ids. A page cut short by the timeout or by a failing read still carries
+// on from its last id, and is never complete.
+func writeIDsPage(ctx context.Context, w http.ResponseWriter, r *http.Request, ids chan *db.ID, limit int64, fields idFields, tid string) {
+	page := make([]*db.ID, 0, limit)
+	more := false
+	var readErr error
+	for docID := range ids {
+		if docID.Err != nil {
+			readErr = docID.Err
+			continue
+		}
+		if int64(len(page)) == limit {
+			more = true
+			continue
+		}
+		page = append(page, docID)
+	}
+
+	if readErr == nil && ctx.Err() != nil {
+		readErr = ctx.Err()
+	}
+
+	if readErr != nil {
+		if len(page) == 0 {
+			msg := fmt.Sprintf("Failed to read IDs from mongo for %v", mux.Vars(r)["collection"])
+			logger.WithTransactionID(tid).WithError(readErr).Error(msg)
+			http.Error(w, msg, http.StatusServiceUnavailable)
+			return
+		}
+		logger.WithTransactionID(tid).WithError(readErr).Warnf("Cut short a page of IDs of %v", mux.Vars(r)["collection"])
+		more = true
+	}
+
+	trailer := idsTrailer{Complete: !more}
+	if more {
+		trailer.Next = page[len(page)-1].UUID
+
+		next := r.URL.Query()
+		next.Set("after", trailer.Next)
+		next.Set("limit", strconv.FormatInt(limit, 10))
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, next.Encode()))
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
 
-			if _, err = bw.WriteString(string(jd) + "\n"); err != nil {
-				logger.WithTransactionID(tid).WithError(err).Error("unable to write string")
+	bw := bufio.NewWriter(w)
+	for _, docID := range page {
+		writeLine(bw, fields.line(docID), tid)
+	}
+	writeLine(bw, trailer, tid)
+	bw.Flush()
+}
+
+// parseIDsQuery reads the filters, shard and page of an ids query, the limit defaulting to defaultIDsLimit when only after is
+// given. The modification times are RFC 3339 timestamps.
+func parseIDsQuery(params url.Values) (db.IDsQuery, error) {
+	query := db.IDsQuery{
+		After:          params.Get("after"),
+		OriginSystemID: params.Get("originSystemId"),
+		ContentType:    params.Get("contentType"),
+	}
+
+	if query.After != "" && !uuidRegexp.MatchString(query.After) {
+		return query, fmt.Errorf("after %q is not the id of a document", query.After)
+	}
+
+	var err error
+	for name, t := range map[string]*time.Time{"modifiedSince": &query.ModifiedSince, "modifiedBefore": &query.ModifiedBefore} {
+		if value := params.Get(name); value != "" {
+			if *t, err = time.Parse(time.RFC3339Nano, value); err != nil {
+				return query, fmt.Errorf("%s %q is not an RFC 3339 timestamp", name, value)
 			}
+		}
+	}
 
-			bw.Flush()
-			w.(http.Flusher).Flush()
+	if shard, shards := params.Get("shard"), params.Get("shards"); shard != "" || shards != "" {
+		if query.Shards, err = strconv.Atoi(shards); err != nil || query.Shards < 1 || query.Shards > maxIDsShards {
+			return query, fmt.Errorf("shards %q is not between 1 and %d", shards, maxIDsShards)
+		}
+		if query.Shard, err = strconv.Atoi(shard); err != nil || query.Shard < 0 || query.Shard >= query.Shards {
+			return query, fmt.Errorf("shard %q is not between 0 and %d", shard, query.Shards-1)
+		}
+	}
+
+	if batchSize := params.Get("batchSize"); batchSize != "" {
+		size, err := strconv.ParseInt(batchSize, 10, 32)
+		if err != nil || size < 1 || size > maxIDsLimit {
+			return query, fmt.Errorf("batchSize %q is not between 1 and %d", batchSize, maxIDsLimit)
+		}
+		query.BatchSize = int32(size)
+	}
+
+	limit := params.Get("limit")
+	if limit == "" {
+		if query.After != "" {
+			query.Limit = defaultIDsLimit
 		}
+		return query, nil
+	}
+
+	if query.Limit, err = strconv.ParseInt(limit, 10, 64); err != nil || query.Limit < 1 || query.Limit > maxIDsLimit {
+		return query, fmt.Errorf("limit %q is not between 1 and %d", limit, maxIDsLimit)
+	}
+	return query, nil
+}
+
+// parseIDFields reads the comma separated list of optional fields of the id lines
+func parseIDFields(include string) (idFields, error) {
+	fields := idFields{}
+	if include == "" {
+		return fields, nil
+	}
+
+	for _, field := range strings.Split(include, ",") {
+		switch strings.TrimSpace(field) {
+		case "hash":
+			fields.hash = true
+		case "lastModified":
+			fields.lastModified = true
+		default:
+			return fields, fmt.Errorf("%q is not a field of the ids, it should be hash or lastModified", field)
+		}
+	}
+	return fields, nil
+}
+
+func writeLine(bw *bufio.Writer, line interface{}, tid string) {
+	jd, _ := json.Marshal(line)
+	if _, err := bw.WriteString(string(jd) + "\n"); err != nil {
+		logger.WithTransactionID(tid).WithError(err).Error("unable to write string")
 	}
 }
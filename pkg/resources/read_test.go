@@ -2,15 +2,19 @@ package resources
 
 import (
 	"errors"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	"github.com/Financial-Times/nativerw/pkg/db"
 	"github.com/Financial-Times/nativerw/pkg/mapper"
 )
 
@@ -19,7 +23,7 @@ func TestReadContent(t *testing.T) {
 	connection := new(MockConnection)
 
 	mongo.On("Open").Return(connection, nil)
-	connection.On("Read", "methode", "a-real-uuid").Return(&mapper.Resource{ContentType: "application/json", Content: map[string]interface{}{"uuid": "fake-data"}}, true, nil)
+	connection.On("Read", "methode", "a-real-uuid").Return(&mapper.Resource{ContentType: "application/json", Content: map[string]interface{}{"uuid": "fake-data"}, Revision: 4}, true, nil)
 
 	router := mux.NewRouter()
 	router.HandleFunc("/{collection}/{resource}", ReadContent(mongo)).Methods("GET")
@@ -31,9 +35,36 @@ func TestReadContent(t *testing.T) {
 	mongo.AssertExpectations(t)
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+	assert.Equal(t, "4f1832db5812fbfa090ee54944d5cf871b42362f6f8dd2135b7ae752", w.Header().Get("X-Native-Hash"))
 	assert.Equal(t, `{"uuid":"fake-data"}`, strings.TrimSpace(w.Body.String()))
 }
 
+func TestReadStreamedContent(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	stream := mapper.NewStream(19, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("some binary content")), nil
+	})
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Read", "methode", "a-real-uuid").Return(&mapper.Resource{ContentType: "application/octet-stream", Content: stream, Hash: "a-hash"}, true, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}", ReadContent(mongo)).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/methode/a-real-uuid", http.NoBody)
+
+	router.ServeHTTP(w, req)
+	mongo.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "19", w.Header().Get("Content-Length"))
+	assert.Equal(t, "a-hash", w.Header().Get("X-Native-Hash"))
+	assert.Equal(t, "some binary content", w.Body.String())
+}
+
 func TestReadContentWithCharsetDirective(t *testing.T) {
 	mongo := new(MockDB)
 	connection := new(MockConnection)
@@ -146,10 +177,10 @@ func TestReadIDs(t *testing.T) {
 	mongo := new(MockDB)
 	connection := new(MockConnection)
 
-	ids := make(chan string, 1)
+	ids := make(chan *db.ID, 1)
 
 	mongo.On("Open").Return(connection, nil)
-	connection.On("ReadIDs", mock.AnythingOfType("*context.timerCtx"), "methode").Return(ids, nil)
+	connection.On("ReadIDs", mock.AnythingOfType("*context.timerCtx"), "methode", db.IDsQuery{}).Return(ids, nil)
 
 	router := mux.NewRouter()
 	router.HandleFunc("/{collection}/__ids", ReadIDs(mongo)).Methods("GET")
@@ -158,7 +189,7 @@ func TestReadIDs(t *testing.T) {
 	req, _ := http.NewRequest("GET", "/methode/__ids", http.NoBody)
 
 	go func() {
-		ids <- "hi"
+		ids <- &db.ID{UUID: "hi"}
 		close(ids)
 	}()
 
@@ -171,6 +202,160 @@ func TestReadIDs(t *testing.T) {
 	assert.Equal(t, `{"id":"hi"}`, strings.TrimSpace(w.Body.String()))
 }
 
+func readIDsPage(t *testing.T, target string, query db.IDsQuery, read []string) *httptest.ResponseRecorder {
+	ids := make([]*db.ID, 0, len(read))
+	for _, id := range read {
+		ids = append(ids, &db.ID{UUID: id})
+	}
+	return readIDs(t, target, query, ids)
+}
+
+func readIDs(t *testing.T, target string, query db.IDsQuery, read []*db.ID) *httptest.ResponseRecorder {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	ids := make(chan *db.ID, len(read))
+	for _, id := range read {
+		ids <- id
+	}
+	close(ids)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadIDs", mock.AnythingOfType("*context.timerCtx"), "methode", query).Return(ids, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/__ids", ReadIDs(mongo)).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", target, http.NoBody)
+	router.ServeHTTP(w, req)
+
+	connection.AssertExpectations(t)
+	return w
+}
+
+func TestReadIDsPage(t *testing.T) {
+	w := readIDsPage(t, "/methode/__ids?limit=2", db.IDsQuery{Limit: 3}, []string{
+		"1a4b6d3c-0000-4000-8000-000000000001",
+		"1a4b6d3c-0000-4000-8000-000000000002",
+		"1a4b6d3c-0000-4000-8000-000000000003",
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, `</methode/__ids?after=1a4b6d3c-0000-4000-8000-000000000002&limit=2>; rel="next"`, w.Header().Get("Link"))
+	assert.Equal(t, `{"id":"1a4b6d3c-0000-4000-8000-000000000001"}
+{"id":"1a4b6d3c-0000-4000-8000-000000000002"}
+{"next":"1a4b6d3c-0000-4000-8000-000000000002"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestReadIDsLastPage(t *testing.T) {
+	w := readIDsPage(t, "/methode/__ids?after=1a4b6d3c-0000-4000-8000-000000000002", db.IDsQuery{After: "1a4b6d3c-0000-4000-8000-000000000002", Limit: defaultIDsLimit + 1}, []string{
+		"1a4b6d3c-0000-4000-8000-000000000003",
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Link"))
+	assert.Equal(t, `{"id":"1a4b6d3c-0000-4000-8000-000000000003"}
+{"complete":true}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestReadIDsPageFailsPartway(t *testing.T) {
+	w := readIDs(t, "/methode/__ids?limit=3", db.IDsQuery{Limit: 4}, []*db.ID{
+		{UUID: "1a4b6d3c-0000-4000-8000-000000000001"},
+		{UUID: "1a4b6d3c-0000-4000-8000-000000000002"},
+		{Err: errors.New("connection reset by peer")},
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `</methode/__ids?after=1a4b6d3c-0000-4000-8000-000000000002&limit=3>; rel="next"`, w.Header().Get("Link"))
+	assert.Equal(t, `{"id":"1a4b6d3c-0000-4000-8000-000000000001"}
+{"id":"1a4b6d3c-0000-4000-8000-000000000002"}
+{"next":"1a4b6d3c-0000-4000-8000-000000000002"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestReadIDsPageFailsFirst(t *testing.T) {
+	w := readIDs(t, "/methode/__ids?limit=3", db.IDsQuery{Limit: 4}, []*db.ID{{Err: errors.New("cursor killed")}})
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NotContains(t, w.Body.String(), "complete")
+}
+
+func TestReadIDsFilters(t *testing.T) {
+	lastModified := time.Date(2020, 3, 4, 10, 30, 15, 0, time.UTC)
+
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	ids := make(chan *db.ID, 2)
+	ids <- &db.ID{UUID: "1a4b6d3c-0000-4000-8000-000000000001", Hash: "hash", LastModified: lastModified}
+	ids <- &db.ID{UUID: "1a4b6d3c-0000-4000-8000-000000000002", Hash: "hash"}
+	close(ids)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadIDs", mock.AnythingOfType("*context.timerCtx"), "methode", db.IDsQuery{
+		Limit:          3,
+		OriginSystemID: "methode-web-pub",
+		ContentType:    "application/json",
+		ModifiedSince:  time.Date(2020, 3, 4, 0, 0, 0, 0, time.UTC),
+		ModifiedBefore: time.Date(2020, 3, 5, 0, 0, 0, 0, time.UTC),
+	}).Return(ids, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/__ids", ReadIDs(mongo)).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/methode/__ids?limit=2&originSystemId=methode-web-pub&contentType=application/json&modifiedSince=2020-03-04T00:00:00Z&modifiedBefore=2020-03-05T00:00:00Z&include=hash,lastModified", http.NoBody)
+	router.ServeHTTP(w, req)
+
+	connection.AssertExpectations(t)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"id":"1a4b6d3c-0000-4000-8000-000000000001","hash":"hash","lastModified":"2020-03-04T10:30:15Z"}
+{"id":"1a4b6d3c-0000-4000-8000-000000000002","hash":"hash"}
+{"complete":true}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestReadIDsShard(t *testing.T) {
+	w := readIDsPage(t, "/methode/__ids?shard=2&shards=8&batchSize=500&limit=100", db.IDsQuery{Shard: 2, Shards: 8, BatchSize: 500, Limit: 101}, []string{
+		"4a4b6d3c-0000-4000-8000-000000000001",
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"id":"4a4b6d3c-0000-4000-8000-000000000001"}
+{"complete":true}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestReadIDsInvalidPage(t *testing.T) {
+	for _, target := range []string{
+		"/methode/__ids?limit=0",
+		"/methode/__ids?limit=10001",
+		"/methode/__ids?limit=ten",
+		"/methode/__ids?after=not-a-uuid",
+		"/methode/__ids?modifiedSince=yesterday",
+		"/methode/__ids?modifiedBefore=2020-03-04",
+		"/methode/__ids?include=content",
+		"/methode/__ids?shard=1",
+		"/methode/__ids?shard=4&shards=4",
+		"/methode/__ids?shard=-1&shards=4",
+		"/methode/__ids?shard=0&shards=1025",
+		"/methode/__ids?batchSize=0",
+	} {
+		mongo := new(MockDB)
+		connection := new(MockConnection)
+		mongo.On("Open").Return(connection, nil)
+
+		router := mux.NewRouter()
+		router.HandleFunc("/{collection}/__ids", ReadIDs(mongo)).Methods("GET")
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", target, http.NoBody)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, target)
+	}
+}
+
 func TestReadIDsMongoOpenFails(t *testing.T) {
 	mongo := new(MockDB)
 	mongo.On("Open").Return(nil, errors.New("no data 4 u"))
@@ -191,10 +376,10 @@ func TestReadIDsMongoCallFails(t *testing.T) {
 	mongo := new(MockDB)
 	connection := new(MockConnection)
 
-	ids := make(chan string, 1)
+	ids := make(chan *db.ID, 1)
 
 	mongo.On("Open").Return(connection, nil)
-	connection.On("ReadIDs", mock.AnythingOfType("*context.timerCtx"), "methode").Return(ids, errors.New(`oh no`))
+	connection.On("ReadIDs", mock.AnythingOfType("*context.timerCtx"), "methode", db.IDsQuery{}).Return(ids, errors.New(`oh no`))
 
 	router := mux.NewRouter()
 	router.HandleFunc("/{collection}/__ids", ReadIDs(mongo)).Methods("GET")
@@ -207,3 +392,74 @@ func TestReadIDsMongoCallFails(t *testing.T) {
 
 	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
 }
+
+var lastModifiedResource = &mapper.Resource{
+	ContentType:  "application/json",
+	Content:      map[string]interface{}{"uuid": "fake-data"},
+	Revision:     2,
+	LastModified: time.Date(2020, 3, 4, 10, 30, 15, 500000000, time.UTC),
+}
+
+func TestReadContentLastModified(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Read", "methode", "a-real-uuid").Return(lastModifiedResource, true, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}", ReadContent(mongo)).Methods("GET", "HEAD")
+
+	var tests = []struct {
+		method       string
+		header       string
+		value        string
+		expectedCode int
+		expectedBody string
+	}{
+		{"GET", "", "", http.StatusOK, `{"uuid":"fake-data"}`},
+		{"GET", "If-Modified-Since", "Wed, 04 Mar 2020 10:30:15 GMT", http.StatusNotModified, ""},
+		{"GET", "If-Modified-Since", "Wed, 04 Mar 2020 10:30:14 GMT", http.StatusOK, `{"uuid":"fake-data"}`},
+		{"GET", "If-Modified-Since", "not a date", http.StatusOK, `{"uuid":"fake-data"}`},
+		{"GET", "If-Unmodified-Since", "Wed, 04 Mar 2020 10:30:15 GMT", http.StatusOK, `{"uuid":"fake-data"}`},
+		{"GET", "If-Unmodified-Since", "Wed, 04 Mar 2020 10:30:14 GMT", http.StatusPreconditionFailed, `{"message":"Precondition failed, the resource has been modified in the meantime"}`},
+		{"HEAD", "", "", http.StatusOK, ""},
+		{"HEAD", "If-Modified-Since", "Wed, 04 Mar 2020 10:30:15 GMT", http.StatusNotModified, ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(test.method, "/methode/a-real-uuid", http.NoBody)
+		if test.header != "" {
+			req.Header.Add(test.header, test.value)
+		}
+
+		router.ServeHTTP(w, req)
+		assert.Equal(t, test.expectedCode, w.Code, "%s %s: %s", test.method, test.header, test.value)
+		assert.Equal(t, test.expectedBody, strings.TrimSpace(w.Body.String()), "%s %s: %s", test.method, test.header, test.value)
+
+		if test.expectedCode != http.StatusPreconditionFailed {
+			assert.Equal(t, "Wed, 04 Mar 2020 10:30:15 GMT", w.Header().Get("Last-Modified"))
+			assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestReadContentWithoutLastModified(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Read", "methode", "a-real-uuid").Return(&mapper.Resource{ContentType: "application/json", Content: map[string]interface{}{}}, true, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}", ReadContent(mongo)).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/methode/a-real-uuid", http.NoBody)
+	req.Header.Add("If-Modified-Since", "Wed, 04 Mar 2020 10:30:15 GMT")
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", w.Header().Get("Last-Modified"))
+}
@@ -0,0 +1,46 @@
+package resources
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/db"
+)
+
+// RestoreContent brings back a deleted native document, as long as its tombstone is still within the retention window
+func RestoreContent(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		collectionID := mux.Vars(r)["collection"]
+		resourceID := mux.Vars(r)["resource"]
+		tid := obtainTxID(r)
+
+		err = connection.Restore(collectionID, resourceID, tid)
+		if err == db.ErrNotFound {
+			msg := fmt.Sprintf("No restorable deleted resource, collection= %v, id= %v", collectionID, resourceID)
+			logger.WithTransactionID(tid).WithUUID(resourceID).Info(msg)
+			writeMessage(w, msg, http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			msg := "Restoring in mongoDB failed"
+			logger.WithMonitoringEvent("SaveToNative", tid, "").WithUUID(resourceID).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf("%s\n%v\n", msg, err), http.StatusInternalServerError)
+			return
+		}
+
+		logger.WithMonitoringEvent("SaveToNative", tid, "").WithUUID(resourceID).Info(fmt.Sprintf("Successfully restored, collection=%s", collectionID))
+		writeMessage(w, "Resource restored", http.StatusOK)
+	}
+}
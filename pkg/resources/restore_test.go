@@ -0,0 +1,76 @@
+package resources
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Financial-Times/nativerw/pkg/db"
+)
+
+func restoreRouter(mongo *MockDB) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}/__restore", RestoreContent(mongo)).Methods("POST")
+	return router
+}
+
+func TestRestoreContent(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Restore", "methode", "a-real-uuid", testTxID).Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/a-real-uuid/__restore", http.NoBody)
+	req.Header.Add("X-Request-Id", testTxID)
+
+	restoreRouter(mongo).ServeHTTP(w, req)
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRestoreContentNotFound(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Restore", "methode", "a-real-uuid", testTxID).Return(db.ErrNotFound)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/a-real-uuid/__restore", http.NoBody)
+	req.Header.Add("X-Request-Id", testTxID)
+
+	restoreRouter(mongo).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRestoreContentFailed(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Restore", "methode", "a-real-uuid", testTxID).Return(errors.New("i failed"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/a-real-uuid/__restore", http.NoBody)
+	req.Header.Add("X-Request-Id", testTxID)
+
+	restoreRouter(mongo).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestFailedMongoOnRestore(t *testing.T) {
+	mongo := new(MockDB)
+	mongo.On("Open").Return(nil, errors.New("no data 4 u"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/a-real-uuid/__restore", http.NoBody)
+
+	restoreRouter(mongo).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
@@ -0,0 +1,121 @@
+package resources
+
+import (
+	"errors"
+	"expvar"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/config"
+	"github.com/Financial-Times/nativerw/pkg/db"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+	"github.com/Financial-Times/nativerw/pkg/schema"
+)
+
+const (
+	rejectedMetric   = "rejected"
+	warnedMetric     = "warned"
+	violationsMetric = "violations"
+)
+
+// schemaMetrics are published by expvar at /debug/vars, under schema.{collection}
+var (
+	schemaMetrics      = expvar.NewMap("schema")
+	schemaMetricsMutex = &sync.Mutex{}
+)
+
+func schemaMetricsFor(collection string) *expvar.Map {
+	schemaMetricsMutex.Lock()
+	defer schemaMetricsMutex.Unlock()
+
+	if m, ok := schemaMetrics.Get(collection).(*expvar.Map); ok {
+		return m
+	}
+
+	m := new(expvar.Map).Init()
+	schemaMetrics.Set(collection, m)
+	return m
+}
+
+var errSchemaViolations = errors.New("the content doesn't match the schema of its collection")
+
+// validateContent returns the violations of the schema of the policy by the content, which is only validated when it is json
+func validateContent(policy *config.Policy, content interface{}) []schema.Violation {
+	s := policy.ContentSchema()
+	if s == nil {
+		return nil
+	}
+
+	switch content.(type) {
+	case []byte, *mapper.Stream:
+		return nil
+	default:
+		return s.Validate(content)
+	}
+}
+
+// schemaCheck validates the content of a single write, keeping the violations of the last validation to report them
+type schemaCheck struct {
+	policy     *config.Policy
+	collection string
+	violations []schema.Violation
+}
+
+// validator returns the function validating the content as it is written, or nil if the collection has no schema
+func (c *schemaCheck) validator() db.Validator {
+	if c.policy.ContentSchema() == nil {
+		return nil
+	}
+
+	return func(content interface{}) error {
+		// a write retried after a conflict validates its content again, so only the last violations count
+		c.violations = validateContent(c.policy, content)
+		if len(c.violations) > 0 && !c.policy.WarnOnly() {
+			return errSchemaViolations
+		}
+		return nil
+	}
+}
+
+// reject responds with the violations of the content the write was aborted for
+func (c *schemaCheck) reject(w http.ResponseWriter, tid, resourceID string) {
+	m := schemaMetricsFor(c.collection)
+	m.Add(rejectedMetric, 1)
+	m.Add(violationsMetric, int64(len(c.violations)))
+
+	msg := "The content doesn't match the schema of collection " + c.collection
+	logger.WithTransactionID(tid).WithUUID(resourceID).Warnf("%s: %s", msg, joinViolations(c.violations))
+
+	writeJSON(w, tid, struct {
+		Message    string             `json:"message"`
+		Violations []schema.Violation `json:"violations"`
+	}{msg, c.violations}, http.StatusUnprocessableEntity)
+}
+
+// warn logs and counts the violations of the content written anyway, if it had any
+func (c *schemaCheck) warn(tid, resourceID string) {
+	warnViolations(c.collection, tid, resourceID, c.violations)
+}
+
+func warnViolations(collection, tid, resourceID string, violations []schema.Violation) {
+	if len(violations) == 0 {
+		return
+	}
+
+	m := schemaMetricsFor(collection)
+	m.Add(warnedMetric, 1)
+	m.Add(violationsMetric, int64(len(violations)))
+
+	logger.WithTransactionID(tid).WithUUID(resourceID).
+		Warnf("Written content doesn't match the schema of collection %s: %s", collection, joinViolations(violations))
+}
+
+func joinViolations(violations []schema.Violation) string {
+	msgs := make([]string, 0, len(violations))
+	for _, v := range violations {
+		msgs = append(msgs, v.String())
+	}
+	return strings.Join(msgs, "; ")
+}
@@ -0,0 +1,177 @@
+package resources
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/config"
+	"github.com/Financial-Times/nativerw/pkg/db"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func testSchemas(t *testing.T) *config.Configuration {
+	conf, err := config.ReadConfigFromReader(strings.NewReader(`{
+		"policies": {
+			"methode": {
+				"schema": {"type": "object", "required": ["title"], "properties": {"title": {"type": "string"}}}
+			},
+			"wordpress": {
+				"schema": {"type": "object", "required": ["title"]},
+				"schemaMode": "warn"
+			}
+		}
+	}`))
+	require.NoError(t, err)
+	return conf
+}
+
+func schemaMetric(collection, name string) int64 {
+	if v, ok := schemaMetricsFor(collection).Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestWriteContentRejectsSchemaViolations(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Write", "methode", mock.AnythingOfType("*mapper.Resource"), mock.AnythingOfType("db.Precondition"), mock.AnythingOfType("db.Validator")).Run(func(args mock.Arguments) {
+		validator := args.Get(3).(db.Validator)
+		assert.Equal(t, errSchemaViolations, validator(args.Get(1).(*mapper.Resource).Content))
+	}).Return(errSchemaViolations)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}", Filter(WriteContent(mongo)).EnforcePolicy(testSchemas(t)).Build()).Methods("PUT")
+
+	rejected := schemaMetric("methode", rejectedMetric)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/methode/a-real-uuid", strings.NewReader(`{"title":1,"body":"Body"}`))
+	req.Header.Add("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{
+		"message": "The content doesn't match the schema of collection methode",
+		"violations": [{"pointer": "/title", "message": "expected string, but got number"}]
+	}`, w.Body.String())
+	assert.Equal(t, rejected+1, schemaMetric("methode", rejectedMetric))
+}
+
+func TestWriteContentWarnsOfSchemaViolations(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Write", "wordpress", mock.AnythingOfType("*mapper.Resource"), mock.AnythingOfType("db.Precondition"), mock.AnythingOfType("db.Validator")).Run(func(args mock.Arguments) {
+		validator := args.Get(3).(db.Validator)
+		assert.NoError(t, validator(args.Get(1).(*mapper.Resource).Content))
+	}).Return(nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}", Filter(WriteContent(mongo)).EnforcePolicy(testSchemas(t)).Build()).Methods("PUT")
+
+	warned := schemaMetric("wordpress", warnedMetric)
+	violations := schemaMetric("wordpress", violationsMetric)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/wordpress/a-real-uuid", strings.NewReader(`{"body":"Body"}`))
+	req.Header.Add("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, warned+1, schemaMetric("wordpress", warnedMetric))
+	assert.Equal(t, violations+1, schemaMetric("wordpress", violationsMetric))
+}
+
+func TestWriteContentWithoutSchema(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Write", "video", mock.AnythingOfType("*mapper.Resource"), db.Precondition{}, noValidator).Return(nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}", Filter(WriteContent(mongo)).EnforcePolicy(testSchemas(t)).Build()).Methods("PUT")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/video/a-real-uuid", strings.NewReader(`{"body":"Body"}`))
+	req.Header.Add("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestPatchContentRejectsSchemaViolations(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Patch", "methode", mock.AnythingOfType("*mapper.Resource"), mock.AnythingOfType("db.Precondition"), mock.AnythingOfType("db.Validator")).Run(func(args mock.Arguments) {
+		validator := args.Get(3).(db.Validator)
+		// the patch alone has no title, the merged content does
+		assert.NoError(t, validator(map[string]interface{}{"title": "Title", "body": "Body"}))
+		assert.Equal(t, errSchemaViolations, validator(map[string]interface{}{"body": "Body"}))
+	}).Return((*mapper.Resource)(nil), errSchemaViolations)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}", Filter(PatchContent(mongo)).EnforcePolicy(testSchemas(t)).Build()).Methods("PATCH")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PATCH", "/methode/a-real-uuid", strings.NewReader(`{"title":null}`))
+	req.Header.Add("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{
+		"message": "The content doesn't match the schema of collection methode",
+		"violations": [{"pointer": "", "message": "missing properties: 'title'"}]
+	}`, w.Body.String())
+}
+
+func TestBulkWriteValidatesSchema(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("GetSupportedCollections").Return(map[string]bool{"methode": true})
+	connection.On("BulkWrite", "methode", mock.MatchedBy(func(resources []*mapper.Resource) bool {
+		return len(resources) == 1 && resources[0].UUID == "9694733e-163a-4393-801f-000ab7de5041"
+	})).Run(func(args mock.Arguments) {
+		hashed(args.Get(1).([]*mapper.Resource))
+	}).Return([]bool{true}, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/__bulk", Filter(BulkWrite(mongo)).EnforcePolicy(testSchemas(t)).Build()).Methods("POST")
+
+	body := `{"uuid":"9694733e-163a-4393-801f-000ab7de5041","contentType":"application/json","content":{"title":"Title"}}
+{"uuid":"59b3a4d0-9a17-11e6-8dbb-4b02b9e5d5ff","contentType":"application/json","content":{"title":1}}`
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/__bulk", strings.NewReader(body))
+
+	router.ServeHTTP(w, req)
+
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"line":1,"uuid":"9694733e-163a-4393-801f-000ab7de5041","status":"ok","hash":"hash-of-9694733e"}
+{"line":2,"uuid":"59b3a4d0-9a17-11e6-8dbb-4b02b9e5d5ff","status":"invalid","error":"content doesn't match the schema of collection methode: /title: expected string, but got number"}`, strings.TrimSpace(w.Body.String()))
+}
@@ -0,0 +1,151 @@
+package resources
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/db"
+)
+
+const statsTTL = 30 * time.Second
+
+type stats struct {
+	Count           int64             `json:"count"`
+	Size            int64             `json:"size"`
+	AverageSize     int64             `json:"averageSize"`
+	ContentTypes    map[string]int64  `json:"contentTypes"`
+	OriginSystemIDs map[string]int64  `json:"originSystemIds"`
+	Indexes         map[string]int64  `json:"indexes"`
+	OldestModified  *time.Time        `json:"oldestModified,omitempty"`
+	NewestModified  *time.Time        `json:"newestModified,omitempty"`
+	Compression     *compressionStats `json:"compression,omitempty"`
+	Estimated       bool              `json:"estimated,omitempty"`
+	Computed        time.Time         `json:"computed"`
+}
+
+// compressionStats describe the documents stored compressed, and the ratio of the size of their content before and after
+type compressionStats struct {
+	Count            int64   `json:"count"`
+	CompressedSize   int64   `json:"compressedSize"`
+	UncompressedSize int64   `json:"uncompressedSize"`
+	Ratio            float64 `json:"ratio"`
+}
+
+func newStats(s *db.Stats, computed time.Time) *stats {
+	resp := &stats{
+		Count:           s.Count,
+		Size:            s.Size,
+		AverageSize:     s.AverageSize,
+		ContentTypes:    s.ContentTypes,
+		OriginSystemIDs: s.OriginSystemIDs,
+		Indexes:         s.Indexes,
+		Estimated:       s.Estimated,
+		Computed:        computed,
+	}
+	if !s.OldestModified.IsZero() {
+		resp.OldestModified = &s.OldestModified
+	}
+	if !s.NewestModified.IsZero() {
+		resp.NewestModified = &s.NewestModified
+	}
+	if s.Compressed > 0 {
+		resp.Compression = &compressionStats{Count: s.Compressed, CompressedSize: s.CompressedSize, UncompressedSize: s.UncompressedSize}
+		if s.CompressedSize > 0 {
+			resp.Compression.Ratio = math.Round(float64(s.UncompressedSize)/float64(s.CompressedSize)*100) / 100
+		}
+	}
+	return resp
+}
+
+// statsCache keeps the stats of each collection for a while, and computes them once for all the concurrent requests of the
+// collection, so that dashboards polling them don't load the database with aggregations. Each collection has its own lock, so
+// that a slow one doesn't hold up the requests for the others.
+type statsCache struct {
+	mutex   *sync.Mutex
+	ttl     time.Duration
+	entries map[string]*statsEntry
+}
+
+// statsEntry holds the stats of a collection, and is locked while they are computed
+type statsEntry struct {
+	mutex *sync.Mutex
+	stats *stats
+}
+
+func newStatsCache(ttl time.Duration) *statsCache {
+	return &statsCache{mutex: &sync.Mutex{}, ttl: ttl, entries: make(map[string]*statsEntry)}
+}
+
+// entry returns the entry of the collection, only holding the lock of the cache for as long as it takes to look it up
+func (c *statsCache) entry(collection string) *statsEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, found := c.entries[collection]
+	if !found {
+		e = &statsEntry{mutex: &sync.Mutex{}}
+		c.entries[collection] = e
+	}
+	return e
+}
+
+func (c *statsCache) get(connection db.Connection, collection string) (*stats, error) {
+	e := c.entry(collection)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.stats != nil && time.Since(e.stats.Computed) < c.ttl {
+		return e.stats, nil
+	}
+
+	s, err := connection.Stats(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	e.stats = newStats(s, time.Now().UTC())
+	return e.stats, nil
+}
+
+// ReadStats responds with the number and sizes of the live documents of the collection, their counts by content type and origin
+// system, their oldest and newest modification times, and the sizes of the indexes. The stats are cached for statsTTL.
+func ReadStats(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return readStats(mongo, newStatsCache(statsTTL))
+}
+
+func readStats(mongo db.DB, cache *statsCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		collection := mux.Vars(r)["collection"]
+		tid := obtainTxID(r)
+
+		s, err := cache.get(connection, collection)
+		if err != nil {
+			msg := "Computing the stats of the collection in mongoDB failed."
+			logger.WithTransactionID(tid).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf(msg+": %v", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		maxAge := int((cache.ttl - time.Since(s.Computed)).Seconds())
+		if maxAge < 0 {
+			maxAge = 0
+		}
+		w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(maxAge))
+		writeJSON(w, tid, s, http.StatusOK)
+	}
+}
@@ -0,0 +1,168 @@
+package resources
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/db"
+)
+
+func statsRouter(mongo *MockDB, cache *statsCache) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/__stats", readStats(mongo, cache)).Methods("GET")
+	return router
+}
+
+func getStats(t *testing.T, router *mux.Router) *httptest.ResponseRecorder {
+	return getCollectionStats(t, router, "methode")
+}
+
+func getCollectionStats(t *testing.T, router *mux.Router, collection string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/"+collection+"/__stats", http.NoBody)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestReadStats(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	oldest := time.Date(2020, 3, 4, 10, 30, 15, 0, time.UTC)
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Stats", "methode").Return(&db.Stats{
+		Count:           2,
+		Size:            300,
+		AverageSize:     150,
+		ContentTypes:    map[string]int64{"application/json": 2},
+		OriginSystemIDs: map[string]int64{"methode-web-pub": 2},
+		Indexes:         map[string]int64{"uuid-index": 4096},
+		OldestModified:  oldest,
+		NewestModified:  oldest.Add(time.Hour),
+	}, nil).Once()
+
+	router := statsRouter(mongo, newStatsCache(time.Minute))
+
+	w := getStats(t, router)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Regexp(t, `^max-age=(59|60)$`, w.Header().Get("Cache-Control"))
+
+	resp := stats{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, int64(2), resp.Count)
+	assert.Equal(t, int64(300), resp.Size)
+	assert.Equal(t, int64(150), resp.AverageSize)
+	assert.Equal(t, map[string]int64{"application/json": 2}, resp.ContentTypes)
+	assert.Equal(t, map[string]int64{"methode-web-pub": 2}, resp.OriginSystemIDs)
+	assert.Equal(t, map[string]int64{"uuid-index": 4096}, resp.Indexes)
+	assert.True(t, oldest.Equal(*resp.OldestModified))
+	assert.True(t, oldest.Add(time.Hour).Equal(*resp.NewestModified))
+	assert.Nil(t, resp.Compression, "no document is compressed")
+
+	w = getStats(t, router)
+	assert.Equal(t, http.StatusOK, w.Code)
+	connection.AssertNumberOfCalls(t, "Stats", 1)
+}
+
+func TestReadStatsExpires(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Stats", "methode").Return(&db.Stats{}, nil)
+
+	router := statsRouter(mongo, newStatsCache(time.Millisecond))
+
+	assert.Equal(t, http.StatusOK, getStats(t, router).Code)
+	time.Sleep(5 * time.Millisecond)
+
+	w := getStats(t, router)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "oldestModified")
+	connection.AssertNumberOfCalls(t, "Stats", 2)
+}
+
+func TestReadStatsOfASlowCollection(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	slow := make(chan time.Time)
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Stats", "universal-content").WaitUntil(slow).Return(&db.Stats{}, nil)
+	connection.On("Stats", "methode").Return(&db.Stats{}, nil)
+
+	router := statsRouter(mongo, newStatsCache(time.Minute))
+
+	done := make(chan int)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- getCollectionStats(t, router, "universal-content").Code
+		}()
+	}
+
+	assert.Equal(t, http.StatusOK, getStats(t, router).Code, "the other collections are served while the slow one is computed")
+
+	close(slow)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, http.StatusOK, <-done)
+	connection.AssertNumberOfCalls(t, "Stats", 2)
+}
+
+func TestReadCompressionStats(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Stats", "methode").Return(&db.Stats{Count: 3, Size: 900, Compressed: 2, CompressedSize: 300, UncompressedSize: 1000}, nil)
+
+	w := getStats(t, statsRouter(mongo, newStatsCache(time.Minute)))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	resp := stats{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, &compressionStats{Count: 2, CompressedSize: 300, UncompressedSize: 1000, Ratio: 3.33}, resp.Compression)
+}
+
+func TestReadEstimatedStats(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Stats", "methode").Return(&db.Stats{Count: 2000000, Size: 600000000, AverageSize: 300, Estimated: true}, nil)
+
+	w := getStats(t, statsRouter(mongo, newStatsCache(time.Minute)))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	resp := stats{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.Estimated)
+	assert.Equal(t, int64(2000000), resp.Count)
+}
+
+func TestReadStatsFails(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Stats", "methode").Return((*db.Stats)(nil), errors.New("oh no"))
+
+	w := getStats(t, statsRouter(mongo, newStatsCache(time.Minute)))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestReadStatsMongoOpenFails(t *testing.T) {
+	mongo := new(MockDB)
+	mongo.On("Open").Return(nil, errors.New("no data 4 u"))
+
+	w := getStats(t, statsRouter(mongo, newStatsCache(time.Minute)))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
@@ -0,0 +1,285 @@
+package resources
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/db"
+	"github.com/Financial-Times/nativerw/pkg/outbox"
+)
+
+type subscription struct {
+	ID             string    `json:"id"`
+	Collection     string    `json:"collection"`
+	URL            string    `json:"url"`
+	OriginSystemID string    `json:"originSystemId,omitempty"`
+	Secret         string    `json:"secret,omitempty"`
+	Created        time.Time `json:"created"`
+}
+
+func newSubscription(s *db.Subscription) subscription {
+	return subscription{ID: s.ID, Collection: s.Collection, URL: s.URL, OriginSystemID: s.OriginSystemID, Created: s.Created}
+}
+
+type delivery struct {
+	Token      string    `json:"token"`
+	UUID       string    `json:"uuid"`
+	Operation  string    `json:"operation"`
+	Status     string    `json:"status"`
+	Attempts   int       `json:"attempts"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// CreateSubscription registers a webhook for the changes of a collection, which are delivered from the time it is created. The
+// payloads are signed with the given secret, or with a generated one returned in the response, which is the only time it is.
+// Webhooks which are, or resolve to, internal addresses are rejected, unless the targets allow their host.
+func CreateSubscription(mongo db.DB, targets *outbox.WebhookTargets) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		tid := obtainTxID(r)
+
+		req := subscription{}
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, fmt.Sprintf("Invalid subscription: %v", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		if !connection.GetSupportedCollections()[req.Collection] {
+			writeMessage(w, fmt.Sprintf("Collection %q is not supported", req.Collection), http.StatusBadRequest)
+			return
+		}
+
+		if u, uErr := url.Parse(req.URL); uErr != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			writeMessage(w, fmt.Sprintf("Invalid webhook url %q, it should be an absolute http(s) url", req.URL), http.StatusBadRequest)
+			return
+		}
+
+		if err = targets.Check(r.Context(), req.URL); err != nil {
+			writeMessage(w, fmt.Sprintf("Invalid webhook url %q: %v", req.URL, err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		if req.Secret == "" {
+			if req.Secret, err = generateSecret(); err != nil {
+				writeMessage(w, "Failed to generate a secret", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		since, err := connection.LastSequence(req.Collection)
+		if err != nil {
+			msg := "Reading the change feed from mongoDB failed"
+			logger.WithTransactionID(tid).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf("%s\n%v\n", msg, err), http.StatusInternalServerError)
+			return
+		}
+
+		created := &db.Subscription{
+			ID:             uuid.New(),
+			Collection:     req.Collection,
+			URL:            req.URL,
+			OriginSystemID: req.OriginSystemID,
+			Secret:         req.Secret,
+			Since:          since,
+			Created:        time.Now().UTC().Truncate(time.Millisecond),
+		}
+
+		if err = connection.CreateSubscription(created); err != nil {
+			msg := "Creating the subscription in mongoDB failed"
+			logger.WithTransactionID(tid).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf("%s\n%v\n", msg, err), http.StatusInternalServerError)
+			return
+		}
+
+		logger.WithTransactionID(tid).Infof("Created subscription %s to collection %s for %s", created.ID, created.Collection, created.URL)
+
+		resp := newSubscription(created)
+		resp.Secret = created.Secret
+
+		w.Header().Set("Location", "/__subscriptions/"+created.ID)
+		writeJSON(w, tid, resp, http.StatusCreated)
+	}
+}
+
+// ReadSubscriptions lists the subscriptions, oldest first
+func ReadSubscriptions(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		tid := obtainTxID(r)
+
+		subscriptions, err := connection.ReadSubscriptions()
+		if err != nil {
+			msg := "Reading subscriptions from mongoDB failed."
+			logger.WithTransactionID(tid).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf(msg+": %v", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		resp := make([]subscription, 0, len(subscriptions))
+		for _, s := range subscriptions {
+			resp = append(resp, newSubscription(s))
+		}
+		writeJSON(w, tid, resp, http.StatusOK)
+	}
+}
+
+// ReadSubscription responds with the subscription, without its secret
+func ReadSubscription(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		tid := obtainTxID(r)
+		id := mux.Vars(r)["id"]
+
+		s, found, err := connection.ReadSubscription(id)
+		if err != nil {
+			msg := "Reading subscription from mongoDB failed."
+			logger.WithTransactionID(tid).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf(msg+": %v", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		if !found {
+			writeMessage(w, fmt.Sprintf("Subscription not found, id= %v", id), http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, tid, newSubscription(s), http.StatusOK)
+	}
+}
+
+// DeleteSubscription stops the deliveries to the webhook of the subscription, and drops their records
+func DeleteSubscription(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		tid := obtainTxID(r)
+		id := mux.Vars(r)["id"]
+
+		err = connection.DeleteSubscription(id)
+		if err == db.ErrNotFound {
+			writeMessage(w, fmt.Sprintf("Subscription not found, id= %v", id), http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			msg := "Deleting subscription from mongoDB failed"
+			logger.WithTransactionID(tid).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf("%s\n%v\n", msg, err), http.StatusInternalServerError)
+			return
+		}
+
+		logger.WithTransactionID(tid).Infof("Deleted subscription %s", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ReadDeliveries lists the recent deliveries and the dead letters of the subscription, newest first. The status parameter
+// narrows them down to either delivered or dead-letter.
+func ReadDeliveries(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		tid := obtainTxID(r)
+		id := mux.Vars(r)["id"]
+
+		status := r.URL.Query().Get("status")
+		if status != "" && status != db.DeliveryDelivered && status != db.DeliveryDeadLetter {
+			writeMessage(w, fmt.Sprintf("Invalid status %q, it should be %s or %s", status, db.DeliveryDelivered, db.DeliveryDeadLetter), http.StatusBadRequest)
+			return
+		}
+
+		_, found, err := connection.ReadSubscription(id)
+		if err == nil && !found {
+			writeMessage(w, fmt.Sprintf("Subscription not found, id= %v", id), http.StatusNotFound)
+			return
+		}
+
+		var deliveries []*db.Delivery
+		if err == nil {
+			deliveries, err = connection.ReadDeliveries(id, status)
+		}
+
+		if err != nil {
+			msg := "Reading deliveries from mongoDB failed."
+			logger.WithTransactionID(tid).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf(msg+": %v", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		resp := make([]delivery, 0, len(deliveries))
+		for _, d := range deliveries {
+			resp = append(resp, delivery{
+				Token:      strconv.FormatInt(d.Sequence, 10),
+				UUID:       d.UUID,
+				Operation:  d.Operation,
+				Status:     d.Status,
+				Attempts:   d.Attempts,
+				StatusCode: d.StatusCode,
+				Error:      d.Error,
+				Timestamp:  d.Timestamp,
+			})
+		}
+		writeJSON(w, tid, resp, http.StatusOK)
+	}
+}
+
+func generateSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func writeJSON(w http.ResponseWriter, tid string, resp interface{}, status int) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.WithTransactionID(tid).WithError(err).Error("could not build response JSON body")
+	}
+}
@@ -0,0 +1,254 @@
+package resources
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Financial-Times/nativerw/pkg/db"
+	"github.com/Financial-Times/nativerw/pkg/outbox"
+)
+
+func subscriptionsRouter(mongo db.DB) *mux.Router {
+	// allowing example.com spares the tests a DNS lookup
+	targets := outbox.NewWebhookTargets([]string{"example.com"})
+
+	router := mux.NewRouter()
+	router.HandleFunc("/__subscriptions", CreateSubscription(mongo, targets)).Methods("POST")
+	router.HandleFunc("/__subscriptions", ReadSubscriptions(mongo)).Methods("GET")
+	router.HandleFunc("/__subscriptions/{id}", ReadSubscription(mongo)).Methods("GET")
+	router.HandleFunc("/__subscriptions/{id}", DeleteSubscription(mongo)).Methods("DELETE")
+	router.HandleFunc("/__subscriptions/{id}/deliveries", ReadDeliveries(mongo)).Methods("GET")
+	return router
+}
+
+func TestCreateSubscription(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("GetSupportedCollections").Return(map[string]bool{"methode": true})
+	connection.On("LastSequence", "methode").Return(int64(42), nil)
+	connection.On("CreateSubscription", mock.MatchedBy(func(s *db.Subscription) bool {
+		return s.ID != "" && s.Collection == "methode" && s.URL == "https://example.com/hook" && s.OriginSystemID == "methode-web-pub" && s.Secret == "s3cret" && s.Since == 42
+	})).Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/__subscriptions", strings.NewReader(`{"collection":"methode","url":"https://example.com/hook","originSystemId":"methode-web-pub","secret":"s3cret"}`))
+
+	subscriptionsRouter(mongo).ServeHTTP(w, req)
+
+	mongo.AssertExpectations(t)
+	connection.AssertExpectations(t)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	resp := subscription{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "/__subscriptions/"+resp.ID, w.Header().Get("Location"))
+	assert.Equal(t, "methode", resp.Collection)
+	assert.Equal(t, "s3cret", resp.Secret)
+}
+
+func TestCreateSubscriptionGeneratesSecret(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("GetSupportedCollections").Return(map[string]bool{"methode": true})
+	connection.On("LastSequence", "methode").Return(int64(0), nil)
+	connection.On("CreateSubscription", mock.AnythingOfType("*db.Subscription")).Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/__subscriptions", strings.NewReader(`{"collection":"methode","url":"http://example.com/hook"}`))
+
+	subscriptionsRouter(mongo).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	resp := subscription{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Secret, 64)
+
+	stored := connection.Calls[2].Arguments.Get(0).(*db.Subscription)
+	assert.Equal(t, resp.Secret, stored.Secret)
+}
+
+func TestCreateSubscriptionInvalid(t *testing.T) {
+	var tests = []struct {
+		name string
+		body string
+	}{
+		{"not json", `not json`},
+		{"unsupported collection", `{"collection":"wordpress","url":"https://example.com/hook"}`},
+		{"missing url", `{"collection":"methode"}`},
+		{"relative url", `{"collection":"methode","url":"/hook"}`},
+		{"unsupported scheme", `{"collection":"methode","url":"ftp://example.com/hook"}`},
+		{"metadata endpoint", `{"collection":"methode","url":"http://169.254.169.254/latest/meta-data"}`},
+		{"loopback", `{"collection":"methode","url":"http://127.0.0.1:8080/hook"}`},
+		{"private", `{"collection":"methode","url":"https://10.1.2.3/hook"}`},
+		{"ipv6 loopback", `{"collection":"methode","url":"http://[::1]/hook"}`},
+		{"ipv4 mapped", `{"collection":"methode","url":"http://[::ffff:192.168.0.1]/hook"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mongo := new(MockDB)
+			connection := new(MockConnection)
+
+			mongo.On("Open").Return(connection, nil)
+			connection.On("GetSupportedCollections").Return(map[string]bool{"methode": true})
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/__subscriptions", strings.NewReader(test.body))
+
+			subscriptionsRouter(mongo).ServeHTTP(w, req)
+
+			connection.AssertNotCalled(t, "CreateSubscription", mock.Anything)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestCreateSubscriptionFails(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("GetSupportedCollections").Return(map[string]bool{"methode": true})
+	connection.On("LastSequence", "methode").Return(int64(0), nil)
+	connection.On("CreateSubscription", mock.AnythingOfType("*db.Subscription")).Return(errors.New("oh no"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/__subscriptions", strings.NewReader(`{"collection":"methode","url":"http://example.com/hook"}`))
+
+	subscriptionsRouter(mongo).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestReadSubscriptionsHidesSecrets(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	created := time.Date(2020, 3, 4, 10, 30, 15, 0, time.UTC)
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadSubscriptions").Return([]*db.Subscription{{ID: "an-id", Collection: "methode", URL: "https://example.com/hook", Secret: "s3cret", Since: 4, Created: created}}, nil)
+	connection.On("ReadSubscription", "an-id").Return(&db.Subscription{ID: "an-id", Collection: "methode", URL: "https://example.com/hook", Secret: "s3cret", Since: 4, Created: created}, true, nil)
+
+	router := subscriptionsRouter(mongo)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/__subscriptions", http.NoBody)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `[{"id":"an-id","collection":"methode","url":"https://example.com/hook","created":"2020-03-04T10:30:15Z"}]`, strings.TrimSpace(w.Body.String()))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/__subscriptions/an-id", http.NoBody)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"id":"an-id","collection":"methode","url":"https://example.com/hook","created":"2020-03-04T10:30:15Z"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestReadSubscriptionNotFound(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadSubscription", "an-id").Return((*db.Subscription)(nil), false, nil)
+
+	router := subscriptionsRouter(mongo)
+
+	for _, path := range []string{"/__subscriptions/an-id", "/__subscriptions/an-id/deliveries"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, http.NoBody)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+}
+
+func TestDeleteSubscription(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("DeleteSubscription", "an-id").Return(nil)
+	connection.On("DeleteSubscription", "another-id").Return(db.ErrNotFound)
+
+	router := subscriptionsRouter(mongo)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/__subscriptions/an-id", http.NoBody)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/__subscriptions/another-id", http.NoBody)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestReadDeliveries(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	ts := time.Date(2020, 3, 4, 10, 30, 15, 0, time.UTC)
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadSubscription", "an-id").Return(&db.Subscription{ID: "an-id"}, true, nil)
+	connection.On("ReadDeliveries", "an-id", db.DeliveryDeadLetter).Return([]*db.Delivery{
+		{Subscription: "an-id", Sequence: 7, UUID: "a-real-uuid", Operation: db.OperationPut, Status: db.DeliveryDeadLetter, Attempts: 5, StatusCode: 503, Error: "webhook responded with status 503", Timestamp: ts},
+	}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/__subscriptions/an-id/deliveries?status=dead-letter", http.NoBody)
+	subscriptionsRouter(mongo).ServeHTTP(w, req)
+
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `[{"token":"7","uuid":"a-real-uuid","operation":"put","status":"dead-letter","attempts":5,"statusCode":503,"error":"webhook responded with status 503","timestamp":"2020-03-04T10:30:15Z"}]`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestReadDeliveriesInvalidStatus(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+	mongo.On("Open").Return(connection, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/__subscriptions/an-id/deliveries?status=failed", http.NoBody)
+	subscriptionsRouter(mongo).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSubscriptionsMongoOpenFails(t *testing.T) {
+	mongo := new(MockDB)
+	mongo.On("Open").Return(nil, errors.New("no data 4 u"))
+
+	router := subscriptionsRouter(mongo)
+	for _, r := range []struct{ method, path string }{
+		{"POST", "/__subscriptions"},
+		{"GET", "/__subscriptions"},
+		{"GET", "/__subscriptions/an-id"},
+		{"DELETE", "/__subscriptions/an-id"},
+		{"GET", "/__subscriptions/an-id/deliveries"},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(r.method, r.path, http.NoBody)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, r.method+" "+r.path)
+	}
+}
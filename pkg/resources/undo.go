@@ -0,0 +1,99 @@
+package resources
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/db"
+)
+
+// UndoContent restores an earlier revision of the native document as the current one. The restore is a write like any other,
+// so it gets recorded as a new revision and can be undone in turn.
+func UndoContent(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		tid := obtainTxID(r)
+		collectionID := mux.Vars(r)["collection"]
+		resourceID := mux.Vars(r)["resource"]
+
+		steps := 1
+		if s := r.URL.Query().Get("steps"); s != "" {
+			steps, err = strconv.Atoi(s)
+			if err != nil || steps < 1 {
+				writeMessage(w, fmt.Sprintf("Invalid steps (%v), it should be a positive number", s), http.StatusBadRequest)
+				return
+			}
+		}
+
+		versions, err := connection.ReadVersions(collectionID, resourceID)
+		if err != nil {
+			msg := "Reading versions from mongoDB failed."
+			logger.WithTransactionID(tid).WithUUID(resourceID).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf(msg+": %v", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		if len(versions) == 0 {
+			msg := fmt.Sprintf("No history retained for resource, collection= %v, id= %v", collectionID, resourceID)
+			logger.WithTransactionID(tid).WithUUID(resourceID).Info(msg)
+			writeMessage(w, msg, http.StatusNotFound)
+			return
+		}
+
+		if steps >= len(versions) {
+			msg := fmt.Sprintf("Cannot undo %d step(s), only %d earlier revision(s) retained, collection= %v, id= %v", steps, len(versions)-1, collectionID, resourceID)
+			logger.WithTransactionID(tid).WithUUID(resourceID).Info(msg)
+			writeMessage(w, msg, http.StatusConflict)
+			return
+		}
+
+		target := versions[steps].Version
+		resource, found, err := connection.ReadVersion(collectionID, resourceID, target)
+		if err != nil {
+			msg := "Reading version from mongoDB failed."
+			logger.WithTransactionID(tid).WithUUID(resourceID).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf(msg+": %v", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		if !found {
+			msg := fmt.Sprintf("Version %d has been dropped from the history in the meantime, collection= %v, id= %v", target, collectionID, resourceID)
+			logger.WithTransactionID(tid).WithUUID(resourceID).Info(msg)
+			writeMessage(w, msg, http.StatusConflict)
+			return
+		}
+
+		// the undo is relative to the latest revision, so it must not go ahead if another write got there first
+		resource.TransactionID = tid
+		err = connection.Write(collectionID, resource, db.Precondition{IfMatch: []int64{versions[0].Version}}, nil)
+		if err == db.ErrPreconditionFailed {
+			msg := fmt.Sprintf("Resource has been modified in the meantime, collection= %v, id= %v", collectionID, resourceID)
+			logger.WithTransactionID(tid).WithUUID(resourceID).Info(msg)
+			writeMessage(w, msg, http.StatusConflict)
+			return
+		}
+
+		if err != nil {
+			msg := "Writing to mongoDB failed"
+			logger.WithMonitoringEvent("SaveToNative", tid, resource.ContentType).WithUUID(resourceID).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf("%s\n%v\n", msg, err), http.StatusInternalServerError)
+			return
+		}
+
+		logger.WithMonitoringEvent("SaveToNative", tid, resource.ContentType).WithUUID(resourceID).Info(fmt.Sprintf("Successfully restored version %d, collection=%s, origin-system-id=%s",
+			target, collectionID, resource.OriginSystemID))
+
+		writeResource(w, resource, tid, resourceID)
+	}
+}
@@ -0,0 +1,151 @@
+package resources
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Financial-Times/nativerw/pkg/db"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+var undoVersions = []*db.Version{{Version: 3}, {Version: 2}, {Version: 1}}
+
+func undoRouter(mongo *MockDB) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}/__undo", UndoContent(mongo)).Methods("POST")
+	return router
+}
+
+func TestUndoContent(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadVersions", "methode", "a-real-uuid").Return(undoVersions, nil)
+	connection.On("ReadVersion", "methode", "a-real-uuid", int64(2)).Return(&mapper.Resource{UUID: "a-real-uuid", Content: map[string]interface{}{"title": "previous"}, ContentType: "application/json", OriginSystemID: "methode-web-pub", TransactionID: "tid_previous"}, true, nil)
+	connection.On("Write", "methode", &mapper.Resource{UUID: "a-real-uuid", Content: map[string]interface{}{"title": "previous"}, ContentType: "application/json", OriginSystemID: "methode-web-pub", TransactionID: testTxID}, db.Precondition{IfMatch: []int64{3}}, noValidator).Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/a-real-uuid/__undo", http.NoBody)
+	req.Header.Add("X-Request-Id", testTxID)
+
+	undoRouter(mongo).ServeHTTP(w, req)
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"title":"previous"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestUndoContentSteps(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadVersions", "methode", "a-real-uuid").Return(undoVersions, nil)
+	connection.On("ReadVersion", "methode", "a-real-uuid", int64(1)).Return(&mapper.Resource{UUID: "a-real-uuid", Content: []byte("first"), ContentType: "application/octet-stream"}, true, nil)
+	connection.On("Write", "methode", &mapper.Resource{UUID: "a-real-uuid", Content: []byte("first"), ContentType: "application/octet-stream", TransactionID: testTxID}, db.Precondition{IfMatch: []int64{3}}, noValidator).Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/a-real-uuid/__undo?steps=2", http.NoBody)
+	req.Header.Add("X-Request-Id", testTxID)
+
+	undoRouter(mongo).ServeHTTP(w, req)
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "first", w.Body.String())
+}
+
+func TestUndoContentTooManySteps(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadVersions", "methode", "a-real-uuid").Return(undoVersions, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/a-real-uuid/__undo?steps=3", http.NoBody)
+
+	undoRouter(mongo).ServeHTTP(w, req)
+	connection.AssertNotCalled(t, "Write")
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestUndoContentInvalidSteps(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+
+	for _, steps := range []string{"0", "-2", "many"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/methode/a-real-uuid/__undo?steps="+steps, http.NoBody)
+
+		undoRouter(mongo).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+	connection.AssertNotCalled(t, "ReadVersions")
+}
+
+func TestUndoContentWithoutHistory(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadVersions", "methode", "a-real-uuid").Return([]*db.Version{}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/a-real-uuid/__undo", http.NoBody)
+
+	undoRouter(mongo).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUndoContentVersionDropped(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadVersions", "methode", "a-real-uuid").Return(undoVersions, nil)
+	connection.On("ReadVersion", "methode", "a-real-uuid", int64(2)).Return((*mapper.Resource)(nil), false, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/a-real-uuid/__undo", http.NoBody)
+
+	undoRouter(mongo).ServeHTTP(w, req)
+	connection.AssertNotCalled(t, "Write")
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestUndoContentWriteFails(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadVersions", "methode", "a-real-uuid").Return(undoVersions, nil)
+	connection.On("ReadVersion", "methode", "a-real-uuid", int64(2)).Return(&mapper.Resource{UUID: "a-real-uuid", Content: map[string]interface{}{}, ContentType: "application/json"}, true, nil)
+	connection.On("Write", "methode", &mapper.Resource{UUID: "a-real-uuid", Content: map[string]interface{}{}, ContentType: "application/json", TransactionID: testTxID}, db.Precondition{IfMatch: []int64{3}}, noValidator).Return(errors.New("i failed"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/a-real-uuid/__undo", http.NoBody)
+	req.Header.Add("X-Request-Id", testTxID)
+
+	undoRouter(mongo).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestUndoContentMongoOpenFails(t *testing.T) {
+	mongo := new(MockDB)
+	mongo.On("Open").Return(nil, errors.New("no data 4 u"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/methode/a-real-uuid/__undo", http.NoBody)
+
+	undoRouter(mongo).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
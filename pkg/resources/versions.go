@@ -0,0 +1,114 @@
+package resources
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/nativerw/pkg/db"
+)
+
+type version struct {
+	Version        int64     `json:"version"`
+	Timestamp      time.Time `json:"timestamp"`
+	Hash           string    `json:"hash"`
+	ContentType    string    `json:"contentType"`
+	OriginSystemID string    `json:"originSystemId"`
+	TransactionID  string    `json:"transactionId"`
+}
+
+// ReadVersions lists the retained revisions of the given native document, newest first
+func ReadVersions(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		tid := obtainTxID(r)
+		vars := mux.Vars(r)
+		resourceID := vars["resource"]
+		collection := vars["collection"]
+
+		versions, err := connection.ReadVersions(collection, resourceID)
+		if err != nil {
+			msg := "Reading versions from mongoDB failed."
+			logger.WithTransactionID(tid).WithUUID(resourceID).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf(msg+": %v", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		if len(versions) == 0 {
+			msg := fmt.Sprintf("No versions found, collection= %v, id= %v", collection, resourceID)
+			logger.WithTransactionID(tid).WithUUID(resourceID).Info(msg)
+			writeMessage(w, msg, http.StatusNotFound)
+			return
+		}
+
+		resp := make([]version, 0, len(versions))
+		for _, v := range versions {
+			resp = append(resp, version{
+				Version:        v.Version,
+				Timestamp:      v.Timestamp,
+				Hash:           v.Hash,
+				ContentType:    v.ContentType,
+				OriginSystemID: v.OriginSystemID,
+				TransactionID:  v.TransactionID,
+			})
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(resp); err != nil {
+			logger.WithTransactionID(tid).WithUUID(resourceID).WithError(err).Error("could not build response JSON body")
+		}
+	}
+}
+
+// ReadVersion reads the native data of the given revision of a document
+func ReadVersion(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		connection, err := mongo.Open()
+		if err != nil {
+			writeMessage(w, "Failed to connect to the database!", http.StatusServiceUnavailable)
+			return
+		}
+
+		tid := obtainTxID(r)
+		vars := mux.Vars(r)
+		resourceID := vars["resource"]
+		collection := vars["collection"]
+
+		n, err := strconv.ParseInt(vars["version"], 10, 64)
+		if err != nil || n < 1 {
+			writeMessage(w, fmt.Sprintf("Invalid version (%v), it should be a positive number", vars["version"]), http.StatusBadRequest)
+			return
+		}
+
+		resource, found, err := connection.ReadVersion(collection, resourceID, n)
+		if err != nil {
+			msg := "Reading version from mongoDB failed."
+			logger.WithTransactionID(tid).WithUUID(resourceID).WithError(err).Error(msg)
+			http.Error(w, fmt.Sprintf(msg+": %v", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		if !found {
+			msg := fmt.Sprintf("Version not found, collection= %v, id= %v, version= %v", collection, resourceID, n)
+			logger.WithTransactionID(tid).WithUUID(resourceID).Info(msg)
+			writeMessage(w, msg, http.StatusNotFound)
+			return
+		}
+
+		writeResource(w, resource, tid, resourceID)
+	}
+}
@@ -0,0 +1,152 @@
+package resources
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Financial-Times/nativerw/pkg/db"
+	"github.com/Financial-Times/nativerw/pkg/mapper"
+)
+
+func TestReadVersions(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	timestamp := time.Date(2019, 3, 1, 10, 30, 0, 0, time.UTC)
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadVersions", "methode", "a-real-uuid").Return([]*db.Version{
+		{Version: 2, Timestamp: timestamp, Hash: "hash-2", ContentType: "application/json", OriginSystemID: "methode-web-pub", TransactionID: "tid_2"},
+		{Version: 1, Timestamp: timestamp.Add(-time.Hour), Hash: "hash-1", ContentType: "application/json", OriginSystemID: "methode-web-pub", TransactionID: "tid_1"},
+	}, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}/__versions", ReadVersions(mongo)).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/methode/a-real-uuid/__versions", http.NoBody)
+
+	router.ServeHTTP(w, req)
+	mongo.AssertExpectations(t)
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `[
+		{"version":2,"timestamp":"2019-03-01T10:30:00Z","hash":"hash-2","contentType":"application/json","originSystemId":"methode-web-pub","transactionId":"tid_2"},
+		{"version":1,"timestamp":"2019-03-01T09:30:00Z","hash":"hash-1","contentType":"application/json","originSystemId":"methode-web-pub","transactionId":"tid_1"}
+	]`, w.Body.String())
+}
+
+func TestReadVersionsNotFound(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadVersions", "methode", "a-real-uuid").Return([]*db.Version{}, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}/__versions", ReadVersions(mongo)).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/methode/a-real-uuid/__versions", http.NoBody)
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestReadVersionsFailed(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadVersions", "methode", "a-real-uuid").Return([]*db.Version(nil), errors.New("i failed"))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}/__versions", ReadVersions(mongo)).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/methode/a-real-uuid/__versions", http.NoBody)
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestReadVersion(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadVersion", "methode", "a-real-uuid", int64(3)).Return(&mapper.Resource{ContentType: "application/json", OriginSystemID: "methode-web-pub", Content: map[string]interface{}{"title": "older"}}, true, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}/__versions/{version}", ReadVersion(mongo)).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/methode/a-real-uuid/__versions/3", http.NoBody)
+
+	router.ServeHTTP(w, req)
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "methode-web-pub", w.Header().Get("Origin-System-Id"))
+	assert.Equal(t, `{"title":"older"}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestReadVersionNotFound(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("ReadVersion", "methode", "a-real-uuid", int64(3)).Return((*mapper.Resource)(nil), false, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}/__versions/{version}", ReadVersion(mongo)).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/methode/a-real-uuid/__versions/3", http.NoBody)
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestReadVersionInvalidNumber(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}/__versions/{version}", ReadVersion(mongo)).Methods("GET")
+
+	for _, version := range []string{"latest", "0", "-1"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/methode/a-real-uuid/__versions/"+version, http.NoBody)
+
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+	connection.AssertNotCalled(t, "ReadVersion")
+}
+
+func TestReadVersionMongoOpenFails(t *testing.T) {
+	mongo := new(MockDB)
+	mongo.On("Open").Return(nil, errors.New("no data 4 u"))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}/__versions/{version}", ReadVersion(mongo)).Methods("GET")
+	router.HandleFunc("/{collection}/{resource}/__versions", ReadVersions(mongo)).Methods("GET")
+
+	for _, path := range []string{"/methode/a-real-uuid/__versions", "/methode/a-real-uuid/__versions/1"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, http.NoBody)
+
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	}
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/Financial-Times/nativerw/pkg/mapper"
 )
 
-// WriteContent writes a new native record
+// WriteContent writes a new native record. Octet streams are streamed into storage rather than read in memory first.
 func WriteContent(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
@@ -28,7 +28,7 @@ func WriteContent(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
 
 		contentTypeHeader := extractAttrFromHeader(r, "Content-Type", "application/octet-stream", tid, resourceID)
 
-		inMapper, err := mapper.InMapperForContentType(contentTypeHeader)
+		inMapper, err := mapper.StreamingInMapperForContentType(contentTypeHeader)
 		if err != nil {
 			msg := "Unsupported content-type"
 			logger.WithMonitoringEvent("SaveToNative", tid, contentTypeHeader).WithUUID(resourceID).WithError(err).Error(msg)
@@ -38,6 +38,12 @@ func WriteContent(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
 
 		originSystemIDHeader := extractAttrFromHeader(r, "Origin-System-Id", "", tid, resourceID)
 		content, err := inMapper(r.Body)
+		if v, ok := bodyViolation(err); ok {
+			logger.WithMonitoringEvent("SaveToNative", tid, contentTypeHeader).WithUUID(resourceID).Warn(v.msg)
+			writeMessage(w, v.msg, v.status)
+			return
+		}
+
 		if err != nil {
 			msg := "Extracting content from HTTP body failed"
 			logger.WithMonitoringEvent("SaveToNative", tid, contentTypeHeader).WithUUID(resourceID).WithError(err).Error(msg)
@@ -46,14 +52,44 @@ func WriteContent(mongo db.DB) func(w http.ResponseWriter, r *http.Request) {
 		}
 
 		wrappedContent := mapper.Wrap(content, resourceID, contentTypeHeader, originSystemIDHeader)
+		wrappedContent.TransactionID = tid
+
+		check := &schemaCheck{policy: policyFromContext(r.Context()), collection: collectionID}
+		precondition := preconditionFromRequest(r)
 
-		if err := connection.Write(collectionID, wrappedContent); err != nil {
+		err = connection.Write(collectionID, wrappedContent, precondition, check.validator())
+		if err == errSchemaViolations {
+			check.reject(w, tid, resourceID)
+			return
+		}
+
+		// octet streams are only read as they are stored, so they can turn out to be too large then
+		if v, ok := bodyViolation(err); ok {
+			logger.WithMonitoringEvent("SaveToNative", tid, contentTypeHeader).WithUUID(resourceID).Warn(v.msg)
+			writeMessage(w, v.msg, v.status)
+			return
+		}
+
+		if err == db.ErrPreconditionFailed {
+			msg := "Precondition failed, the resource has been modified in the meantime"
+			logger.WithMonitoringEvent("SaveToNative", tid, contentTypeHeader).WithUUID(resourceID).Info(msg)
+			writeMessage(w, msg, http.StatusPreconditionFailed)
+			return
+		}
+
+		if err != nil {
 			msg := "Writing to mongoDB failed"
 			logger.WithMonitoringEvent("SaveToNative", tid, contentTypeHeader).WithUUID(resourceID).WithError(err).Error(msg)
 			http.Error(w, fmt.Sprintf("%s\n%v\n", msg, err), http.StatusInternalServerError)
 			return
 		}
 
+		check.warn(tid, resourceID)
+		w.Header().Set("ETag", etag(wrappedContent.Revision))
+		w.Header().Set(nativeHashHeader, wrappedContent.Hash)
+		if !wrappedContent.LastModified.IsZero() {
+			w.Header().Set("Last-Modified", lastModified(wrappedContent).Format(http.TimeFormat))
+		}
 		logger.WithMonitoringEvent("SaveToNative", tid, contentTypeHeader).WithUUID(resourceID).Info(fmt.Sprintf("Successfully saved, collection=%s, origin-system-id=%s",
 			collectionID, originSystemIDHeader))
 	}
@@ -10,7 +10,9 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 
+	"github.com/Financial-Times/nativerw/pkg/db"
 	"github.com/Financial-Times/nativerw/pkg/mapper"
 )
 
@@ -19,7 +21,7 @@ func TestWriteContent(t *testing.T) {
 	connection := new(MockConnection)
 
 	mongo.On("Open").Return(connection, nil)
-	connection.On("Write", "methode", &mapper.Resource{UUID: "a-real-uuid", Content: map[string]interface{}{}, ContentType: "application/json"}).Return(nil)
+	connection.On("Write", "methode", &mapper.Resource{UUID: "a-real-uuid", Content: map[string]interface{}{}, ContentType: "application/json", TransactionID: testTxID}, db.Precondition{}, noValidator).Return(nil)
 
 	router := mux.NewRouter()
 	router.HandleFunc("/{collection}/{resource}", WriteContent(mongo)).Methods("PUT")
@@ -28,6 +30,7 @@ func TestWriteContent(t *testing.T) {
 	req, _ := http.NewRequest("PUT", "/methode/a-real-uuid", strings.NewReader(`{}`))
 
 	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Request-Id", testTxID)
 
 	router.ServeHTTP(w, req)
 	mongo.AssertExpectations(t)
@@ -43,9 +46,10 @@ func TestWriteContentWithCharsetDirective(t *testing.T) {
 	connection.On("Write",
 		"methode",
 		&mapper.Resource{
-			UUID:        "a-real-uuid",
-			Content:     map[string]interface{}{},
-			ContentType: "application/json; charset=utf-8"}).
+			UUID:          "a-real-uuid",
+			Content:       map[string]interface{}{},
+			ContentType:   "application/json; charset=utf-8",
+			TransactionID: testTxID}, db.Precondition{}, noValidator).
 		Return(nil)
 
 	router := mux.NewRouter()
@@ -55,6 +59,7 @@ func TestWriteContentWithCharsetDirective(t *testing.T) {
 	req, _ := http.NewRequest("PUT", "/methode/a-real-uuid", strings.NewReader(`{}`))
 
 	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+	req.Header.Add("X-Request-Id", testTxID)
 
 	router.ServeHTTP(w, req)
 	mongo.AssertExpectations(t)
@@ -66,7 +71,7 @@ func TestWriteFailed(t *testing.T) {
 	connection := new(MockConnection)
 
 	mongo.On("Open").Return(connection, nil)
-	connection.On("Write", "methode", &mapper.Resource{UUID: "a-real-uuid", Content: map[string]interface{}{}, ContentType: "application/json"}).Return(errors.New("i failed"))
+	connection.On("Write", "methode", &mapper.Resource{UUID: "a-real-uuid", Content: map[string]interface{}{}, ContentType: "application/json", TransactionID: testTxID}, db.Precondition{}, noValidator).Return(errors.New("i failed"))
 
 	router := mux.NewRouter()
 	router.HandleFunc("/{collection}/{resource}", WriteContent(mongo)).Methods("PUT")
@@ -75,6 +80,7 @@ func TestWriteFailed(t *testing.T) {
 	req, _ := http.NewRequest("PUT", "/methode/a-real-uuid", strings.NewReader(`{}`))
 
 	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Request-Id", testTxID)
 
 	router.ServeHTTP(w, req)
 	mongo.AssertExpectations(t)
@@ -92,7 +98,7 @@ func TestDefaultsToBinaryMapping(t *testing.T) {
 	content, err := inMapper(ioutil.NopCloser(strings.NewReader(`{}`)))
 	assert.NoError(t, err)
 
-	connection.On("Write", "methode", &mapper.Resource{UUID: "a-real-uuid", Content: content, ContentType: "application/octet-stream"}).Return(errors.New("i failed"))
+	connection.On("Write", "methode", &mapper.Resource{UUID: "a-real-uuid", Content: content, ContentType: "application/octet-stream", TransactionID: testTxID}, db.Precondition{}, noValidator).Return(errors.New("i failed"))
 
 	router := mux.NewRouter()
 	router.HandleFunc("/{collection}/{resource}", WriteContent(mongo)).Methods("PUT")
@@ -101,6 +107,7 @@ func TestDefaultsToBinaryMapping(t *testing.T) {
 	req, _ := http.NewRequest("PUT", "/methode/a-real-uuid", strings.NewReader(`{}`))
 
 	req.Header.Add("Content-Type", "application/a-fake-type")
+	req.Header.Add("X-Request-Id", testTxID)
 
 	router.ServeHTTP(w, req)
 	mongo.AssertExpectations(t)
@@ -120,8 +127,88 @@ func TestFailedJSON(t *testing.T) {
 	req, _ := http.NewRequest("PUT", "/methode/a-real-uuid", strings.NewReader(`i am not json`))
 
 	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Request-Id", testTxID)
 
 	router.ServeHTTP(w, req)
 	mongo.AssertExpectations(t)
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
+
+func TestConditionalWriteContent(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Write", "methode", &mapper.Resource{UUID: "a-real-uuid", Content: map[string]interface{}{}, ContentType: "application/json", TransactionID: testTxID}, db.Precondition{IfMatch: []int64{2}}, noValidator).
+		Run(func(args mock.Arguments) {
+			args.Get(1).(*mapper.Resource).Revision = 3
+			args.Get(1).(*mapper.Resource).Hash = "a-native-hash"
+		}).
+		Return(nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}", WriteContent(mongo)).Methods("PUT")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/methode/a-real-uuid", strings.NewReader(`{}`))
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Request-Id", testTxID)
+	req.Header.Add("If-Match", `"2"`)
+
+	router.ServeHTTP(w, req)
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+	assert.Equal(t, "a-native-hash", w.Header().Get("X-Native-Hash"))
+}
+
+func TestWriteContentPreconditionFailed(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Write", "methode", &mapper.Resource{UUID: "a-real-uuid", Content: map[string]interface{}{}, ContentType: "application/json", TransactionID: testTxID}, db.Precondition{IfNoneMatchAny: true}, noValidator).Return(db.ErrPreconditionFailed)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}", WriteContent(mongo)).Methods("PUT")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/methode/a-real-uuid", strings.NewReader(`{}`))
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Request-Id", testTxID)
+	req.Header.Add("If-None-Match", "*")
+
+	router.ServeHTTP(w, req)
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, "", w.Header().Get("ETag"))
+}
+
+func TestWriteContentStreamsBinaryContent(t *testing.T) {
+	mongo := new(MockDB)
+	connection := new(MockConnection)
+
+	mongo.On("Open").Return(connection, nil)
+	connection.On("Write", "methode", mock.MatchedBy(func(resource *mapper.Resource) bool {
+		_, streamed := resource.Content.(*mapper.Stream)
+		return streamed
+	}), db.Precondition{}, noValidator).Run(func(args mock.Arguments) {
+		data, err := args.Get(1).(*mapper.Resource).Content.(*mapper.Stream).Bytes()
+		assert.NoError(t, err)
+		assert.Equal(t, "some binary content", string(data))
+	}).Return(nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{collection}/{resource}", WriteContent(mongo)).Methods("PUT")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/methode/a-real-uuid", strings.NewReader("some binary content"))
+	req.Header.Add("Content-Type", "application/octet-stream")
+
+	router.ServeHTTP(w, req)
+
+	connection.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,89 @@
+// Package schema validates json documents against JSON Schema. The schemas are compiled and evaluated by
+// github.com/santhosh-tekuri/jsonschema, which implements the whole of draft 2020-12, the default, and the earlier drafts named
+// by $schema, and passes the official test suite; this package only confines the references to the schema itself, and reports
+// the violations as json pointers into the document. Formats are annotations only, as the draft has them by default.
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Draft is the draft of the schemas which don't name one with $schema
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// location identifies the schema being compiled, which its references are resolved against
+const location = "urn:nativerw:schema"
+
+// Schema is a compiled schema, safe for concurrent use
+type Schema struct {
+	compiled *jsonschema.Schema
+}
+
+// Violation is a value of the document which doesn't match the schema, located by a json pointer into the document
+type Violation struct {
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Pointer == "" {
+		return v.Message
+	}
+	return v.Pointer + ": " + v.Message
+}
+
+// Compile parses and compiles the schema. References to other documents fail the compilation, as the schema is all there is.
+func Compile(data []byte) (*Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("can't load %s, only references within the schema are supported", url)
+	}
+
+	if err := compiler.AddResource(location, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("invalid schema json: %v", err)
+	}
+
+	compiled, err := compiler.Compile(location)
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{compiled: compiled}, nil
+}
+
+// Validate returns the violations of the schema by the document, or none if it matches. The document is made of the values
+// decoded from json into interface{}; numbers of any go numeric type are accepted too. The violations are sorted by pointer.
+func (s *Schema) Validate(document interface{}) []Violation {
+	err := s.compiled.Validate(document)
+	if err == nil {
+		return nil
+	}
+
+	// values which can't come from json, like go structs, fail the whole document
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return []Violation{{Message: err.Error()}}
+	}
+
+	var violations []Violation
+	collectViolations(validationErr, &violations)
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Pointer < violations[j].Pointer })
+	return violations
+}
+
+// collectViolations keeps the leaves of the validation error, as the errors above them only say which schema they come from
+func collectViolations(err *jsonschema.ValidationError, violations *[]Violation) {
+	if len(err.Causes) == 0 {
+		*violations = append(*violations, Violation{Pointer: err.InstanceLocation, Message: err.Message})
+		return
+	}
+
+	for _, cause := range err.Causes {
+		collectViolations(cause, violations)
+	}
+}
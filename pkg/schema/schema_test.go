@@ -0,0 +1,145 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func document(t *testing.T, data string) interface{} {
+	var doc interface{}
+	require.NoError(t, json.Unmarshal([]byte(data), &doc))
+	return doc
+}
+
+const articleSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"required": ["uuid", "title"],
+	"properties": {
+		"uuid": {"type": "string", "pattern": "^[a-f0-9-]{36}$"},
+		"title": {"type": "string", "minLength": 1, "maxLength": 10},
+		"wordCount": {"type": "integer", "minimum": 0},
+		"rating": {"type": "number", "exclusiveMaximum": 5, "multipleOf": 0.5},
+		"status": {"enum": ["draft", "published"]},
+		"kind": {"const": "article"},
+		"byline": {"type": ["string", "null"]},
+		"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true, "maxItems": 3},
+		"point": {"type": "array", "prefixItems": [{"type": "number"}, {"type": "number"}], "items": false},
+		"authors": {"type": "array", "items": {"$ref": "#/$defs/author"}, "contains": {"required": ["lead"]}},
+		"links": {"type": "object", "propertyNames": {"pattern": "^[a-z]+$"}, "additionalProperties": {"type": "string"}}
+	},
+	"patternProperties": {"^x-": {"type": "string"}},
+	"additionalProperties": false,
+	"dependentRequired": {"rating": ["wordCount"]},
+	"$defs": {
+		"author": {
+			"type": "object",
+			"required": ["name"],
+			"properties": {"name": {"type": "string"}, "lead": {"const": true}, "co": {"$ref": "#/$defs/author"}}
+		}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	schema, err := Compile([]byte(articleSchema))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		doc        string
+		violations []Violation
+	}{
+		{"valid", `{"uuid":"9694733e-163a-4393-801f-000ab7de5041","title":"Title","wordCount":3,"rating":4.5,"status":"draft","kind":"article","byline":null,"tags":["a","b"],"point":[1,2],"authors":[{"name":"A","lead":true,"co":{"name":"B"}}],"links":{"home":"/"},"x-source":"methode"}`, nil},
+		{"missing required", `{}`, []Violation{{"", "missing properties: 'uuid', 'title'"}}},
+		{"wrong type", `[]`, []Violation{{"", "expected object, but got array"}}},
+		{"strings", `{"uuid":"not a uuid","title":""}`, []Violation{{"/title", "length must be >= 1, but got 0"}, {"/uuid", "does not match pattern '^[a-f0-9-]{36}$'"}}},
+		{"unicode", `{"uuid":"9694733e-163a-4393-801f-000ab7de5041","title":"Ünïcödé ok"}`, nil},
+		{"numbers", `{"uuid":"9694733e-163a-4393-801f-000ab7de5041","title":"T","wordCount":-1.5,"rating":5}`, []Violation{{"/rating", "must be < 5 but found 5"}, {"/wordCount", "expected integer, but got number"}}},
+		{"arrays", `{"uuid":"9694733e-163a-4393-801f-000ab7de5041","title":"T","tags":["a",1,"a","b"],"point":[1,"2",3]}`, []Violation{{"/point/1", "expected number, but got string"}, {"/point/2", "not allowed"}, {"/tags", "maximum 3 items required, but found 4 items"}, {"/tags", "items at index 0 and 2 are equal"}, {"/tags/1", "expected string, but got number"}}},
+		{"references", `{"uuid":"9694733e-163a-4393-801f-000ab7de5041","title":"T","authors":[{"name":"A","co":{"co":{}}}]}`, []Violation{{"/authors/0", "missing properties: 'lead'"}, {"/authors/0/co", "missing properties: 'name'"}, {"/authors/0/co/co", "missing properties: 'name'"}}},
+		{"additional properties", `{"uuid":"9694733e-163a-4393-801f-000ab7de5041","title":"T","body":"","x-source":1,"links":{"Home":1}}`, []Violation{{"", "additionalProperties 'body' not allowed"}, {"/links/Home", "does not match pattern '^[a-z]+$'"}, {"/links/Home", "expected string, but got number"}, {"/x-source", "expected string, but got number"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.violations, schema.Validate(document(t, test.doc)))
+		})
+	}
+}
+
+func TestValidateUnevaluated(t *testing.T) {
+	schema, err := Compile([]byte(`{
+		"allOf": [{"properties": {"title": {"type": "string"}}}],
+		"properties": {"body": {"type": "string"}},
+		"unevaluatedProperties": false
+	}`))
+	require.NoError(t, err)
+
+	assert.Empty(t, schema.Validate(document(t, `{"title":"Title","body":"Body"}`)))
+	assert.Equal(t, []Violation{{"/byline", "not allowed"}}, schema.Validate(document(t, `{"title":"Title","byline":"By"}`)))
+}
+
+func TestValidateGoNumbers(t *testing.T) {
+	schema, err := Compile([]byte(`{"properties": {"count": {"type": "integer", "enum": [1, 2]}}}`))
+	require.NoError(t, err)
+
+	assert.Empty(t, schema.Validate(map[string]interface{}{"count": int32(1)}))
+	assert.Empty(t, schema.Validate(map[string]interface{}{"count": int64(2)}))
+	assert.Len(t, schema.Validate(map[string]interface{}{"count": int64(3)}), 1)
+}
+
+func TestBooleanSchemas(t *testing.T) {
+	schema, err := Compile([]byte(`true`))
+	require.NoError(t, err)
+	assert.Empty(t, schema.Validate(document(t, `{"anything":[1]}`)))
+
+	schema, err = Compile([]byte(`false`))
+	require.NoError(t, err)
+	assert.Equal(t, []Violation{{"", "not allowed"}}, schema.Validate(document(t, `{}`)))
+}
+
+func TestReferences(t *testing.T) {
+	schema, err := Compile([]byte(`{
+		"$defs": {"a~b/c": {"type": "string"}, "named": {"$anchor": "name", "minLength": 2}},
+		"properties": {"escaped": {"$ref": "#/$defs/a~0b~1c"}, "anchored": {"$ref": "#name"}, "tree": {"$ref": "#"}}
+	}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []Violation{
+		{"/anchored", "length must be >= 2, but got 1"},
+		{"/escaped", "expected string, but got number"},
+		{"/tree/tree/escaped", "expected string, but got boolean"},
+	}, schema.Validate(document(t, `{"escaped":1,"anchored":"a","tree":{"tree":{"escaped":true}}}`)))
+}
+
+func TestCompileFails(t *testing.T) {
+	for _, schema := range []string{
+		`not json`,
+		`"a string"`,
+		`{"type": 1}`,
+		`{"type": ["string", "thing"]}`,
+		`{"minLength": -1}`,
+		`{"maxItems": 1.5}`,
+		`{"pattern": "["}`,
+		`{"items": [{"type": "string"}]}`,
+		`{"allOf": []}`,
+		`{"properties": {"a": 1}}`,
+		`{"$ref": "https://example.com/schema"}`,
+		`{"$ref": "file:///etc/hostname"}`,
+		`{"$ref": "#/$defs/missing"}`,
+		`{"$ref": "#unknown"}`,
+		`{"required": [1]}`,
+		`{"dependentRequired": {"a": "b"}}`,
+	} {
+		_, err := Compile([]byte(schema))
+		assert.Error(t, err, schema)
+	}
+}
+
+func TestViolationString(t *testing.T) {
+	assert.Equal(t, "/title: expected string, got number", Violation{"/title", "expected string, got number"}.String())
+	assert.Equal(t, `missing required property "title"`, Violation{"", `missing required property "title"`}.String())
+}